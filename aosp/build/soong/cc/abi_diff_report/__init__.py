@@ -0,0 +1,384 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Converts header-abi-diff reports to JSON and merges them.
+
+header-abi-diff writes the differences between the ABI of a library and its
+reference dump as a CompatibilityReport in the protobuf text format. The report
+of a library is converted to JSON that lists the added, removed and changed
+functions, variables, records, enums, vtables and ELF symbols, each with a
+severity.
+
+The reports of all libraries can be merged into a build-wide report grouped by
+the ABI class of the libraries, e.g. NDK, LLNDK, VNDK or PLATFORM.
+"""
+import argparse
+from dataclasses import dataclass, field
+import json
+from pathlib import Path
+import re
+from typing import Any, Dict, Iterable, List, Optional, TextIO, Tuple
+
+# Severities of changes, from the least to the most severe.
+SEVERITIES = ['compatible', 'unreferenced', 'extension', 'incompatible']
+
+# The classes that are always listed in a merged report. The VNDK-core,
+# VNDK-SP and their -ext classes are merged into VNDK.
+REPORT_CLASSES = ['NDK', 'LLNDK', 'VNDK', 'PLATFORM']
+
+KINDS = [
+    'functions', 'variables', 'records', 'enums', 'vtables', 'elf_symbols'
+]
+
+CHANGES = ['added', 'removed', 'changed']
+
+# Maps the repeated fields of a CompatibilityReport to the kind of the entries,
+# how they changed and the severity of the change.
+REPORT_FIELDS: Dict[str, Tuple[str, str, str]] = {
+    'functions_added': ('functions', 'added', 'extension'),
+    'functions_removed': ('functions', 'removed', 'incompatible'),
+    'function_diffs': ('functions', 'changed', 'incompatible'),
+    'global_vars_added': ('variables', 'added', 'extension'),
+    'global_vars_removed': ('variables', 'removed', 'incompatible'),
+    'global_var_diffs': ('variables', 'changed', 'incompatible'),
+    'record_type_diffs': ('records', 'changed', 'incompatible'),
+    'unreferenced_record_types_added': ('records', 'added', 'unreferenced'),
+    'unreferenced_record_types_removed':
+    ('records', 'removed', 'unreferenced'),
+    'unreferenced_record_type_diffs': ('records', 'changed', 'unreferenced'),
+    'enum_type_diffs': ('enums', 'changed', 'incompatible'),
+    'unreferenced_enum_types_added': ('enums', 'added', 'unreferenced'),
+    'unreferenced_enum_types_removed': ('enums', 'removed', 'unreferenced'),
+    'unreferenced_enum_type_diffs': ('enums', 'changed', 'unreferenced'),
+    'added_elf_functions': ('elf_symbols', 'added', 'extension'),
+    'removed_elf_functions': ('elf_symbols', 'removed', 'incompatible'),
+    'added_elf_objects': ('elf_symbols', 'added', 'extension'),
+    'removed_elf_objects': ('elf_symbols', 'removed', 'incompatible'),
+}
+
+# Maps CompatibilityStatus values to severities.
+STATUS_SEVERITIES = {
+    'COMPATIBLE': 'compatible',
+    'UNREFERENCED_CHANGES': 'unreferenced',
+    'EXTENSION': 'extension',
+    'INCOMPATIBLE': 'incompatible',
+    'ELF_INCOMPATIBLE': 'incompatible',
+}
+
+# Fields that name an entry, in the order they are preferred.
+NAME_FIELDS = ['name', 'function_name', 'linker_set_key']
+
+# Nested messages that hold the name of an entry if it has no name field.
+NAMED_MESSAGE_FIELDS = ['basic_abi', 'type_info', 'type_abi', 'new', 'old']
+
+TOKEN_RE = re.compile(r'''
+    \s+|\#[^\n]*|
+    (?P<string>"(?:[^"\\\n]|\\.)*"|'(?:[^'\\\n]|\\.)*')|
+    (?P<punct>[{}<>:;,\[\]])|
+    (?P<word>[^\s{}<>:;,\[\]"'\#]+)
+    ''', re.VERBOSE)
+
+PUNCTUATION = ['{', '}', '<', '>', ':', '[', ']']
+
+# A parsed message maps each field to its values, which are strings or
+# messages.
+TextProtoMessage = Dict[str, List[Any]]
+
+
+class ParseError(Exception):
+    """An error in the protobuf text format."""
+
+
+def tokenize(text: str) -> List[str]:
+    """Splits the protobuf text format into tokens.
+
+    Strings are unquoted and kept with a leading '"' to tell them apart from
+    other tokens.
+    """
+    tokens = []
+    pos = 0
+    while pos < len(text):
+        match = TOKEN_RE.match(text, pos)
+        if match is None:
+            raise ParseError(f'unexpected character at offset {pos}')
+        pos = match.end()
+        if match.group('string') is not None:
+            literal = match.group('string')[1:-1]
+            tokens.append('"' + literal.encode('latin-1', 'backslashreplace')
+                          .decode('unicode_escape'))
+        elif match.group('punct') is not None:
+            tokens.append(match.group('punct'))
+        elif match.group('word') is not None:
+            tokens.append(match.group('word'))
+    return tokens
+
+
+def parse_text_proto(text: str) -> TextProtoMessage:
+    """Parses a message in the protobuf text format.
+
+    Every field maps to the list of its values, which are strings for scalar
+    fields and dicts for message fields. Adjacent strings are concatenated.
+    """
+    tokens = tokenize(text)
+    pos = 0
+
+    def parse_message(end: Optional[str]) -> TextProtoMessage:
+        nonlocal pos
+        message: TextProtoMessage = {}
+        while pos < len(tokens):
+            token = tokens[pos]
+            if token == end:
+                pos += 1
+                return message
+            if token in (';', ','):
+                pos += 1
+                continue
+            if token.startswith('"') or token in PUNCTUATION:
+                raise ParseError(f'expected a field name, got {token!r}')
+            name = token
+            pos += 1
+            if pos < len(tokens) and tokens[pos] == ':':
+                pos += 1
+            if pos >= len(tokens):
+                raise ParseError(f'missing value of field {name}')
+            value: Any
+            if tokens[pos] in ('{', '<'):
+                pos += 1
+                value = parse_message('}' if tokens[pos - 1] == '{' else '>')
+            elif tokens[pos].startswith('"'):
+                value = ''
+                while pos < len(tokens) and tokens[pos].startswith('"'):
+                    value += tokens[pos][1:]
+                    pos += 1
+            else:
+                value = tokens[pos]
+                pos += 1
+            message.setdefault(name, []).append(value)
+        if end is not None:
+            raise ParseError(f'missing {end!r}')
+        return message
+
+    return parse_message(None)
+
+
+def scalar(message: TextProtoMessage, name: str) -> Optional[str]:
+    """Returns the last value of a scalar field, if it is set."""
+    values = [v for v in message.get(name, []) if isinstance(v, str)]
+    return values[-1] if values else None
+
+
+def entry_name(message: TextProtoMessage) -> str:
+    """Returns the name of a function, variable, type or symbol."""
+    for name in NAME_FIELDS:
+        value = scalar(message, name)
+        if value:
+            return value
+    for name in NAMED_MESSAGE_FIELDS:
+        for value in message.get(name, []):
+            if isinstance(value, dict):
+                nested = entry_name(value)
+                if nested:
+                    return nested
+    return ''
+
+
+def max_severity(severities: Iterable[str]) -> str:
+    """Returns the most severe of the severities."""
+    return max(severities, key=SEVERITIES.index, default='compatible')
+
+
+@dataclass
+class Change:
+    """A function, variable, type or symbol that changed."""
+    name: str
+    severity: str
+
+    def to_json(self) -> Dict[str, Any]:
+        """Returns the representation of the change in JSON."""
+        return {'name': self.name, 'severity': self.severity}
+
+
+@dataclass
+class Report:
+    """The ABI diff report of a library variant."""
+    library: str
+    arch: str
+    abi_class: str
+    status: str = 'COMPATIBLE'
+    changes: Dict[str, Dict[str, List[Change]]] = field(
+        default_factory=lambda: {k: {c: [] for c in CHANGES}
+                                 for k in KINDS})
+
+    @property
+    def severity(self) -> str:
+        """Returns the most severe change of the library."""
+        severities = [STATUS_SEVERITIES.get(self.status, 'incompatible')]
+        for changes in self.changes.values():
+            for entries in changes.values():
+                severities.extend(c.severity for c in entries)
+        return max_severity(severities)
+
+    def to_json(self) -> Dict[str, Any]:
+        """Returns the representation of the report in JSON."""
+        obj: Dict[str, Any] = {
+            'library': self.library,
+            'arch': self.arch,
+            'class': self.abi_class,
+            'status': self.status,
+            'severity': self.severity,
+        }
+        for kind in KINDS:
+            obj[kind] = {
+                change: [c.to_json() for c in entries]
+                for change, entries in self.changes[kind].items()
+            }
+        return obj
+
+    @staticmethod
+    def from_json(obj: Dict[str, Any]) -> 'Report':
+        """Reads a report written by to_json."""
+        report = Report(library=obj['library'],
+                        arch=obj['arch'],
+                        abi_class=obj.get('class', ''),
+                        status=obj.get('status', 'COMPATIBLE'))
+        for kind in KINDS:
+            for change in CHANGES:
+                report.changes[kind][change] = [
+                    Change(c['name'], c['severity'])
+                    for c in obj.get(kind, {}).get(change, [])
+                ]
+        return report
+
+
+def build_report(message: TextProtoMessage, library: str, arch: str,
+                 abi_class: str) -> Report:
+    """Builds the report of a library from a parsed CompatibilityReport."""
+    report = Report(library=scalar(message, 'lib_name') or library,
+                    arch=scalar(message, 'arch') or arch,
+                    abi_class=abi_class,
+                    status=scalar(message, 'compatibility_status')
+                    or 'COMPATIBLE')
+    for name, (kind, change, severity) in REPORT_FIELDS.items():
+        for entry in message.get(name, []):
+            if not isinstance(entry, dict):
+                continue
+            report.changes[kind][change].append(
+                Change(entry_name(entry), severity))
+            if kind == 'records' and 'vtable_layout_diff' in entry:
+                report.changes['vtables']['changed'].append(
+                    Change(entry_name(entry), severity))
+    for changes in report.changes.values():
+        for entries in changes.values():
+            entries.sort(key=lambda c: c.name)
+    return report
+
+
+def report_class(abi_class: str) -> str:
+    """Returns the class of a merged report that a library is listed in."""
+    if abi_class.startswith('VNDK'):
+        return 'VNDK'
+    return abi_class or 'PLATFORM'
+
+
+def write_merged_report(report_file: TextIO, reports: List[Report]) -> None:
+    """Writes the reports of all libraries grouped by their class.
+
+    The most severe reports of each class are listed first, and the number of
+    libraries with each severity is summarized.
+    """
+    classes: Dict[str, List[Report]] = {c: [] for c in REPORT_CLASSES}
+    for report in reports:
+        classes.setdefault(report_class(report.abi_class), []).append(report)
+
+    merged: Dict[str, Any] = {'classes': {}, 'summary': {}}
+    for abi_class, class_reports in classes.items():
+        class_reports.sort(key=lambda r: (-SEVERITIES.index(r.severity), r.
+                                          library, r.arch))
+        merged['classes'][abi_class] = [r.to_json() for r in class_reports]
+        summary = {s: 0 for s in SEVERITIES}
+        for report in class_reports:
+            summary[report.severity] += 1
+        merged['summary'][abi_class] = summary
+    json.dump(merged, report_file, indent=2, sort_keys=True)
+    report_file.write('\n')
+
+
+def expand_inputs(inputs: List[str]) -> List[Path]:
+    """Expands @file arguments to the whitespace separated list in file."""
+    paths = []
+    for arg in inputs:
+        if arg.startswith('@'):
+            with open(arg[1:]) as rsp_file:
+                paths.extend(Path(p) for p in rsp_file.read().split())
+        else:
+            paths.append(Path(arg))
+    return paths
+
+
+def parse_args() -> argparse.Namespace:
+    """Parses and returns command line arguments."""
+    parser = argparse.ArgumentParser()
+
+    parser.add_argument('--output',
+                        type=Path,
+                        required=True,
+                        help='Path to write the report to.')
+    parser.add_argument('--library',
+                        default='',
+                        help='Name of the library, if the diff has none.')
+    parser.add_argument('--arch',
+                        default='',
+                        help='Architecture of the library, if the diff has '
+                        'none.')
+    parser.add_argument('--class',
+                        dest='abi_class',
+                        default='',
+                        help='ABI class of the library, e.g. NDK or LLNDK.')
+    parser.add_argument(
+        '--merge',
+        action='store_true',
+        help='Merge the library reports given as inputs into one report.')
+    parser.add_argument('inputs',
+                        nargs='+',
+                        help='The .abidiff file of the library, or the '
+                        'reports to merge or @file lists of them.')
+
+    return parser.parse_args()
+
+
+def main() -> None:
+    """Program entry point."""
+    args = parse_args()
+
+    if args.merge:
+        reports = []
+        for path in expand_inputs(args.inputs):
+            with path.open() as report_file:
+                reports.append(Report.from_json(json.load(report_file)))
+        with args.output.open('w') as merged_file:
+            write_merged_report(merged_file, reports)
+        return
+
+    with open(args.inputs[0]) as diff_file:
+        message = parse_text_proto(diff_file.read())
+    report = build_report(message, args.library, args.arch, args.abi_class)
+    with args.output.open('w') as report_file:
+        json.dump(report.to_json(), report_file, indent=2, sort_keys=True)
+        report_file.write('\n')
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,146 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for abi_diff_report."""
+import io
+import json
+import textwrap
+import unittest
+
+import abi_diff_report
+from abi_diff_report import Change, Report
+
+# pylint: disable=missing-docstring
+
+
+class ParseTextProtoTest(unittest.TestCase):
+    def test_nested(self) -> None:
+        message = abi_diff_report.parse_text_proto(
+            textwrap.dedent("""\
+                lib_name: "libfoo"
+                # A comment.
+                compatibility_status: INCOMPATIBLE
+                record_type_diffs {
+                  name: "Foo"
+                  vtable_layout_diff < old_vtable { } >
+                }
+                record_type_diffs { name: "Bar\\"s" "Baz" }
+                """))
+        self.assertEqual(['libfoo'], message['lib_name'])
+        self.assertEqual(['INCOMPATIBLE'], message['compatibility_status'])
+        self.assertEqual([{
+            'name': ['Foo'],
+            'vtable_layout_diff': [{
+                'old_vtable': [{}]
+            }],
+        }, {
+            'name': ['Bar"sBaz']
+        }], message['record_type_diffs'])
+
+    def test_unbalanced(self) -> None:
+        with self.assertRaises(abi_diff_report.ParseError):
+            abi_diff_report.parse_text_proto('record_type_diffs { name: "a"')
+
+
+class BuildReportTest(unittest.TestCase):
+    def test_changes(self) -> None:
+        message = abi_diff_report.parse_text_proto(
+            textwrap.dedent("""\
+                lib_name: "libfoo"
+                arch: "arm64"
+                compatibility_status: INCOMPATIBLE
+                functions_removed {
+                  function_name: "foo"
+                  linker_set_key: "_Z3foov"
+                }
+                functions_added { linker_set_key: "_Z3barv" }
+                function_diffs { name: "_Z3bazv" }
+                record_type_diffs {
+                  name: "Foo"
+                  vtable_layout_diff { }
+                }
+                unreferenced_record_types_removed {
+                  type_info { name: "Unused" }
+                }
+                removed_elf_objects { name: "kFoo" }
+                """))
+        report = abi_diff_report.build_report(message, '', '', 'NDK')
+        self.assertEqual('libfoo', report.library)
+        self.assertEqual('arm64', report.arch)
+        self.assertEqual('incompatible', report.severity)
+        self.assertEqual([Change('foo', 'incompatible')],
+                         report.changes['functions']['removed'])
+        self.assertEqual([Change('_Z3barv', 'extension')],
+                         report.changes['functions']['added'])
+        self.assertEqual([Change('_Z3bazv', 'incompatible')],
+                         report.changes['functions']['changed'])
+        self.assertEqual([Change('Foo', 'incompatible')],
+                         report.changes['vtables']['changed'])
+        self.assertEqual([Change('Unused', 'unreferenced')],
+                         report.changes['records']['removed'])
+        self.assertEqual([Change('kFoo', 'incompatible')],
+                         report.changes['elf_symbols']['removed'])
+        self.assertEqual(report, Report.from_json(report.to_json()))
+
+    def test_extension(self) -> None:
+        message = abi_diff_report.parse_text_proto(
+            'compatibility_status: EXTENSION\n'
+            'added_elf_functions { name: "_Z3foov" }\n')
+        report = abi_diff_report.build_report(message, 'libfoo', 'x86',
+                                              'VNDK-core')
+        self.assertEqual('libfoo', report.library)
+        self.assertEqual('x86', report.arch)
+        self.assertEqual('extension', report.severity)
+
+    def test_empty(self) -> None:
+        report = abi_diff_report.build_report({}, 'libfoo', 'x86', 'LLNDK')
+        self.assertEqual('COMPATIBLE', report.status)
+        self.assertEqual('compatible', report.severity)
+
+
+class MergeTest(unittest.TestCase):
+    def test_classes(self) -> None:
+        compatible = Report('libfoo', 'arm64', 'VNDK-SP')
+        incompatible = Report('libbar', 'arm64', 'VNDK-core',
+                              'INCOMPATIBLE')
+        host = Report('libhost', 'x86_64', 'HOST')
+        merged_file = io.StringIO()
+        abi_diff_report.write_merged_report(merged_file,
+                                            [compatible, host, incompatible])
+        merged_file.seek(0)
+        merged = json.load(merged_file)
+        self.assertEqual(['HOST', 'LLNDK', 'NDK', 'PLATFORM', 'VNDK'],
+                         sorted(merged['classes']))
+        self.assertEqual(
+            [incompatible, compatible],
+            [Report.from_json(r) for r in merged['classes']['VNDK']])
+        self.assertEqual(
+            {
+                'compatible': 1,
+                'unreferenced': 0,
+                'extension': 0,
+                'incompatible': 1,
+            }, merged['summary']['VNDK'])
+        self.assertEqual([], merged['classes']['NDK'])
+
+
+def main() -> None:
+    suite = unittest.TestLoader().loadTestsFromName(__name__)
+    unittest.TextTestRunner(verbosity=3).run(suite)
+
+
+if __name__ == '__main__':
+    main()
@@ -54,6 +54,10 @@ type BinaryLinkerProperties struct {
 
 	// Inject boringssl hash into the shared library.  This is only intended for use by external/boringssl.
 	Inject_bssl_hash *bool `android:"arch_variant"`
+
+	// Version information, icon and application manifest to compile into the binary as
+	// resources. Only used when building for Windows.
+	Windows_resources WindowsResourcesProperties `android:"arch_variant"`
 }
 
 func init() {
@@ -86,6 +90,7 @@ type binaryDecorator struct {
 	*baseLinker
 	*baseInstaller
 	stripper Stripper
+	bolt     Bolt
 
 	Properties BinaryLinkerProperties
 
@@ -94,6 +99,9 @@ type binaryDecorator struct {
 	// Location of the linked, unstripped binary
 	unstrippedOutputFile android.Path
 
+	// Location of the CodeView debug info of Windows binaries
+	pdbFile android.OptionalPath
+
 	// Names of symlinks to be installed for use in LOCAL_MODULE_SYMLINKS
 	symlinks []string
 
@@ -119,7 +127,8 @@ var _ linker = (*binaryDecorator)(nil)
 func (binary *binaryDecorator) linkerProps() []interface{} {
 	return append(binary.baseLinker.linkerProps(),
 		&binary.Properties,
-		&binary.stripper.StripProperties)
+		&binary.stripper.StripProperties,
+		&binary.bolt.BoltProperties)
 
 }
 
@@ -258,6 +267,9 @@ func (binary *binaryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Flags
 		flags.Global.CFlags = append(flags.Global.CFlags, "-fPIE")
 	}
 
+	flags = binary.stripper.pdbFlags(ctx, flags)
+	flags = binary.bolt.flags(ctx, flags)
+
 	if ctx.toolchain().Bionic() {
 		if binary.static() {
 			// Clang driver needs -static to create static executable.
@@ -343,6 +355,14 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 		flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--no-dynamic-linker")
 	}
 
+	var implicitOutputs android.WritablePaths
+	if binary.stripper.NeedsPdb(ctx) {
+		var pdbFile android.ModuleOutPath
+		pdbFile, flags = binary.stripper.pdbFile(ctx, fileName, flags)
+		implicitOutputs = append(implicitOutputs, pdbFile)
+		binary.pdbFile = android.OptionalPathForPath(pdbFile)
+	}
+
 	builderFlags := flagsToBuilderFlags(flags)
 	stripFlags := flagsToStripFlags(flags)
 	if binary.stripper.NeedsStrip(ctx) {
@@ -356,6 +376,12 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 
 	binary.unstrippedOutputFile = outputFile
 
+	if binary.bolt.enabled(ctx) {
+		boltedOutputFile := outputFile
+		outputFile = android.PathForModuleOut(ctx, "unbolted", fileName)
+		binary.bolt.optimize(ctx, outputFile, boltedOutputFile, binary.Properties.Inject_bssl_hash)
+	}
+
 	if String(binary.Properties.Prefix_symbols) != "" {
 		afterPrefixSymbols := outputFile
 		outputFile = android.PathForModuleOut(ctx, "unprefixed", fileName)
@@ -415,10 +441,18 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, objs.tidyFiles...)
 	linkerDeps = append(linkerDeps, flags.LdFlagsDeps...)
 
+	objFiles := objs.objFiles
+	if ctx.Windows() && !binary.Properties.Windows_resources.empty() {
+		objFiles = append(objFiles, binary.compileWindowsResources(ctx, flags, fileName))
+	}
+	if defaultOptions := binary.baseLinker.sanitize.compileDefaultOptions(ctx, builderFlags); defaultOptions.Valid() {
+		objFiles = append(objFiles, defaultOptions.Path())
+	}
+
 	// Register link action.
-	transformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs, deps.StaticLibs,
+	transformObjToDynamicBinary(ctx, objFiles, sharedLibs, deps.StaticLibs,
 		deps.LateStaticLibs, deps.WholeStaticLibs, linkerDeps, deps.CrtBegin, deps.CrtEnd, true,
-		builderFlags, outputFile, nil, validations)
+		builderFlags, outputFile, implicitOutputs, validations)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
@@ -446,10 +480,35 @@ func (binary *binaryDecorator) link(ctx ModuleContext,
 	return ret
 }
 
+// compileWindowsResources generates the .rc file for the windows_resources properties and
+// compiles it with windres into an object to link into the binary.
+func (binary *binaryDecorator) compileWindowsResources(ctx ModuleContext, flags Flags, fileName string) android.Path {
+	rcFile, rcDeps := genWinResources(ctx, &binary.Properties.Windows_resources, fileName)
+	objFile := android.PathForModuleObj(ctx, "windres", fileName+".res.o")
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        windres,
+		Description: "windres " + fileName,
+		Output:      objFile,
+		Input:       rcFile,
+		Implicits:   rcDeps,
+		Args: map[string]string{
+			"windresCmd": mingwCmd(flags.Toolchain, "windres"),
+			"flags":      flags.Toolchain.WindresFlags(),
+		},
+	})
+
+	return objFile
+}
+
 func (binary *binaryDecorator) unstrippedOutputFilePath() android.Path {
 	return binary.unstrippedOutputFile
 }
 
+func (binary *binaryDecorator) pdbFilePath() android.OptionalPath {
+	return binary.pdbFile
+}
+
 func (binary *binaryDecorator) symlinkList() []string {
 	return binary.symlinks
 }
@@ -491,7 +550,14 @@ func (binary *binaryDecorator) install(ctx ModuleContext, file android.Path) {
 		}
 		binary.baseInstaller.subDir = "bootstrap"
 	}
+	// Windows binaries with ASan are installed to their own directory, next to the ASan runtime
+	// DLL, as binaries that share a directory would all install it.
+	if binary.baseLinker.sanitize.needsWindowsRuntime(ctx) {
+		binary.baseInstaller.relative = ctx.ModuleName()
+	}
 	binary.baseInstaller.install(ctx, file)
+	binary.baseLinker.sanitize.installSuppressions(ctx, binary.baseInstaller.installDir(ctx))
+	binary.baseLinker.sanitize.installWindowsRuntime(ctx, binary.baseInstaller.installDir(ctx))
 
 	var preferredArchSymlinkPath android.OptionalPath
 	for _, symlink := range binary.symlinks {
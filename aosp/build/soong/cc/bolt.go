@@ -0,0 +1,138 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+
+	"github.com/google/blueprint"
+
+	"android/soong/android"
+)
+
+// BOLT (Binary Optimization and Layout Tool) rewrites a linked binary to lay out its code according
+// to a profile of the binary, which reduces instruction cache and TLB misses of large programs.
+// It runs on the unstripped output of the linker, before stripping.
+
+var (
+	// Default llvm-bolt optimizations, see llvm-bolt --help.
+	boltDefaultFlags = []string{
+		"-reorder-blocks=ext-tsp",
+		"-reorder-functions=hfsort",
+		"-split-functions",
+		"-split-all-cold",
+		"-split-eh",
+		"-dyno-stats",
+		"-icf=1",
+		"-use-gnu-stack",
+	}
+
+	boltOptimize = pctx.AndroidStaticRule("boltOptimize",
+		blueprint.RuleParams{
+			Command:     "${config.ClangBin}/llvm-bolt $in -o $out -data=$profile $boltFlags",
+			CommandDeps: []string{"${config.ClangBin}/llvm-bolt"},
+		}, "profile", "boltFlags")
+
+	// perf2bolt converts a perf.data file recorded with branch sampling, e.g.
+	// perf record -e cycles:u -j any,u, to the fdata format that llvm-bolt reads.
+	boltPerf2bolt = pctx.AndroidStaticRule("boltPerf2bolt",
+		blueprint.RuleParams{
+			Command:     "${config.ClangBin}/perf2bolt -p $perfData -o $out $in",
+			CommandDeps: []string{"${config.ClangBin}/perf2bolt"},
+		}, "perfData")
+)
+
+// BoltProperties defines the post-link optimization of a binary or shared library with llvm-bolt.
+type BoltProperties struct {
+	Bolt struct {
+		// profile of the module to optimize the layout of its code with, either in the fdata
+		// format written by perf2bolt or by a binary instrumented with llvm-bolt -instrument, or
+		// a perf.data file recorded with branch sampling that is converted with perf2bolt.
+		// Only supported for x86_64 Linux host modules.
+		Profile *string `android:"path,arch_variant"`
+
+		// enabled can be set to false to skip BOLT, e.g. for one architecture. Defaults to true
+		// if profile is set.
+		Enabled *bool `android:"arch_variant"`
+
+		// flags are passed to llvm-bolt instead of the default optimizations.
+		Flags []string `android:"arch_variant"`
+	} `android:"arch_variant"`
+}
+
+// Bolt defines the BOLT actions and properties for a module.
+type Bolt struct {
+	BoltProperties BoltProperties
+}
+
+// enabled returns true if the linked output of the module should be optimized with llvm-bolt.
+func (bolt *Bolt) enabled(ctx BaseModuleContext) bool {
+	props := bolt.BoltProperties.Bolt
+	return props.Profile != nil && BoolDefault(props.Enabled, true) &&
+		ctx.Host() && ctx.Os().Linux() && ctx.Arch().ArchType == android.X86_64
+}
+
+// flags adds the linker flags llvm-bolt needs to rewrite the output. llvm-bolt can only move
+// functions around if the linker keeps the relocations in the output.
+func (bolt *Bolt) flags(ctx ModuleContext, flags Flags) Flags {
+	if bolt.enabled(ctx) {
+		flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--emit-relocs")
+	}
+	return flags
+}
+
+// optimize registers the actions to optimize the linked output in inputFile into outputFile.
+func (bolt *Bolt) optimize(ctx ModuleContext, inputFile android.Path, outputFile android.WritablePath,
+	injectBsslHash *bool) {
+
+	// llvm-bolt runs after the BoringSSL hash is injected, and it moves the code that the hash
+	// covers, also when the hash is injected for a static library dependency.
+	if injectsBoringSSLHash(ctx, injectBsslHash) {
+		ctx.PropertyErrorf("bolt.profile", "can't be used with inject_bssl_hash")
+	}
+
+	profile := android.PathForModuleSrc(ctx, *bolt.BoltProperties.Bolt.Profile)
+	if !strings.HasSuffix(profile.Base(), ".fdata") {
+		fdata := android.PathForModuleOut(ctx, "bolt", outputFile.Base()+".fdata")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        boltPerf2bolt,
+			Description: "perf2bolt " + outputFile.Base(),
+			Output:      fdata,
+			Input:       inputFile,
+			Implicit:    profile,
+			Args: map[string]string{
+				"perfData": profile.String(),
+			},
+		})
+		profile = fdata
+	}
+
+	boltFlags := boltDefaultFlags
+	if len(bolt.BoltProperties.Bolt.Flags) > 0 {
+		boltFlags = bolt.BoltProperties.Bolt.Flags
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        boltOptimize,
+		Description: "bolt " + outputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Implicit:    profile,
+		Args: map[string]string{
+			"profile":   profile.String(),
+			"boltFlags": strings.Join(boltFlags, " "),
+		},
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+var prepareForBoltTest = android.GroupFixturePreparers(
+	prepareForCcTest,
+	android.MockFS{
+		"foo.fdata":   nil,
+		"libfoo.perf": nil,
+	}.AddToFixture(),
+)
+
+func TestBolt(t *testing.T) {
+	result := prepareForBoltTest.RunTestWithBp(t, `
+		cc_binary {
+			name: "foo",
+			host_supported: true,
+			srcs: ["foo.c"],
+			bolt: {
+				profile: "foo.fdata",
+			},
+		}
+	`)
+
+	host := result.Config.BuildOSTarget.String()
+	foo := result.ModuleForTests("foo", host)
+
+	// llvm-bolt needs the relocations to move functions around.
+	ld := foo.Rule("ld")
+	android.AssertStringListContains(t, "ldflags", strings.Fields(ld.Args["ldFlags"]),
+		"-Wl,--emit-relocs")
+
+	// llvm-bolt optimizes the output of the linker into the unstripped binary.
+	bolt := foo.Description("bolt foo")
+	android.AssertStringEquals(t, "bolt input", ld.Output.String(), bolt.Input.String())
+	android.AssertStringDoesContain(t, "unbolted output", ld.Output.String(), "/unbolted/")
+	android.AssertStringEquals(t, "bolt output",
+		foo.Module().(*Module).UnstrippedOutputFile().String(), bolt.Output.String())
+	android.AssertStringEquals(t, "profile", "foo.fdata", bolt.Args["profile"])
+	android.AssertStringDoesContain(t, "bolt flags", bolt.Args["boltFlags"], "-reorder-blocks=ext-tsp")
+	if foo.MaybeDescription("perf2bolt foo").Rule != nil {
+		t.Errorf("expected no perf2bolt rule for an fdata profile")
+	}
+
+	// Only x86_64 Linux host modules are optimized.
+	device := result.ModuleForTests("foo", "android_arm64_armv8-a")
+	android.AssertStringListDoesNotContain(t, "device ldflags",
+		strings.Fields(device.Rule("ld").Args["ldFlags"]), "-Wl,--emit-relocs")
+	if device.MaybeDescription("bolt foo").Rule != nil {
+		t.Errorf("expected no bolt rule for the device variant")
+	}
+}
+
+func TestBoltPerfData(t *testing.T) {
+	result := prepareForBoltTest.RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+			bolt: {
+				profile: "libfoo.perf",
+				flags: ["-reorder-blocks=cache+"],
+			},
+		}
+	`)
+
+	libfoo := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared")
+	ld := libfoo.Rule("ld")
+	android.AssertStringListContains(t, "ldflags", strings.Fields(ld.Args["ldFlags"]),
+		"-Wl,--emit-relocs")
+
+	// The perf.data profile is converted with perf2bolt, which reads the unbolted library.
+	perf2bolt := libfoo.Description("perf2bolt libfoo.so")
+	android.AssertStringEquals(t, "perf2bolt input", ld.Output.String(), perf2bolt.Input.String())
+	android.AssertStringEquals(t, "perf data", "libfoo.perf", perf2bolt.Args["perfData"])
+
+	bolt := libfoo.Description("bolt libfoo.so")
+	android.AssertStringEquals(t, "bolt input", ld.Output.String(), bolt.Input.String())
+	android.AssertStringEquals(t, "profile", perf2bolt.Output.String(), bolt.Args["profile"])
+	android.AssertStringEquals(t, "bolt flags", "-reorder-blocks=cache+", bolt.Args["boltFlags"])
+}
+
+func TestBoltInjectBsslHash(t *testing.T) {
+	const bp = `
+		cc_library_static {
+			name: "libcrypto_static",
+			host_supported: true,
+			srcs: ["libcrypto.c"],
+			inject_bssl_hash: true,
+		}
+
+		cc_binary {
+			name: "foo",
+			host_supported: true,
+			srcs: ["foo.c"],
+			bolt: {
+				profile: "foo.fdata",
+			},
+			%s
+		}
+	`
+
+	// llvm-bolt runs after the BoringSSL hash is injected, which would no longer match the
+	// code it covers.
+	for _, tc := range []struct{ name, props string }{
+		{"own property", "inject_bssl_hash: true,"},
+		{"static dependency", `static_libs: ["libcrypto_static"],`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prepareForBoltTest.
+				ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+					`bolt\.profile: can't be used with inject_bssl_hash`)).
+				RunTestWithBp(t, fmt.Sprintf(bp, tc.props))
+		})
+	}
+
+	// Without bolt the hash is injected into the output of the linker.
+	result := prepareForBoltTest.RunTestWithBp(t, fmt.Sprintf(bp, `
+			static_libs: ["libcrypto_static"],
+			target: {
+				host: {
+					bolt: {
+						enabled: false,
+					},
+				},
+			},`))
+	foo := result.ModuleForTests("foo", result.Config.BuildOSTarget.String())
+	hash := foo.Description("inject crypto hash")
+	android.AssertStringDoesContain(t, "hash command", hash.RuleParams.Command,
+		"-in-object "+foo.Rule("ld").Output.String())
+	if foo.MaybeDescription("bolt foo").Rule != nil {
+		t.Errorf("expected no bolt rule when bolt is disabled")
+	}
+}
@@ -19,6 +19,7 @@ package cc
 // functions.
 
 import (
+	"fmt"
 	"path/filepath"
 	"runtime"
 	"strings"
@@ -152,6 +153,14 @@ var (
 			CommandDeps: []string{"${config.MacStripPath}"},
 		})
 
+	// Rule to strip Windows executables and DLLs.
+	windowsStrip = pctx.AndroidStaticRule("windowsStrip",
+		blueprint.RuleParams{
+			Command:     "${config.ClangBin}/llvm-strip $args -o $out $in",
+			CommandDeps: []string{"${config.ClangBin}/llvm-strip"},
+		},
+		"args")
+
 	// b/132822437: objcopy uses a file descriptor per .o file when called on .a files, which runs the system out of
 	// file descriptors on darwin.  Limit concurrent calls to 5 on darwin.
 	darwinStripPool = func() blueprint.Pool {
@@ -195,10 +204,15 @@ var (
 		},
 		"clangBin", "format")
 
-	// Rule for invoking clang-tidy (a clang-based linter).
+	// Rule for invoking clang-tidy (a clang-based linter). For modules that export their fixes,
+	// exportFixes is -export-fixes=${out}.yaml, and the fixes and diagnostics are exported there
+	// for the tidy reports. clang-tidy only writes the file if there are any, so it is touched
+	// like $out.
 	clangTidy, clangTidyRE = pctx.RemoteStaticRules("clangTidy",
 		blueprint.RuleParams{
-			Command:     "rm -f $out && $reTemplate${config.ClangBin}/clang-tidy $tidyFlags $in -- $cFlags && touch $out",
+			Command: "rm -f $out ${out}.yaml && " +
+				"$reTemplate${config.ClangBin}/clang-tidy $tidyFlags $exportFixes $in -- $cFlags && " +
+				"touch ${out}.yaml $out",
 			CommandDeps: []string{"${config.ClangBin}/clang-tidy"},
 		},
 		&remoteexec.REParams{
@@ -208,9 +222,9 @@ var (
 			// OutputFile here is $in for remote-execution since its possible that
 			// clang-tidy modifies the given input file itself and $out refers to the
 			// ".tidy" file generated for ninja-dependency reasons.
-			OutputFiles: []string{"$in"},
+			OutputFiles: []string{"$in", "${out}.yaml"},
 			Platform:    map[string]string{remoteexec.PoolKey: "${config.REClangTidyPool}"},
-		}, []string{"cFlags", "tidyFlags"}, []string{})
+		}, []string{"cFlags", "tidyFlags", "exportFixes"}, []string{})
 
 	_ = pctx.SourcePathVariable("yasmCmd", "prebuilts/misc/${config.HostPrebuiltTag}/yasm/yasm")
 
@@ -271,19 +285,45 @@ var (
 
 	_ = pctx.SourcePathVariable("sAbiDiffer", "prebuilts/clang-tools/${config.HostPrebuiltTag}/bin/header-abi-diff")
 
-	// Rule to compare linked sAbi dump files (.ldump).
+	_ = pctx.HostBinToolVariable("abiDiffReportCmd", "abi_diff_report")
+
+	// Rule to compare linked sAbi dump files (.ldump). An incompatible ABI doesn't fail the
+	// diff, the exit status of header-abi-diff is written to $statusFile and checked by
+	// sAbiDiffCheck, so that the diff can still be reported.
 	sAbiDiff = pctx.RuleFunc("sAbiDiff",
 		func(ctx android.PackageRuleContext) blueprint.RuleParams {
-			commandStr := "($sAbiDiffer ${extraFlags} -lib ${libName} -arch ${arch} -o ${out} -new ${in} -old ${referenceDump})"
-			commandStr += "|| (echo 'error: Please update ABI references with: $$ANDROID_BUILD_TOP/development/vndk/tools/header-checker/utils/create_reference_dumps.py ${createReferenceDumpFlags} -l ${libName}'"
-			commandStr += " && (mkdir -p $$DIST_DIR/abidiffs && cp ${out} $$DIST_DIR/abidiffs/)"
-			commandStr += " && exit 1)"
+			commandStr := "rm -f ${out} ${statusFile}"
+			commandStr += " && ($sAbiDiffer ${extraFlags} -lib ${libName} -arch ${arch} -o ${out} -new ${in} -old ${referenceDump})"
+			commandStr += "; status=$$?"
+			commandStr += "; if [ ! -f ${out} ]; then exit $$status; fi"
+			commandStr += "; echo $$status > ${statusFile}"
 			return blueprint.RuleParams{
 				Command:     commandStr,
 				CommandDeps: []string{"$sAbiDiffer"},
 			}
 		},
-		"extraFlags", "referenceDump", "libName", "arch", "createReferenceDumpFlags")
+		"extraFlags", "referenceDump", "libName", "arch", "statusFile")
+
+	// Rule to fail the build if header-abi-diff found ABI changes that aren't allowed. $hint tells
+	// how to update the reference dump.
+	sAbiDiffCheck = pctx.AndroidStaticRule("sAbiDiffCheck",
+		blueprint.RuleParams{
+			Command: "if [ \"$$(cat ${statusFile})\" != 0 ]; then " +
+				"echo 'error: Please update ABI references with: ${hint}'" +
+				" && (mkdir -p $$DIST_DIR/abidiffs && cp ${in} $$DIST_DIR/abidiffs/)" +
+				" && exit 1; fi" +
+				" && touch ${out}",
+		},
+		"statusFile", "hint")
+
+	// Rule to convert a diff of sAbi dump files to a JSON report, whether or not the ABI is
+	// compatible.
+	sAbiDiffReport = pctx.AndroidStaticRule("sAbiDiffReport",
+		blueprint.RuleParams{
+			Command:     "$abiDiffReportCmd --library ${libName} --arch ${arch} --class '${abiClass}' --output ${out} ${in}",
+			CommandDeps: []string{"$abiDiffReportCmd"},
+		},
+		"libName", "arch", "abiClass")
 
 	// Rule to unzip a reference abi dump.
 	unzipRefSAbiDump = pctx.AndroidStaticRule("unzipRefSAbiDump",
@@ -374,11 +414,17 @@ type builderFlags struct {
 	rsFlags       string // Flags that apply to renderscript source files
 	toolchain     config.Toolchain
 
+	coverageFlags       string   // Flags that apply to source files instrumented for coverage
+	coverageSrcs        []string // Globs of the source files to instrument, all if empty
+	coverageExcludeSrcs []string // Globs of the source files not to instrument
+
 	// True if these extra features are enabled.
-	tidy         bool
-	gcovCoverage bool
-	sAbiDump     bool
-	emitXrefs    bool
+	tidy               bool
+	tidyExportFixes    bool
+	gcovCoverage       bool
+	sAbiDump           bool
+	emitXrefs          bool
+	distributedThinLTO bool
 
 	assemblerWithCpp bool // True if .s files should be processed with the c preprocessor.
 
@@ -408,6 +454,7 @@ type StripFlags struct {
 type Objects struct {
 	objFiles      android.Paths
 	tidyFiles     android.Paths
+	tidyYamlFiles android.Paths
 	coverageFiles android.Paths
 	sAbiDumpFiles android.Paths
 	kytheFiles    android.Paths
@@ -417,6 +464,7 @@ func (a Objects) Copy() Objects {
 	return Objects{
 		objFiles:      append(android.Paths{}, a.objFiles...),
 		tidyFiles:     append(android.Paths{}, a.tidyFiles...),
+		tidyYamlFiles: append(android.Paths{}, a.tidyYamlFiles...),
 		coverageFiles: append(android.Paths{}, a.coverageFiles...),
 		sAbiDumpFiles: append(android.Paths{}, a.sAbiDumpFiles...),
 		kytheFiles:    append(android.Paths{}, a.kytheFiles...),
@@ -427,37 +475,30 @@ func (a Objects) Append(b Objects) Objects {
 	return Objects{
 		objFiles:      append(a.objFiles, b.objFiles...),
 		tidyFiles:     append(a.tidyFiles, b.tidyFiles...),
+		tidyYamlFiles: append(a.tidyYamlFiles, b.tidyYamlFiles...),
 		coverageFiles: append(a.coverageFiles, b.coverageFiles...),
 		sAbiDumpFiles: append(a.sAbiDumpFiles, b.sAbiDumpFiles...),
 		kytheFiles:    append(a.kytheFiles, b.kytheFiles...),
 	}
 }
 
-// Generate rules for compiling multiple .c, .cpp, or .S files to individual .o files
-func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles android.Paths,
-	flags builderFlags, pathDeps android.Paths, cFlagsDeps android.Paths) Objects {
-
-	// Source files are one-to-one with tidy, coverage, or kythe files, if enabled.
-	objFiles := make(android.Paths, len(srcFiles))
-	var tidyFiles android.Paths
-	if flags.tidy {
-		tidyFiles = make(android.Paths, 0, len(srcFiles))
-	}
-	var coverageFiles android.Paths
-	if flags.gcovCoverage {
-		coverageFiles = make(android.Paths, 0, len(srcFiles))
-	}
-	var kytheFiles android.Paths
-	if flags.emitXrefs {
-		kytheFiles = make(android.Paths, 0, len(srcFiles))
-	}
+// compileFlags holds the fully expanded flags for use by C tools, C compiles, C++ tools, C++
+// compiles, and asm compiles respectively.
+type compileFlags struct {
+	toolingCflags   string
+	cflags          string
+	toolingCppflags string
+	cppflags        string
+	asflags         string
+}
 
-	// Produce fully expanded flags for use by C tools, C compiles, C++ tools, C++ compiles, and asm compiles
-	// respectively.
+// expandCompileFlags expands flags into compileFlags, with localCommonFlags in place of
+// flags.localCommonFlags.
+func expandCompileFlags(flags builderFlags, localCommonFlags string) compileFlags {
 	toolingCflags := flags.globalCommonFlags + " " +
 		flags.globalToolingCFlags + " " +
 		flags.globalConlyFlags + " " +
-		flags.localCommonFlags + " " +
+		localCommonFlags + " " +
 		flags.localToolingCFlags + " " +
 		flags.localConlyFlags + " " +
 		flags.systemIncludeFlags
@@ -465,7 +506,7 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 	cflags := flags.globalCommonFlags + " " +
 		flags.globalCFlags + " " +
 		flags.globalConlyFlags + " " +
-		flags.localCommonFlags + " " +
+		localCommonFlags + " " +
 		flags.localCFlags + " " +
 		flags.localConlyFlags + " " +
 		flags.systemIncludeFlags
@@ -473,7 +514,7 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 	toolingCppflags := flags.globalCommonFlags + " " +
 		flags.globalToolingCFlags + " " +
 		flags.globalToolingCppFlags + " " +
-		flags.localCommonFlags + " " +
+		localCommonFlags + " " +
 		flags.localToolingCFlags + " " +
 		flags.localToolingCppFlags + " " +
 		flags.systemIncludeFlags
@@ -481,26 +522,58 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 	cppflags := flags.globalCommonFlags + " " +
 		flags.globalCFlags + " " +
 		flags.globalCppFlags + " " +
-		flags.localCommonFlags + " " +
+		localCommonFlags + " " +
 		flags.localCFlags + " " +
 		flags.localCppFlags + " " +
 		flags.systemIncludeFlags
 
 	asflags := flags.globalCommonFlags + " " +
 		flags.globalAsFlags + " " +
-		flags.localCommonFlags + " " +
+		localCommonFlags + " " +
 		flags.localAsFlags + " " +
 		flags.systemIncludeFlags
 
+	return compileFlags{
+		toolingCflags:   toolingCflags + " ${config.NoOverrideGlobalCflags}",
+		cflags:          cflags + " ${config.NoOverrideGlobalCflags}",
+		toolingCppflags: toolingCppflags + " ${config.NoOverrideGlobalCflags}",
+		cppflags:        cppflags + " ${config.NoOverrideGlobalCflags}",
+		asflags:         asflags,
+	}
+}
+
+// Generate rules for compiling multiple .c, .cpp, or .S files to individual .o files
+func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles android.Paths,
+	flags builderFlags, pathDeps android.Paths, cFlagsDeps android.Paths) Objects {
+
+	// Source files are one-to-one with tidy, coverage, or kythe files, if enabled.
+	objFiles := make(android.Paths, len(srcFiles))
+	var tidyFiles, tidyYamlFiles android.Paths
+	if flags.tidy {
+		tidyFiles = make(android.Paths, 0, len(srcFiles))
+		tidyYamlFiles = make(android.Paths, 0, len(srcFiles))
+	}
+	var coverageFiles android.Paths
+	if flags.gcovCoverage {
+		coverageFiles = make(android.Paths, 0, len(srcFiles))
+	}
+	var kytheFiles android.Paths
+	if flags.emitXrefs {
+		kytheFiles = make(android.Paths, 0, len(srcFiles))
+	}
+
 	var sAbiDumpFiles android.Paths
 	if flags.sAbiDump {
 		sAbiDumpFiles = make(android.Paths, 0, len(srcFiles))
 	}
 
-	cflags += " ${config.NoOverrideGlobalCflags}"
-	toolingCflags += " ${config.NoOverrideGlobalCflags}"
-	cppflags += " ${config.NoOverrideGlobalCflags}"
-	toolingCppflags += " ${config.NoOverrideGlobalCflags}"
+	// Sources that are instrumented for coverage get the coverage flags after the module's common
+	// flags.
+	srcFlags := expandCompileFlags(flags, flags.localCommonFlags)
+	coverageSrcFlags := srcFlags
+	if flags.coverageFlags != "" {
+		coverageSrcFlags = expandCompileFlags(flags, flags.localCommonFlags+" "+flags.coverageFlags)
+	}
 
 	for i, srcFile := range srcFiles {
 		objFile := android.ObjPathWithExt(ctx, subdir, srcFile, "o")
@@ -543,6 +616,7 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 
 		var moduleFlags string
 		var moduleToolingFlags string
+		fileFlags := srcFlags
 
 		var ccCmd string
 		tidy := flags.tidy
@@ -551,6 +625,18 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 		rule := cc
 		emitXref := flags.emitXrefs
 
+		if flags.coverageFlags != "" {
+			instrument, err := coverageIncludesSrc(srcFile.Rel(), flags.coverageSrcs, flags.coverageExcludeSrcs)
+			if err != nil {
+				ctx.ModuleErrorf("invalid native coverage glob: %s", err)
+			}
+			if instrument {
+				fileFlags = coverageSrcFlags
+			} else {
+				coverage = false
+			}
+		}
+
 		switch srcFile.Ext() {
 		case ".s":
 			if !flags.assemblerWithCpp {
@@ -559,19 +645,19 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 			fallthrough
 		case ".S":
 			ccCmd = "clang"
-			moduleFlags = asflags
+			moduleFlags = fileFlags.asflags
 			tidy = false
 			coverage = false
 			dump = false
 			emitXref = false
 		case ".c":
 			ccCmd = "clang"
-			moduleFlags = cflags
-			moduleToolingFlags = toolingCflags
+			moduleFlags = fileFlags.cflags
+			moduleToolingFlags = fileFlags.toolingCflags
 		case ".cpp", ".cc", ".cxx", ".mm":
 			ccCmd = "clang++"
-			moduleFlags = cppflags
-			moduleToolingFlags = toolingCppflags
+			moduleFlags = fileFlags.cppflags
+			moduleToolingFlags = fileFlags.toolingCppflags
 		case ".h", ".hpp":
 			ctx.PropertyErrorf("srcs", "Header file %s is not supported, instead use export_include_dirs or local_include_dirs.", srcFile)
 			continue
@@ -625,27 +711,45 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 		if tidy {
 			tidyFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy")
 			tidyFiles = append(tidyFiles, tidyFile)
-
-			rule := clangTidy
-			if ctx.Config().UseRBE() && ctx.Config().IsEnvTrue("RBE_CLANG_TIDY") {
-				rule = clangTidyRE
+			tidyYamlFile := android.ObjPathWithExt(ctx, subdir, srcFile, "tidy.yaml")
+			exportFixes := ""
+			if flags.tidyExportFixes {
+				exportFixes = "-export-fixes=" + tidyYamlFile.String()
+				tidyYamlFiles = append(tidyYamlFiles, tidyYamlFile)
 			}
 
-			ctx.Build(pctx, android.BuildParams{
-				Rule:        rule,
-				Description: "clang-tidy " + srcFile.Rel(),
-				Output:      tidyFile,
-				Input:       srcFile,
-				// We must depend on objFile, since clang-tidy doesn't
-				// support exporting dependencies.
-				Implicit:  objFile,
-				Implicits: cFlagsDeps,
-				OrderOnly: pathDeps,
-				Args: map[string]string{
-					"cFlags":    moduleToolingFlags,
-					"tidyFlags": config.TidyFlagsForSrcFile(srcFile, flags.tidyFlags),
-				},
-			})
+			if !tidyChangedFile(ctx, srcFile) {
+				// Incremental tidy only checks changed files, the other files get an
+				// empty result.
+				ctx.Build(pctx, android.BuildParams{
+					Rule:        android.Touch,
+					Description: "clang-tidy (unchanged) " + srcFile.Rel(),
+					Outputs:     android.WritablePaths{tidyFile, tidyYamlFile},
+				})
+			} else {
+				rule := clangTidy
+				if ctx.Config().UseRBE() && ctx.Config().IsEnvTrue("RBE_CLANG_TIDY") {
+					rule = clangTidyRE
+				}
+
+				ctx.Build(pctx, android.BuildParams{
+					Rule:           rule,
+					Description:    "clang-tidy " + srcFile.Rel(),
+					Output:         tidyFile,
+					ImplicitOutput: tidyYamlFile,
+					Input:          srcFile,
+					// We must depend on objFile, since clang-tidy doesn't
+					// support exporting dependencies.
+					Implicit:  objFile,
+					Implicits: cFlagsDeps,
+					OrderOnly: pathDeps,
+					Args: map[string]string{
+						"cFlags":      moduleToolingFlags,
+						"tidyFlags":   config.TidyFlagsForSrcFile(srcFile, flags.tidyFlags),
+						"exportFixes": exportFixes,
+					},
+				})
+			}
 		}
 
 		if dump {
@@ -676,6 +780,7 @@ func transformSourceToObj(ctx android.ModuleContext, subdir string, srcFiles and
 	return Objects{
 		objFiles:      objFiles,
 		tidyFiles:     tidyFiles,
+		tidyYamlFiles: tidyYamlFiles,
 		coverageFiles: coverageFiles,
 		sAbiDumpFiles: sAbiDumpFiles,
 		kytheFiles:    kytheFiles,
@@ -731,6 +836,12 @@ func transformObjToDynamicBinary(ctx android.ModuleContext,
 	groupLate bool, flags builderFlags, outputFile android.WritablePath,
 	implicitOutputs android.WritablePaths, validations android.WritablePaths) {
 
+	if flags.distributedThinLTO {
+		transformObjToDynamicBinaryDistributedThinLTO(ctx, objFiles, sharedLibs, staticLibs, lateStaticLibs,
+			wholeStaticLibs, deps, crtBegin, crtEnd, groupLate, flags, outputFile, implicitOutputs, validations)
+		return
+	}
+
 	ldCmd := "${config.ClangBin}/clang++"
 
 	var libFlagsList []string
@@ -862,11 +973,18 @@ func unzipRefDump(ctx android.ModuleContext, zippedRefDump android.Path, baseNam
 	return outputFile
 }
 
-// sourceAbiDiff registers a build statement to compare linked sAbi dump files (.ldump).
+// sourceAbiDiff registers a build statement to compare linked sAbi dump files (.ldump). It returns
+// a stamp file that fails to build if inputDump isn't compatible with referenceDump, and the JSON
+// report of the differences, which is tagged with abiClass. The report can be built whether or
+// not the ABI is compatible.
 func sourceAbiDiff(ctx android.ModuleContext, inputDump android.Path, referenceDump android.Path,
-	baseName, exportedHeaderFlags string, checkAllApis, isLlndk, isNdk, isVndkExt bool) android.OptionalPath {
+	baseName, exportedHeaderFlags, abiClass string,
+	checkAllApis, isLlndk, isNdk, isVndkExt bool) (abiDiff, abiDiffReport android.OptionalPath) {
 
 	outputFile := android.PathForModuleOut(ctx, baseName+".abidiff")
+	statusFile := android.PathForModuleOut(ctx, baseName+".abidiff.status")
+	checkFile := android.PathForModuleOut(ctx, baseName+".abidiff.check")
+	reportFile := android.PathForModuleOut(ctx, baseName+".abidiff.json")
 	libName := strings.TrimSuffix(baseName, filepath.Ext(baseName))
 	createReferenceDumpFlags := ""
 
@@ -896,21 +1014,53 @@ func sourceAbiDiff(ctx android.ModuleContext, inputDump android.Path, referenceD
 		extraFlags = append(extraFlags, "-allow-extensions")
 	}
 
+	// create_reference_dumps.py only knows the layout of the device reference dumps.
+	hint := "m update-abi-refs-" + ctx.ModuleName()
+	if !ctx.Host() {
+		hint = fmt.Sprintf("$$ANDROID_BUILD_TOP/development/vndk/tools/header-checker/utils/create_reference_dumps.py %s -l %s or %s",
+			createReferenceDumpFlags, libName, hint)
+	}
+
 	ctx.Build(pctx, android.BuildParams{
-		Rule:        sAbiDiff,
-		Description: "header-abi-diff " + outputFile.Base(),
-		Output:      outputFile,
-		Input:       inputDump,
-		Implicit:    referenceDump,
+		Rule:           sAbiDiff,
+		Description:    "header-abi-diff " + outputFile.Base(),
+		Output:         outputFile,
+		ImplicitOutput: statusFile,
+		Input:          inputDump,
+		Implicit:       referenceDump,
 		Args: map[string]string{
-			"referenceDump":            referenceDump.String(),
-			"libName":                  libName,
-			"arch":                     ctx.Arch().ArchType.Name,
-			"extraFlags":               strings.Join(extraFlags, " "),
-			"createReferenceDumpFlags": createReferenceDumpFlags,
+			"referenceDump": referenceDump.String(),
+			"libName":       libName,
+			"arch":          ctx.Arch().ArchType.Name,
+			"extraFlags":    strings.Join(extraFlags, " "),
+			"statusFile":    statusFile.String(),
 		},
 	})
-	return android.OptionalPathForPath(outputFile)
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sAbiDiffCheck,
+		Description: "check header-abi-diff " + outputFile.Base(),
+		Output:      checkFile,
+		Input:       outputFile,
+		Implicit:    statusFile,
+		Args: map[string]string{
+			"statusFile": statusFile.String(),
+			"hint":       hint,
+		},
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sAbiDiffReport,
+		Description: "abi diff report " + outputFile.Base(),
+		Output:      reportFile,
+		Input:       outputFile,
+		Args: map[string]string{
+			"libName":  libName,
+			"arch":     ctx.Arch().ArchType.Name,
+			"abiClass": abiClass,
+		},
+	})
+	return android.OptionalPathForPath(checkFile), android.OptionalPathForPath(reportFile)
 }
 
 // Generate a rule for extracting a table of contents from a shared library (.so)
@@ -1026,6 +1176,27 @@ func transformDarwinStrip(ctx android.ModuleContext, inputFile android.Path,
 	})
 }
 
+// Registers build statement to strip Windows executables and DLLs. Unless stripAll is set only the
+// debug info is removed.
+func transformWindowsStrip(ctx android.ModuleContext, inputFile android.Path,
+	outputFile android.WritablePath, stripAll bool) {
+
+	args := "--strip-debug"
+	if stripAll {
+		args = "--strip-all"
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        windowsStrip,
+		Description: "strip " + outputFile.Base(),
+		Output:      outputFile,
+		Input:       inputFile,
+		Args: map[string]string{
+			"args": args,
+		},
+	})
+}
+
 // Registers build statement to zip one or more coverage files.
 func transformCoverageFilesToZip(ctx android.ModuleContext,
 	inputs Objects, baseName string) android.OptionalPath {
@@ -201,11 +201,20 @@ type Flags struct {
 	// These must be after any module include flags, which will be in CommonFlags.
 	SystemIncludeFlags []string
 
-	Toolchain    config.Toolchain
-	Tidy         bool // True if clang-tidy is enabled.
-	GcovCoverage bool // True if coverage files should be generated.
-	SAbiDump     bool // True if header abi dumps should be generated.
-	EmitXrefs    bool // If true, generate Ninja rules to generate emitXrefs input files for Kythe
+	// Flags that apply to the source files that are instrumented for coverage, and the globs
+	// that select them.
+	CoverageFlags       []string
+	CoverageSrcs        []string
+	CoverageExcludeSrcs []string
+
+	Toolchain       config.Toolchain
+	Tidy            bool // True if clang-tidy is enabled.
+	TidyExportFixes bool // True if clang-tidy exports its fixes and diagnostics.
+	GcovCoverage    bool // True if coverage files should be generated.
+	SAbiDump        bool // True if header abi dumps should be generated.
+	EmitXrefs       bool // If true, generate Ninja rules to generate emitXrefs input files for Kythe
+
+	DistributedThinLTO bool // True if the ThinLTO backend runs as separate actions from the link.
 
 	// The instruction set required for clang ("arm" or "thumb").
 	RequiredInstructionSet string
@@ -813,6 +822,9 @@ type Module struct {
 	// Kythe (source file indexer) paths for this compilation module
 	kytheFiles android.Paths
 
+	// clang-tidy diagnostics exported for each source file of this compilation module
+	tidyYamlFiles android.Paths
+
 	// For apex variants, this is set as apex.min_sdk_version
 	apexSdkVersion android.ApiLevel
 
@@ -1829,6 +1841,7 @@ func (c *Module) GenerateAndroidBuildActions(actx android.ModuleContext) {
 			return
 		}
 		c.kytheFiles = objs.kytheFiles
+		c.tidyYamlFiles = objs.tidyYamlFiles
 	}
 
 	if c.linker != nil {
@@ -2173,7 +2186,8 @@ func (c *Module) DepsMutator(actx android.BottomUpMutatorContext) {
 
 	if c.isNDKStubLibrary() {
 		// NDK stubs depend on their implementation because the ABI dumps are
-		// generated from the implementation library.
+		// generated from the implementation library, and its exports are checked
+		// against the symbol file.
 		actx.AddFarVariationDependencies(append(ctx.Target().Variations(),
 			c.ImageVariation(),
 			blueprint.Variation{Mutator: "link", Variation: "shared"},
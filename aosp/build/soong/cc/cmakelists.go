@@ -16,8 +16,10 @@ package cc
 
 import (
 	"fmt"
+	"io"
 
 	"android/soong/android"
+	"android/soong/cc/config"
 	"os"
 	"path"
 	"path/filepath"
@@ -26,7 +28,13 @@ import (
 
 // This singleton generates CMakeLists.txt files. It does so for each blueprint Android.bp resulting in a cc.Module
 // when either make, mm, mma, mmm or mmma is called. CMakeLists.txt files are generated in a separate folder
-// structure (see variable CLionOutputProjectsDirectory for root).
+// structure (see variable CLionOutputProjectsDirectory for root). The set of modules can be restricted with
+// SOONG_GEN_IDE_MODULES and SOONG_GEN_IDE_DIRS, see idefilter.go.
+//
+// For each OS/arch a CMake toolchain file is generated as well (see cMakeToolchainsDirectory), so the projects of
+// cross-compiled variants (e.g. windows_x86_64) configure with the right target triple, sysroot and resource
+// compiler. Static and shared library dependencies of a module are pulled in with add_subdirectory when their
+// project is generated too, or as IMPORTED libraries pointing at the soong outputs otherwise.
 
 func init() {
 	android.RegisterSingletonType("cmakelists_generator", cMakeListsGeneratorSingleton)
@@ -40,6 +48,7 @@ type cmakelistsGeneratorSingleton struct{}
 
 const (
 	cMakeListsFilename              = "CMakeLists.txt"
+	cMakeToolchainsDirectory        = "toolchains"
 	cLionAggregateProjectsDirectory = "development" + string(os.PathSeparator) + "ide" + string(os.PathSeparator) + "clion"
 	cLionOutputProjectsDirectory    = "out" + string(os.PathSeparator) + cLionAggregateProjectsDirectory
 	minimumCMakeVersionSupported    = "3.5"
@@ -64,11 +73,14 @@ func (c *cmakelistsGeneratorSingleton) GenerateBuildActions(ctx android.Singleto
 	// Track which projects have already had CMakeLists.txt generated to keep the first
 	// variant for each project.
 	seenProjects := map[string]bool{}
+	// Track which toolchain files have already been generated.
+	seenToolchains := map[string]bool{}
 
+	filter := newIdeModuleFilter(ctx)
 	ctx.VisitAllModules(func(module android.Module) {
-		if ccModule, ok := module.(*Module); ok {
+		if ccModule, ok := module.(*Module); ok && filter.include(ccModule) {
 			if compiledModule, ok := ccModule.compiler.(CompiledInterface); ok {
-				generateCLionProject(compiledModule, ctx, ccModule, seenProjects)
+				generateCLionProject(compiledModule, ctx, ccModule, filter, seenProjects, seenToolchains)
 			}
 		}
 	})
@@ -118,7 +130,7 @@ func linkAggregateCMakeListsFiles(path string, info os.FileInfo, err error) erro
 }
 
 func generateCLionProject(compiledModule CompiledInterface, ctx android.SingletonContext, ccModule *Module,
-	seenProjects map[string]bool) {
+	filter *ideModuleFilter, seenProjects map[string]bool, seenToolchains map[string]bool) {
 	srcs := compiledModule.Srcs()
 	if len(srcs) == 0 {
 		return
@@ -136,6 +148,8 @@ func generateCLionProject(compiledModule CompiledInterface, ctx android.Singleto
 	projectDir := path.Dir(clionprojectLocation)
 	os.MkdirAll(projectDir, os.ModePerm)
 
+	toolchainFile := generateCMakeToolchainFile(ctx, ccModule, seenToolchains)
+
 	// Create cmakelists.txt
 	f, _ := os.Create(filepath.Join(projectDir, cMakeListsFilename))
 	defer f.Close()
@@ -146,12 +160,12 @@ func generateCLionProject(compiledModule CompiledInterface, ctx android.Singleto
 	f.WriteString("# To improve project view in Clion    :\n")
 	f.WriteString("# Tools > CMake > Change Project Root  \n\n")
 	f.WriteString(fmt.Sprintf("cmake_minimum_required(VERSION %s)\n", minimumCMakeVersionSupported))
-	f.WriteString(fmt.Sprintf("project(%s)\n", ccModule.ModuleBase.Name()))
-	f.WriteString(fmt.Sprintf("set(ANDROID_ROOT %s)\n\n", android.AbsSrcDirForExistingUseCases()))
-
-	pathToCC, _ := evalVariable(ctx, "${config.ClangBin}/")
-	f.WriteString(fmt.Sprintf("set(CMAKE_C_COMPILER \"%s%s\")\n", buildCMakePath(pathToCC), "clang"))
-	f.WriteString(fmt.Sprintf("set(CMAKE_CXX_COMPILER \"%s%s\")\n", buildCMakePath(pathToCC), "clang++"))
+	f.WriteString(fmt.Sprintf("set(ANDROID_ROOT %s)\n", android.AbsSrcDirForExistingUseCases()))
+	// The toolchain file sets the compilers, so it has to be known before project().
+	f.WriteString("if(NOT CMAKE_TOOLCHAIN_FILE)\n")
+	f.WriteString(fmt.Sprintf("    set(CMAKE_TOOLCHAIN_FILE \"%s\")\n", toolchainFile))
+	f.WriteString("endif()\n")
+	f.WriteString(fmt.Sprintf("project(%s)\n\n", ccModule.ModuleBase.Name()))
 
 	// Add all sources to the project.
 	f.WriteString("list(APPEND\n")
@@ -198,9 +212,147 @@ func generateCLionProject(compiledModule CompiledInterface, ctx android.Singleto
 	globalIncludeParameters := parseCompilerParameters(ccModule.flags.SystemIncludeFlags, ctx, f)
 	translateToCMake(globalIncludeParameters, f, true, true)
 
-	// Add project executable.
-	f.WriteString(fmt.Sprintf("\nadd_executable(%s ${SOURCE_FILES})\n",
-		cleanExecutableName(ccModule.ModuleBase.Name())))
+	// Add project target.
+	targetName := cleanExecutableName(ccModule.ModuleBase.Name())
+	if library := moduleLibraryInterface(ccModule); library != nil && library.static() {
+		f.WriteString(fmt.Sprintf("\nadd_library(%s STATIC ${SOURCE_FILES})\n", targetName))
+	} else if library != nil && library.shared() {
+		f.WriteString(fmt.Sprintf("\nadd_library(%s SHARED ${SOURCE_FILES})\n", targetName))
+	} else if ccModule.Object() {
+		// Objects don't link against anything.
+		f.WriteString(fmt.Sprintf("\nadd_library(%s OBJECT ${SOURCE_FILES})\n", targetName))
+		return
+	} else {
+		f.WriteString(fmt.Sprintf("\nadd_executable(%s ${SOURCE_FILES})\n", targetName))
+	}
+
+	writeCMakeDependencies(ctx, ccModule, targetName, filter, f)
+}
+
+// Writes a target for each static or shared library dependency of the module and links the module's target
+// against them. Only the first variant of each project is generated, so a dependency's target may be of a
+// different linkage than the variant the module links against in soong.
+func writeCMakeDependencies(ctx android.SingletonContext, ccModule *Module, targetName string,
+	filter *ideModuleFilter, f io.StringWriter) {
+	var deps []string
+	ctx.VisitDirectDeps(ccModule, func(dep android.Module) {
+		if !isIdeLibraryDependency(dep) {
+			return
+		}
+		depModule := dep.(*Module)
+		depName := cleanExecutableName(depModule.ModuleBase.Name())
+		if inList(depName, deps) {
+			return
+		}
+
+		if hasCLionProject(depModule, filter) {
+			depProjectDir := path.Dir(getCMakeListsForModule(depModule, ctx))
+			f.WriteString(fmt.Sprintf("if(NOT TARGET %s)\n", depName))
+			f.WriteString(fmt.Sprintf("    add_subdirectory(\"%s\" \"${CMAKE_BINARY_DIR}/deps/%s\")\n",
+				depProjectDir, path.Base(depProjectDir)))
+			f.WriteString("endif()\n")
+		} else if outputFile := depModule.OutputFile(); outputFile.Valid() {
+			linkage := "STATIC"
+			if depModule.Shared() {
+				linkage = "SHARED"
+			}
+			location := buildCMakePath(outputFile.String())
+			f.WriteString(fmt.Sprintf("if(NOT TARGET %s)\n", depName))
+			f.WriteString(fmt.Sprintf("    add_library(%s %s IMPORTED)\n", depName, linkage))
+			f.WriteString(fmt.Sprintf("    set_target_properties(%s PROPERTIES IMPORTED_LOCATION \"%s\")\n",
+				depName, location))
+			if library, ok := depModule.linker.(*libraryDecorator); ok && library.importLibraryFile.Valid() {
+				// Windows DLLs are linked against through the import library written by the linker.
+				f.WriteString(fmt.Sprintf("    set_target_properties(%s PROPERTIES IMPORTED_IMPLIB \"%s\")\n",
+					depName, buildCMakePath(library.importLibraryFile.String())))
+			}
+			f.WriteString("endif()\n")
+		} else {
+			return
+		}
+		deps = append(deps, depName)
+	})
+
+	if len(deps) > 0 {
+		f.WriteString(fmt.Sprintf("target_link_libraries(%s PRIVATE %s)\n", targetName, strings.Join(deps, " ")))
+	}
+}
+
+// Returns true if a CMakeLists.txt is generated for the module by this singleton.
+func hasCLionProject(ccModule *Module, filter *ideModuleFilter) bool {
+	if compiledModule, ok := ccModule.compiler.(CompiledInterface); ok {
+		return len(compiledModule.Srcs()) > 0 && filter.include(ccModule)
+	}
+	return false
+}
+
+// Maps a soong OS to the CMAKE_SYSTEM_NAME used to cross-compile for it. Device variants are treated like
+// Linux, as they are built against the bionic sysroot rather than through CMake's NDK support.
+func cMakeSystemName(osType android.OsType) string {
+	switch osType {
+	case android.Windows:
+		return "Windows"
+	case android.Darwin:
+		return "Darwin"
+	default:
+		return "Linux"
+	}
+}
+
+// Writes the CMake toolchain file for the OS/arch of the module, if it hasn't been written yet, and returns
+// its path.
+func generateCMakeToolchainFile(ctx android.SingletonContext, ccModule *Module,
+	seenToolchains map[string]bool) string {
+	toolchainFile := getCMakeToolchainFileForModule(ccModule)
+	if seenToolchains[toolchainFile] {
+		return toolchainFile
+	}
+	seenToolchains[toolchainFile] = true
+
+	os.MkdirAll(path.Dir(toolchainFile), os.ModePerm)
+	f, _ := os.Create(toolchainFile)
+	defer f.Close()
+	writeCMakeToolchain(ctx, ccModule, f)
+
+	return toolchainFile
+}
+
+// Writes the CMake toolchain file contents for the OS/arch of the module.
+func writeCMakeToolchain(ctx android.SingletonContext, ccModule *Module, f io.StringWriter) {
+	toolchain := config.FindToolchain(ccModule.Os(), ccModule.Arch())
+
+	f.WriteString("# THIS FILE WAS AUTOMATICALY GENERATED!\n")
+	f.WriteString("# ANY MODIFICATION WILL BE OVERWRITTEN!\n\n")
+	f.WriteString(fmt.Sprintf("set(CMAKE_SYSTEM_NAME %s)\n", cMakeSystemName(ccModule.Os())))
+	f.WriteString(fmt.Sprintf("set(CMAKE_SYSTEM_PROCESSOR %s)\n", ccModule.Arch().ArchType.Name))
+	f.WriteString(fmt.Sprintf("set(ANDROID_ROOT %s)\n\n", android.AbsSrcDirForExistingUseCases()))
+
+	pathToCC, _ := evalVariable(ctx, "${config.ClangBin}/")
+	f.WriteString(fmt.Sprintf("set(CMAKE_C_COMPILER \"%s%s\")\n", buildCMakePath(pathToCC), "clang"))
+	f.WriteString(fmt.Sprintf("set(CMAKE_CXX_COMPILER \"%s%s\")\n", buildCMakePath(pathToCC), "clang++"))
+	for _, lang := range []string{"C", "CXX", "ASM"} {
+		f.WriteString(fmt.Sprintf("set(CMAKE_%s_COMPILER_TARGET %s)\n", lang, toolchain.ClangTriple()))
+	}
+
+	// The sysroot is passed as part of the toolchain cflags, e.g. --sysroot ${WindowsGccRoot}/${WindowsGccTriple}.
+	toolchainParameters := parseCompilerCCParameters(ctx, []string{toolchain.Cflags()})
+	if toolchainParameters.SysRoot != "" {
+		sysroot := buildCMakePath(toolchainParameters.SysRoot)
+		f.WriteString(fmt.Sprintf("\nset(CMAKE_SYSROOT \"%s\")\n", sysroot))
+		f.WriteString(fmt.Sprintf("set(CMAKE_FIND_ROOT_PATH \"%s\")\n", sysroot))
+		f.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_PROGRAM NEVER)\n")
+		f.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_LIBRARY ONLY)\n")
+		f.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_INCLUDE ONLY)\n")
+	}
+
+	if ccModule.Os() == android.Windows {
+		windres, _ := evalVariable(ctx, mingwCmd(toolchain, "windres"))
+		f.WriteString(fmt.Sprintf("\nset(CMAKE_RC_COMPILER \"%s\")\n", buildCMakePath(windres)))
+		f.WriteString(fmt.Sprintf("set(CMAKE_RC_FLAGS \"%s\")\n", toolchain.WindresFlags()))
+	}
+
+	f.WriteString(fmt.Sprintf("\nset(CMAKE_EXECUTABLE_SUFFIX \"%s\")\n", toolchain.ExecutableSuffix()))
+	f.WriteString(fmt.Sprintf("set(CMAKE_SHARED_LIBRARY_SUFFIX \"%s\")\n", toolchain.ShlibSuffix()))
 }
 
 func cleanExecutableName(s string) string {
@@ -476,3 +628,10 @@ func getCMakeListsForModule(module *Module, ctx android.SingletonContext) string
 			module.ModuleBase.Os().Name,
 		cMakeListsFilename)
 }
+
+func getCMakeToolchainFileForModule(module *Module) string {
+	return filepath.Join(android.AbsSrcDirForExistingUseCases(),
+		cLionOutputProjectsDirectory,
+		cMakeToolchainsDirectory,
+		module.ModuleBase.Os().Name+"-"+module.ModuleBase.Arch().ArchType.Name+".cmake")
+}
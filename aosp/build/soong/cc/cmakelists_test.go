@@ -0,0 +1,144 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestCMakeSystemName(t *testing.T) {
+	testCases := []struct {
+		os   android.OsType
+		want string
+	}{
+		{android.Windows, "Windows"},
+		{android.Darwin, "Darwin"},
+		{android.Linux, "Linux"},
+		{android.Android, "Linux"},
+	}
+	for _, tc := range testCases {
+		if got := cMakeSystemName(tc.os); got != tc.want {
+			t.Errorf("cMakeSystemName(%s): expected %q, got %q", tc.os, tc.want, got)
+		}
+	}
+}
+
+// cmakeTestSingleton writes the toolchain file and the dependencies of the Windows variant of foo
+// to strings instead of the CLion project directory.
+type cmakeTestSingleton struct {
+	toolchain strings.Builder
+	deps      strings.Builder
+}
+
+func (s *cmakeTestSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	ctx.VisitAllModules(func(module android.Module) {
+		if ccModule, ok := module.(*Module); ok && ccModule.Name() == "foo" && ccModule.Os() == android.Windows {
+			writeCMakeToolchain(ctx, ccModule, &s.toolchain)
+			writeCMakeDependencies(ctx, ccModule, "foo", newIdeModuleFilter(ctx), &s.deps)
+		}
+	})
+}
+
+func TestCMakeToolchainAndDependencies(t *testing.T) {
+	bp := `
+		cc_defaults {
+			name: "windows_defaults",
+			host_supported: true,
+			stl: "none",
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}
+
+		cc_binary {
+			name: "foo",
+			defaults: ["windows_defaults"],
+			srcs: ["foo.c"],
+			static_libs: ["libstatic"],
+			shared_libs: ["libshared"],
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			defaults: ["windows_defaults"],
+			srcs: ["static.c"],
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			defaults: ["windows_defaults"],
+		}
+	`
+
+	singleton := &cmakeTestSingleton{}
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		PrepareForTestOnWindows,
+		android.FixtureModifyConfig(func(c android.Config) {
+			c.Targets[android.Windows] = []android.Target{
+				{Os: android.Windows, Arch: android.Arch{ArchType: android.X86_64}},
+			}
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("cmake_test", func() android.Singleton { return singleton })
+		}),
+	).RunTestWithBp(t, bp)
+
+	toolchain := singleton.toolchain.String()
+	for _, line := range []string{
+		"set(CMAKE_SYSTEM_NAME Windows)\n",
+		"set(CMAKE_SYSTEM_PROCESSOR x86_64)\n",
+		"set(CMAKE_C_COMPILER_TARGET x86_64-pc-windows-gnu)\n",
+		"set(CMAKE_CXX_COMPILER_TARGET x86_64-pc-windows-gnu)\n",
+		"set(CMAKE_SYSROOT ",
+		"set(CMAKE_RC_FLAGS \"-F pe-x86-64\")\n",
+		"set(CMAKE_EXECUTABLE_SUFFIX \".exe\")\n",
+		"set(CMAKE_SHARED_LIBRARY_SUFFIX \".dll\")\n",
+	} {
+		android.AssertStringDoesContain(t, "toolchain file", toolchain, line)
+	}
+
+	// libstatic has sources, so its project is added as a subdirectory. libshared has none, so its
+	// output is imported, with the import library written by the linker.
+	deps := singleton.deps.String()
+	for _, line := range []string{
+		"if(NOT TARGET libstatic)\n",
+		"/libstatic-x86_64-windows\" \"${CMAKE_BINARY_DIR}/deps/libstatic-x86_64-windows\")\n",
+		"add_library(libshared SHARED IMPORTED)\n",
+	} {
+		android.AssertStringDoesContain(t, "dependencies", deps, line)
+	}
+	android.AssertStringDoesNotContain(t, "dependencies", deps, "add_library(libstatic")
+
+	i := strings.Index(deps, "set_target_properties(libshared PROPERTIES IMPORTED_IMPLIB ")
+	if i < 0 {
+		t.Fatalf("expected libshared to have an import library, got:\n%s", deps)
+	}
+	implib := strings.SplitN(deps[i:], "\n", 2)[0]
+	android.AssertStringDoesContain(t, "IMPORTED_IMPLIB", implib, "/libshared.lib\")")
+
+	i = strings.Index(deps, "target_link_libraries(foo PRIVATE ")
+	if i < 0 {
+		t.Fatalf("expected foo to link against its dependencies, got:\n%s", deps)
+	}
+	link := deps[i:]
+	android.AssertStringDoesContain(t, "target_link_libraries", link, " libstatic")
+	android.AssertStringDoesContain(t, "target_link_libraries", link, " libshared")
+}
@@ -19,6 +19,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"android/soong/android"
@@ -30,6 +31,15 @@ import (
 // at ${OUT_DIR}/soong/development/ide/compdb/compile_commands.json. It will also symlink it
 // to ${SOONG_LINK_COMPDB_TO} if set. In general this should be created by running
 // make SOONG_GEN_COMPDB=1 nothing to get all targets.
+//
+// If SOONG_GEN_COMPDB_SHARDS is also set, a compile_commands.json is additionally
+// written for every module and OS/arch variant at
+// ${OUT_DIR}/soong/development/ide/compdb/<module>/<os>_<arch>/compile_commands.json
+// so that e.g. the windows and linux_glibc flags of the same sources don't
+// overwrite each other. The shards are listed in compile_commands_index.json.
+//
+// The set of modules can be restricted with SOONG_GEN_IDE_MODULES and
+// SOONG_GEN_IDE_DIRS, see idefilter.go.
 
 func init() {
 	android.RegisterSingletonType("compdb_generator", compDBGeneratorSingleton)
@@ -43,11 +53,13 @@ type compdbGeneratorSingleton struct{}
 
 const (
 	compdbFilename                = "compile_commands.json"
+	compdbIndexFilename           = "compile_commands_index.json"
 	compdbOutputProjectsDirectory = "development/ide/compdb"
 
 	// Environment variables used to modify behavior of this singleton.
 	envVariableGenerateCompdb          = "SOONG_GEN_COMPDB"
 	envVariableGenerateCompdbDebugInfo = "SOONG_GEN_COMPDB_DEBUG"
+	envVariableGenerateCompdbShards    = "SOONG_GEN_COMPDB_SHARDS"
 	envVariableCompdbLink              = "SOONG_LINK_COMPDB_TO"
 )
 
@@ -59,6 +71,17 @@ type compDbEntry struct {
 	Output    string   `json:"output,omitempty"`
 }
 
+// A compdb shard holds the entries of a single module for a single OS/arch
+// variant. The compile_commands_index.json file is a list of these.
+type compDbShard struct {
+	Module string `json:"module"`
+	Os     string `json:"os"`
+	Arch   string `json:"arch"`
+	File   string `json:"file"`
+
+	entries map[string]compDbEntry
+}
+
 func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 	if !ctx.Config().IsEnvTrue(envVariableGenerateCompdb) {
 		return
@@ -67,33 +90,76 @@ func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonCon
 	// Instruct the generator to indent the json file for easier debugging.
 	outputCompdbDebugInfo := ctx.Config().IsEnvTrue(envVariableGenerateCompdbDebugInfo)
 
+	// Instruct the generator to also write one compdb per module and OS/arch variant.
+	outputCompdbShards := ctx.Config().IsEnvTrue(envVariableGenerateCompdbShards)
+
 	// We only want one entry per file. We don't care what module/isa it's from
 	m := make(map[string]compDbEntry)
+	// Shards are keyed by <module>/<os>_<arch>, and also keep one entry per file.
+	shards := make(map[string]*compDbShard)
+	filter := newIdeModuleFilter(ctx)
 	ctx.VisitAllModules(func(module android.Module) {
-		if ccModule, ok := module.(*Module); ok {
+		if ccModule, ok := module.(*Module); ok && filter.include(ccModule) {
 			if compiledModule, ok := ccModule.compiler.(CompiledInterface); ok {
 				generateCompdbProject(compiledModule, ctx, ccModule, m)
+				if outputCompdbShards {
+					generateCompdbShard(compiledModule, ctx, ccModule, shards)
+				}
 			}
 		}
 	})
 
 	// Create the output file.
 	dir := android.PathForOutput(ctx, compdbOutputProjectsDirectory)
-	os.MkdirAll(filepath.Join(android.AbsSrcDirForExistingUseCases(), dir.String()), 0777)
 	compDBFile := dir.Join(ctx, compdbFilename)
-	f, err := os.Create(filepath.Join(android.AbsSrcDirForExistingUseCases(), compDBFile.String()))
-	if err != nil {
-		log.Fatalf("Could not create file %s: %s", compDBFile, err)
+	writeCompdbFile(compDBFile, m, outputCompdbDebugInfo)
+
+	if outputCompdbShards {
+		index := make([]compDbShard, 0, len(shards))
+		for _, shard := range shards {
+			writeCompdbFile(dir.Join(ctx, shard.File), shard.entries, outputCompdbDebugInfo)
+			index = append(index, *shard)
+		}
+		sort.Slice(index, func(i, j int) bool {
+			return index[i].File < index[j].File
+		})
+		writeCompdbJson(dir.Join(ctx, compdbIndexFilename), index, outputCompdbDebugInfo)
 	}
-	defer f.Close()
 
+	if finalLinkDir := ctx.Config().Getenv(envVariableCompdbLink); finalLinkDir != "" {
+		finalLinkPath := filepath.Join(finalLinkDir, compdbFilename)
+		os.Remove(finalLinkPath)
+		if err := os.Symlink(compDBFile.String(), finalLinkPath); err != nil {
+			log.Fatalf("Unable to symlink %s to %s: %s", compDBFile, finalLinkPath, err)
+		}
+	}
+}
+
+// writeCompdbFile writes the given entries as a compile_commands.json file at the
+// given path, sorted by source file so the output is stable across runs.
+func writeCompdbFile(compDBFile android.OutputPath, m map[string]compDbEntry, indent bool) {
 	v := make([]compDbEntry, 0, len(m))
 
 	for _, value := range m {
 		v = append(v, value)
 	}
+	sort.Slice(v, func(i, j int) bool {
+		return v[i].File < v[j].File
+	})
+	writeCompdbJson(compDBFile, v, indent)
+}
+
+func writeCompdbJson(path android.OutputPath, v interface{}, indent bool) {
+	absPath := filepath.Join(android.AbsSrcDirForExistingUseCases(), path.String())
+	os.MkdirAll(filepath.Dir(absPath), 0777)
+	f, err := os.Create(absPath)
+	if err != nil {
+		log.Fatalf("Could not create file %s: %s", path, err)
+	}
+	defer f.Close()
+
 	var dat []byte
-	if outputCompdbDebugInfo {
+	if indent {
 		dat, err = json.MarshalIndent(v, "", " ")
 	} else {
 		dat, err = json.Marshal(v)
@@ -102,14 +168,6 @@ func (c *compdbGeneratorSingleton) GenerateBuildActions(ctx android.SingletonCon
 		log.Fatalf("Failed to marshal: %s", err)
 	}
 	f.Write(dat)
-
-	if finalLinkDir := ctx.Config().Getenv(envVariableCompdbLink); finalLinkDir != "" {
-		finalLinkPath := filepath.Join(finalLinkDir, compdbFilename)
-		os.Remove(finalLinkPath)
-		if err := os.Symlink(compDBFile.String(), finalLinkPath); err != nil {
-			log.Fatalf("Unable to symlink %s to %s: %s", compDBFile, finalLinkPath, err)
-		}
-	}
 }
 
 func expandAllVars(ctx android.SingletonContext, args []string) []string {
@@ -192,6 +250,36 @@ func generateCompdbProject(compiledModule CompiledInterface, ctx android.Singlet
 	}
 }
 
+// compdbShardPath returns the path of the shard of a module variant, relative to
+// the compdb output directory.
+func compdbShardPath(name, osName, archName string) string {
+	return filepath.Join(cleanExecutableName(name), osName+"_"+archName, compdbFilename)
+}
+
+func generateCompdbShard(compiledModule CompiledInterface, ctx android.SingletonContext, ccModule *Module, shards map[string]*compDbShard) {
+	if len(compiledModule.Srcs()) == 0 {
+		return
+	}
+
+	name := ccModule.ModuleBase.Name()
+	osName := ccModule.Os().Name
+	archName := ccModule.Arch().ArchType.Name
+	file := compdbShardPath(name, osName, archName)
+
+	shard, ok := shards[file]
+	if !ok {
+		shard = &compDbShard{
+			Module:  name,
+			Os:      osName,
+			Arch:    archName,
+			File:    file,
+			entries: make(map[string]compDbEntry),
+		}
+		shards[file] = shard
+	}
+	generateCompdbProject(compiledModule, ctx, ccModule, shard.entries)
+}
+
 func evalAndSplitVariable(ctx android.SingletonContext, str string) ([]string, error) {
 	evaluated, err := ctx.Eval(pctx, str)
 	if err == nil {
@@ -15,6 +15,7 @@
 package config
 
 import (
+	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -366,21 +367,15 @@ func init() {
 		return "${ClangDefaultBase}"
 	})
 	pctx.VariableFunc("ClangVersion", func(ctx android.PackageVarContext) string {
-		if override := ctx.Config().Getenv("LLVM_PREBUILTS_VERSION"); override != "" {
-			return override
-		}
-		return ClangDefaultVersion
+		return clangVersion(ctx.Config())
 	})
 	pctx.StaticVariable("ClangPath", "${ClangBase}/${HostPrebuiltTag}/${ClangVersion}")
 	pctx.StaticVariable("ClangBin", "${ClangPath}/bin")
 
 	pctx.VariableFunc("ClangShortVersion", func(ctx android.PackageVarContext) string {
-		if override := ctx.Config().Getenv("LLVM_RELEASE_VERSION"); override != "" {
-			return override
-		}
-		return ClangDefaultShortVersion
+		return clangShortVersion(ctx.Config())
 	})
-	pctx.StaticVariable("ClangAsanLibDir", "${ClangBase}/linux-x86/${ClangVersion}/lib64/clang/${ClangShortVersion}/lib/linux")
+	pctx.StaticVariable("ClangAsanLibDir", clangRuntimeLibDir("${ClangBase}", "${ClangVersion}", "${ClangShortVersion}", "linux"))
 
 	// These are tied to the version of LLVM directly in external/llvm, so they might trail the host prebuilts
 	// being used for the rest of the build process.
@@ -412,4 +407,39 @@ func init() {
 	pctx.StaticVariableWithEnvOverride("REAbiLinkerExecStrategy", "RBE_ABI_LINKER_EXEC_STRATEGY", remoteexec.LocalExecStrategy)
 }
 
+func clangBase(config android.Config) string {
+	if override := config.Getenv("LLVM_PREBUILTS_BASE"); override != "" {
+		return override
+	}
+	return ClangDefaultBase
+}
+
+func clangVersion(config android.Config) string {
+	if override := config.Getenv("LLVM_PREBUILTS_VERSION"); override != "" {
+		return override
+	}
+	return ClangDefaultVersion
+}
+
+func clangShortVersion(config android.Config) string {
+	if override := config.Getenv("LLVM_RELEASE_VERSION"); override != "" {
+		return override
+	}
+	return ClangDefaultShortVersion
+}
+
+// clangRuntimeLibDir returns the directory of the clang runtime libraries for the given OS, which
+// are only in the linux-x86 prebuilts.
+func clangRuntimeLibDir(base, version, shortVersion, os string) string {
+	return filepath.Join(base, "linux-x86", version, "lib64", "clang", shortVersion, "lib", os)
+}
+
+// ClangRuntimeLibPath returns the path to a clang runtime library for the given OS, e.g.
+// ${ClangAsanLibDir}/<lib> for "linux".
+func ClangRuntimeLibPath(ctx android.PathContext, os, lib string) android.SourcePath {
+	config := ctx.Config()
+	return android.PathForSource(ctx, clangRuntimeLibDir(clangBase(config), clangVersion(config),
+		clangShortVersion(config), os), lib)
+}
+
 var HostPrebuiltTag = pctx.VariableConfigMethod("HostPrebuiltTag", android.Config.PrebuiltOS)
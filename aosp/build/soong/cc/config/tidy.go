@@ -15,50 +15,23 @@
 package config
 
 import (
-	"android/soong/android"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
+
+	"android/soong/android"
 )
 
 func init() {
-	// Many clang-tidy checks like altera-*, llvm-*, modernize-*
-	// are not designed for Android source code or creating too
-	// many (false-positive) warnings. The global default tidy checks
-	// should include only tested groups and exclude known noisy checks.
-	// See https://clang.llvm.org/extra/clang-tidy/checks/list.html
+	// The default checks are the "default" check set of the tidy config file.
 	pctx.VariableFunc("TidyDefaultGlobalChecks", func(ctx android.PackageVarContext) string {
 		if override := ctx.Config().Getenv("DEFAULT_GLOBAL_TIDY_CHECKS"); override != "" {
 			return override
 		}
-		checks := strings.Join([]string{
-			"-*",
-			"android-*",
-			"bugprone-*",
-			"cert-*",
-			"clang-diagnostic-unused-command-line-argument",
-			"google-*",
-			"misc-*",
-			"performance-*",
-			"portability-*",
-			"-bugprone-narrowing-conversions",
-			"-google-readability*",
-			"-google-runtime-references",
-			"-misc-no-recursion",
-			"-misc-non-private-member-variables-in-classes",
-			"-misc-unused-parameters",
-			// the following groups are excluded by -*
-			// -altera-*
-			// -cppcoreguidelines-*
-			// -darwin-*
-			// -fuchsia-*
-			// -hicpp-*
-			// -llvm-*
-			// -llvmlibc-*
-			// -modernize-*
-			// -mpi-*
-			// -objc-*
-			// -readability-*
-			// -zircon-*
-		}, ",")
+		checks := strings.Join(LoadTidyConfig(ctx).checkSet(tidyDefaultCheckSet), ",")
 		// clang-analyzer-* checks are too slow to be in the default for WITH_TIDY=1.
 		// nightly builds add CLANG_ANALYZER_CHECKS=1 to run those checks.
 		if ctx.Config().IsEnvTrue("CLANG_ANALYZER_CHECKS") {
@@ -67,23 +40,13 @@ func init() {
 		return checks
 	})
 
-	// There are too many clang-tidy warnings in external and vendor projects.
-	// Enable only some google checks for these projects.
+	// The checks for external and vendor projects are the "external_vendor" check set of the
+	// tidy config file.
 	pctx.VariableFunc("TidyExternalVendorChecks", func(ctx android.PackageVarContext) string {
 		if override := ctx.Config().Getenv("DEFAULT_EXTERNAL_VENDOR_TIDY_CHECKS"); override != "" {
 			return override
 		}
-		return strings.Join([]string{
-			"-*",
-			"clang-diagnostic-unused-command-line-argument",
-			"google*",
-			"-google-build-using-namespace",
-			"-google-default-arguments",
-			"-google-explicit-constructor",
-			"-google-readability*",
-			"-google-runtime-int",
-			"-google-runtime-references",
-		}, ",")
+		return strings.Join(LoadTidyConfig(ctx).checkSet(tidyExternalVendorCheckSet), ",")
 	})
 
 	// To reduce duplicate warnings from the same header files,
@@ -99,46 +62,159 @@ func init() {
 	})
 }
 
-type PathBasedTidyCheck struct {
-	PathPrefix string
-	Checks     string
-}
-
 const tidyDefault = "${config.TidyDefaultGlobalChecks}"
 const tidyExternalVendor = "${config.TidyExternalVendorChecks}"
 const tidyDefaultNoAnalyzer = "${config.TidyDefaultGlobalChecks},-clang-analyzer-*"
 
-// This is a map of local path prefixes to the set of default clang-tidy checks
-// to be used.
-// The last matched local_path_prefix should be the most specific to be used.
-var DefaultLocalTidyChecks = []PathBasedTidyCheck{
-	{"external/", tidyExternalVendor},
-	{"external/google", tidyDefault},
-	{"external/webrtc", tidyDefault},
-	{"frameworks/compile/mclinker/", tidyExternalVendor},
-	{"hardware/qcom", tidyExternalVendor},
-	{"vendor/", tidyExternalVendor},
-	{"vendor/google", tidyDefault},
-	{"vendor/google_devices", tidyExternalVendor},
+const (
+	// The tidy config file, relative to the top of the tree. It can be replaced with
+	// TIDY_CONFIG_FILE, e.g. to tune the checks of a project without patching soong.
+	defaultTidyConfigFile = "build/soong/cc/config/tidy_config.json"
+
+	// The check sets of the config file that are used for
+	// ${config.TidyDefaultGlobalChecks} and ${config.TidyExternalVendorChecks}.
+	tidyDefaultCheckSet        = "default"
+	tidyExternalVendorCheckSet = "external_vendor"
+)
+
+// TidyCheckSet is a named list of clang-tidy checks in the tidy config file.
+type TidyCheckSet struct {
+	Comment string   `json:"comment,omitempty"`
+	Checks  []string `json:"checks"`
 }
 
-var reversedDefaultLocalTidyChecks = reverseTidyChecks(DefaultLocalTidyChecks)
+// PathBasedTidyConfig is the clang-tidy configuration of the modules in the
+// directories that start with PathPrefix.
+type PathBasedTidyConfig struct {
+	Comment    string `json:"comment,omitempty"`
+	PathPrefix string `json:"path_prefix"`
+	// Name of the check set to use, defaults to "default".
+	CheckSet string `json:"check_set,omitempty"`
+	// Checks to add to the check set.
+	Checks []string `json:"checks,omitempty"`
+	// Checks that are treated as errors, in addition to tidy_checks_as_errors.
+	ChecksAsErrors []string `json:"checks_as_errors,omitempty"`
+	// Directories whose headers are checked, in addition to the module directory and
+	// DEFAULT_TIDY_HEADER_DIRS.
+	HeaderFilterDirs []string `json:"header_filter_dirs,omitempty"`
+}
+
+// TidyConfig is the contents of the tidy config file.
+type TidyConfig struct {
+	CheckSets map[string]TidyCheckSet `json:"check_sets"`
+	// The last matched entry is used, so it should be the most specific one.
+	Directories []PathBasedTidyConfig `json:"directories"`
+}
 
-func reverseTidyChecks(in []PathBasedTidyCheck) []PathBasedTidyCheck {
-	ret := make([]PathBasedTidyCheck, len(in))
-	for i, check := range in {
-		ret[len(in)-i-1] = check
+// ParseTidyConfig parses and validates the contents of a tidy config file.
+func ParseTidyConfig(data []byte) (*TidyConfig, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	config := &TidyConfig{}
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
 	}
-	return ret
+
+	for _, name := range []string{tidyDefaultCheckSet, tidyExternalVendorCheckSet} {
+		if _, ok := config.CheckSets[name]; !ok {
+			return nil, fmt.Errorf("missing check set %q", name)
+		}
+	}
+	for i, dir := range config.Directories {
+		if dir.PathPrefix == "" {
+			return nil, fmt.Errorf("directories[%d]: missing path_prefix", i)
+		}
+		if _, ok := config.CheckSets[dir.CheckSet]; dir.CheckSet != "" && !ok {
+			return nil, fmt.Errorf("directories[%d]: unknown check set %q", i, dir.CheckSet)
+		}
+	}
+	return config, nil
 }
 
-func TidyChecksForDir(dir string) string {
-	for _, pathCheck := range reversedDefaultLocalTidyChecks {
-		if strings.HasPrefix(dir, pathCheck.PathPrefix) {
-			return pathCheck.Checks
+var tidyConfigKey = android.NewOnceKey("TidyConfig")
+
+// LoadTidyConfig returns the parsed tidy config file of the build.
+func LoadTidyConfig(ctx android.PathContext) *TidyConfig {
+	file := ctx.Config().Getenv("TIDY_CONFIG_FILE")
+	if file == "" {
+		file = defaultTidyConfigFile
+	}
+	path := android.ExistentPathForSource(ctx, file)
+	if !path.Valid() {
+		android.ReportPathErrorf(ctx, "tidy config file %q does not exist", file)
+		return &TidyConfig{}
+	}
+	ctx.AddNinjaFileDeps(path.String())
+
+	config := ctx.Config().Once(tidyConfigKey, func() interface{} {
+		data, err := ioutil.ReadFile(filepath.Join(android.AbsSrcDirForExistingUseCases(), path.String()))
+		if err != nil {
+			return err
 		}
+		config, err := ParseTidyConfig(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		return config
+	})
+	if err, ok := config.(error); ok {
+		android.ReportPathErrorf(ctx, "failed to load the tidy config: %s", err)
+		return &TidyConfig{}
+	}
+	return config.(*TidyConfig)
+}
+
+func (c *TidyConfig) checkSet(name string) []string {
+	return c.CheckSets[name].Checks
+}
+
+// ForDir returns the configuration of the last entry that matches the directory.
+func (c *TidyConfig) ForDir(dir string) PathBasedTidyConfig {
+	for i := len(c.Directories) - 1; i >= 0; i-- {
+		if strings.HasPrefix(dir, c.Directories[i].PathPrefix) {
+			return c.Directories[i]
+		}
+	}
+	return PathBasedTidyConfig{}
+}
+
+// ChecksForDir returns the value of the -checks flag for modules in the directory.
+func (c *TidyConfig) ChecksForDir(dir string) string {
+	return c.Checks(c.ForDir(dir))
+}
+
+// Checks returns the value of the -checks flag for modules that use pathConfig.
+func (c *TidyConfig) Checks(pathConfig PathBasedTidyConfig) string {
+	var checks string
+	switch pathConfig.CheckSet {
+	case "", tidyDefaultCheckSet:
+		checks = tidyDefault
+	case tidyExternalVendorCheckSet:
+		checks = tidyExternalVendor
+	default:
+		checks = strings.Join(c.checkSet(pathConfig.CheckSet), ",")
 	}
-	return tidyDefault
+	if len(pathConfig.Checks) > 0 {
+		checks += "," + strings.Join(pathConfig.Checks, ",")
+	}
+	return checks
+}
+
+// TidyChecksForDir returns the default clang-tidy checks for modules in the directory.
+func TidyChecksForDir(ctx android.PathContext, dir string) string {
+	return LoadTidyConfig(ctx).ChecksForDir(dir)
+}
+
+// TidyChecksAsErrorsForDir returns the clang-tidy checks that are errors for modules in the
+// directory.
+func TidyChecksAsErrorsForDir(ctx android.PathContext, dir string) []string {
+	return LoadTidyConfig(ctx).ForDir(dir).ChecksAsErrors
+}
+
+// TidyHeaderFilterDirsForDir returns the directories whose headers are checked for modules in the
+// directory, in addition to the module directory.
+func TidyHeaderFilterDirsForDir(ctx android.PathContext, dir string) []string {
+	return LoadTidyConfig(ctx).ForDir(dir).HeaderFilterDirs
 }
 
 func TidyFlagsForSrcFile(srcFile android.Path, flags string) string {
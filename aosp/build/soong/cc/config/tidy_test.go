@@ -15,10 +15,26 @@
 package config
 
 import (
+	"io/ioutil"
+	"reflect"
 	"testing"
 )
 
+func loadTestTidyConfig(t *testing.T) *TidyConfig {
+	data, err := ioutil.ReadFile("tidy_config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseTidyConfig(data)
+	if err != nil {
+		t.Fatalf("failed to parse tidy_config.json: %s", err)
+	}
+	return config
+}
+
 func TestTidyChecksForDir(t *testing.T) {
+	config := loadTestTidyConfig(t)
+
 	testCases := []struct {
 		input    string
 		expected string
@@ -32,10 +48,79 @@ func TestTidyChecksForDir(t *testing.T) {
 
 	for _, testCase := range testCases {
 		t.Run(testCase.input, func(t *testing.T) {
-			output := TidyChecksForDir(testCase.input)
+			output := config.ChecksForDir(testCase.input)
 			if output != testCase.expected {
 				t.Error("Output doesn't match expected", output, testCase.expected)
 			}
 		})
 	}
 }
+
+func TestParseTidyConfig(t *testing.T) {
+	config, err := ParseTidyConfig([]byte(`{
+		"check_sets": {
+			"default": {"checks": ["-*", "android-*"]},
+			"external_vendor": {"checks": ["-*", "google*"]},
+			"strict": {"checks": ["-*", "bugprone-*", "modernize-*"]}
+		},
+		"directories": [
+			{"path_prefix": "frameworks/base/", "checks": ["performance-*"]},
+			{
+				"path_prefix": "frameworks/base/tools/aapt2",
+				"check_set": "strict",
+				"checks": ["-modernize-use-trailing-return-type"],
+				"checks_as_errors": ["bugprone-*"],
+				"header_filter_dirs": ["frameworks/base/libs/androidfw/"]
+			}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := config.ChecksForDir("frameworks/base/core/jni"), tidyDefault+",performance-*"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got, want := config.ChecksForDir("frameworks/base/tools/aapt2"),
+		"-*,bugprone-*,modernize-*,-modernize-use-trailing-return-type"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	aapt2 := config.ForDir("frameworks/base/tools/aapt2/link")
+	if got, want := aapt2.ChecksAsErrors, []string{"bugprone-*"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got, want := aapt2.HeaderFilterDirs, []string{"frameworks/base/libs/androidfw/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestParseTidyConfigErrors(t *testing.T) {
+	testCases := []struct {
+		name, config, err string
+	}{
+		{
+			name:   "missing check set",
+			config: `{"check_sets": {"default": {"checks": []}}}`,
+			err:    `missing check set "external_vendor"`,
+		},
+		{
+			name: "unknown check set",
+			config: `{"check_sets": {"default": {"checks": []}, "external_vendor": {"checks": []}},
+				"directories": [{"path_prefix": "foo/", "check_set": "bar"}]}`,
+			err: `directories[0]: unknown check set "bar"`,
+		},
+		{
+			name:   "unknown field",
+			config: `{"check_sets": {}, "checks": []}`,
+			err:    `json: unknown field "checks"`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := ParseTidyConfig([]byte(testCase.config))
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("expected error %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
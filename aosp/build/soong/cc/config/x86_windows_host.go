@@ -15,6 +15,10 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"android/soong/android"
@@ -65,6 +69,8 @@ var (
 	}
 	windowsLldflags = append(windowsLdflags, []string{
 		"-Wl,--Xlink=-Brepro", // Enable deterministic build
+		// Let the clang mingw driver find crt2.o and the import libraries in the sysroot.
+		"--sysroot ${WindowsGccRoot}/${WindowsGccTriple}",
 	}...)
 
 	windowsX86Cflags = []string{
@@ -82,8 +88,8 @@ var (
 		"-static-libgcc",
 
 		"-B${WindowsGccRoot}/${WindowsGccTriple}/bin",
-		"-B${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/4.8.3/32",
-		"-L${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/4.8.3/32",
+		"-B${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/${WindowsGccLibVersion}/32",
+		"-L${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/${WindowsGccLibVersion}/32",
 		"-B${WindowsGccRoot}/${WindowsGccTriple}/lib32",
 	}
 
@@ -94,11 +100,23 @@ var (
 		"-static-libgcc",
 
 		"-B${WindowsGccRoot}/${WindowsGccTriple}/bin",
-		"-B${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/4.8.3",
-		"-L${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/4.8.3",
+		"-B${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/${WindowsGccLibVersion}",
+		"-L${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/${WindowsGccLibVersion}",
 		"-B${WindowsGccRoot}/${WindowsGccTriple}/lib64",
 	}
 
+	// lld is run by clang in mingw mode, which only needs the search paths of the
+	// mingw-w64 sysroot and of libgcc. Distribution packages of mingw-w64 (e.g.
+	// Arch's mingw-w64-gcc) put the import libraries in lib instead of lib64.
+	windowsX8664Lldflags = []string{
+		"-m64",
+		"-L${WindowsGccRoot}/${WindowsGccTriple}/lib64",
+		"-L${WindowsGccRoot}/${WindowsGccTriple}/lib",
+		"-L${WindowsGccRoot}/lib/gcc/${WindowsGccTriple}/${WindowsGccLibVersion}",
+		"-Wl,--high-entropy-va",
+		"-static-libgcc",
+	}
+
 	windowsAvailableLibraries = addPrefix([]string{
 		"gdi32",
 		"imagehlp",
@@ -120,15 +138,57 @@ var (
 
 const (
 	windowsGccVersion = "4.8"
+	windowsGccTriple  = "x86_64-w64-mingw32"
+
+	// The version of libgcc in the prebuilt mingw-w64 toolchain, used when the prebuilt
+	// toolchain isn't checked out.
+	windowsDefaultGccLibVersion = "4.8.3"
 )
 
 func init() {
 	pctx.StaticVariable("WindowsGccVersion", windowsGccVersion)
 
-	pctx.SourcePathVariable("WindowsGccRoot",
+	pctx.SourcePathVariable("WindowsDefaultGccRoot",
 		"prebuilts/gcc/${HostPrebuiltTag}/host/x86_64-w64-mingw32-${WindowsGccVersion}")
-
-	pctx.StaticVariable("WindowsGccTriple", "x86_64-w64-mingw32")
+	// WINDOWS_MINGW_ROOT selects another mingw-w64 toolchain, e.g. the one from the
+	// mingw-w64-gcc package of a Linux distribution.
+	pctx.VariableFunc("WindowsGccRoot", func(ctx android.PackageVarContext) string {
+		if override := ctx.Config().Getenv("WINDOWS_MINGW_ROOT"); override != "" {
+			return override
+		}
+		return "${WindowsDefaultGccRoot}"
+	})
+	// The libgcc version is the name of the newest directory under lib/gcc/<triple> of
+	// the GCC root. It can be overridden with WINDOWS_MINGW_GCC_LIB_VERSION.
+	pctx.VariableFunc("WindowsGccLibVersion", func(ctx android.PackageVarContext) string {
+		if override := ctx.Config().Getenv("WINDOWS_MINGW_GCC_LIB_VERSION"); override != "" {
+			return override
+		}
+		root := ctx.Config().Getenv("WINDOWS_MINGW_ROOT")
+		prebuilt := root == ""
+		if prebuilt {
+			root = filepath.Join("prebuilts/gcc", ctx.Config().PrebuiltOS(), "host",
+				windowsGccTriple+"-"+windowsGccVersion)
+		}
+		libDir := filepath.Join(root, "lib", "gcc", windowsGccTriple)
+		absLibDir := libDir
+		if !filepath.IsAbs(absLibDir) {
+			absLibDir = filepath.Join(android.AbsSrcDirForExistingUseCases(), libDir)
+		}
+		if _, err := os.Stat(absLibDir); err != nil && prebuilt {
+			return windowsDefaultGccLibVersion
+		}
+		version, err := windowsGccLibVersion(absLibDir)
+		if err != nil {
+			ctx.Errorf("WINDOWS_MINGW_ROOT: %s, set WINDOWS_MINGW_GCC_LIB_VERSION to override", err)
+			return ""
+		}
+		// Rerun soong when a version of libgcc is added to or removed from the GCC root.
+		ctx.AddNinjaFileDeps(libDir)
+		return version
+	})
+
+	pctx.StaticVariable("WindowsGccTriple", windowsGccTriple)
 
 	pctx.StaticVariable("WindowsCflags", strings.Join(windowsCflags, " "))
 	pctx.StaticVariable("WindowsLdflags", strings.Join(windowsLdflags, " "))
@@ -140,7 +200,7 @@ func init() {
 	pctx.StaticVariable("WindowsX86Ldflags", strings.Join(windowsX86Ldflags, " "))
 	pctx.StaticVariable("WindowsX86Lldflags", strings.Join(windowsX86Ldflags, " "))
 	pctx.StaticVariable("WindowsX8664Ldflags", strings.Join(windowsX8664Ldflags, " "))
-	pctx.StaticVariable("WindowsX8664Lldflags", strings.Join(windowsX8664Ldflags, " "))
+	pctx.StaticVariable("WindowsX8664Lldflags", strings.Join(windowsX8664Lldflags, " "))
 	pctx.StaticVariable("WindowsX86Cppflags", strings.Join(windowsX86Cppflags, " "))
 	pctx.StaticVariable("WindowsX8664Cppflags", strings.Join(windowsX8664Cppflags, " "))
 
@@ -148,6 +208,86 @@ func init() {
 	// Yasm flags
 	pctx.StaticVariable("WindowsX86YasmFlags", "-f win32 -m x86")
 	pctx.StaticVariable("WindowsX8664YasmFlags", "-f win64 -m amd64")
+
+	// The clang runtime libraries for the mingw targets.
+	pctx.StaticVariable("WindowsClangRuntimeLibDir",
+		clangRuntimeLibDir("${ClangBase}", "${ClangVersion}", "${ClangShortVersion}", "windows"))
+}
+
+// WindowsAddressSanitizerRuntimeDll returns the name of the ASan runtime DLL that Windows
+// executables built with ASan load, or "" if ASan isn't supported for the toolchain.
+func WindowsAddressSanitizerRuntimeDll(t Toolchain) string {
+	if t != toolchainWindowsX8664Singleton {
+		return ""
+	}
+	return "libclang_rt.asan_dynamic-x86_64.dll"
+}
+
+// WindowsUndefinedBehaviorSanitizerMinimalRuntimeLibrary returns the name of the static UBSan
+// minimal runtime library for the Windows toolchain.
+func WindowsUndefinedBehaviorSanitizerMinimalRuntimeLibrary(t Toolchain) string {
+	if t == toolchainWindowsX86Singleton {
+		return "libclang_rt.ubsan_minimal-i386.a"
+	}
+	return "libclang_rt.ubsan_minimal-x86_64.a"
+}
+
+// windowsGccLibVersion returns the newest libgcc version in the lib/gcc/<triple> directory of a
+// mingw-w64 toolchain, which has a directory for each version.
+func windowsGccLibVersion(libDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(libDir, "*"))
+	if err != nil {
+		return "", err
+	}
+	var versions []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			versions = append(versions, filepath.Base(match))
+		}
+	}
+	version := latestGccVersion(versions)
+	if version == "" {
+		return "", fmt.Errorf("no libgcc version found in %s", libDir)
+	}
+	return version, nil
+}
+
+// latestGccVersion returns the highest of the given dotted GCC versions, ignoring
+// any that aren't made of numbers only, or "" if there is none.
+func latestGccVersion(versions []string) string {
+	latest := ""
+	var latestParts []int
+	for _, version := range versions {
+		parts, ok := parseGccVersion(version)
+		if !ok {
+			continue
+		}
+		if latest == "" || compareGccVersions(parts, latestParts) > 0 {
+			latest, latestParts = version, parts
+		}
+	}
+	return latest
+}
+
+func parseGccVersion(version string) ([]int, bool) {
+	var parts []int
+	for _, field := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+func compareGccVersions(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] - b[i]
+		}
+	}
+	return len(a) - len(b)
 }
 
 type toolchainWindows struct {
@@ -0,0 +1,71 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLatestGccVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    []string
+		expected string
+	}{
+		{"prebuilt", []string{"4.8.3"}, "4.8.3"},
+		{"mingw-w64 11.2", []string{"11.2.0"}, "11.2.0"},
+		{"numeric order", []string{"9.3.0", "11.2.0", "10.1.0"}, "11.2.0"},
+		{"longer wins on tie", []string{"4.8", "4.8.3"}, "4.8.3"},
+		{"ignores non versions", []string{"include", "11.2.0-posix", "8.1.0"}, "8.1.0"},
+		{"none", []string{"include"}, ""},
+		{"empty", nil, ""},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			output := latestGccVersion(testCase.input)
+			if output != testCase.expected {
+				t.Error("Output doesn't match expected", output, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestWindowsGccLibVersion(t *testing.T) {
+	libDir := t.TempDir()
+	for _, dir := range []string{"9.3.0", "11.2.0", "include"} {
+		if err := os.Mkdir(filepath.Join(libDir, dir), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Files aren't versions of libgcc.
+	if err := os.WriteFile(filepath.Join(libDir, "12.1.0"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := windowsGccLibVersion(libDir)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if version != "11.2.0" {
+		t.Errorf("expected 11.2.0, got %q", version)
+	}
+
+	if _, err := windowsGccLibVersion(filepath.Join(libDir, "include")); err == nil {
+		t.Errorf("expected an error for a directory without versions")
+	}
+}
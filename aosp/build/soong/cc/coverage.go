@@ -16,17 +16,59 @@ package cc
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
 
 	"android/soong/android"
 )
 
 const profileInstrFlag = "-fprofile-instr-generate=/data/misc/trace/clang-%p-%m.profraw"
 
+// Host binaries write their profiles to the path in LLVM_PROFILE_FILE, which the coverage report
+// rule sets.
+const hostProfileInstrFlag = "-fprofile-instr-generate"
+
+// HOST_NATIVE_COVERAGE=true builds host modules with clang coverage, and adds
+// m coverage-report-<test> and m host-coverage-report to run host tests and report their coverage.
+const envVariableHostNativeCoverage = "HOST_NATIVE_COVERAGE"
+
+// HOST_NATIVE_COVERAGE_IGNORE_FAILURES=true reports the coverage of host tests that fail instead of
+// failing the report.
+const envVariableHostNativeCoverageIgnoreFailures = "HOST_NATIVE_COVERAGE_IGNORE_FAILURES"
+
+func init() {
+	android.RegisterSingletonType("host_coverage_report", hostCoverageReportSingletonFactory)
+}
+
+var hostCoverageReport = pctx.AndroidStaticRule("hostCoverageReport",
+	blueprint.RuleParams{
+		Command: "rm -rf $outDir && mkdir -p $outDir/raw && " +
+			"(LLVM_PROFILE_FILE=$outDir/raw/%p-%m.profraw $in $onFailure) && " +
+			"${config.ClangBin}/llvm-profdata merge -sparse -o $profdata $outDir/raw/*.profraw && " +
+			"${config.ClangBin}/llvm-cov show -format=html -output-dir=$outDir/html " +
+			"-instr-profile=$profdata $objects && " +
+			"${config.ClangBin}/llvm-cov export -format=lcov -instr-profile=$profdata $objects > $lcov && " +
+			"${config.ClangBin}/llvm-cov export -summary-only -instr-profile=$profdata $objects > $out",
+		CommandDeps: []string{
+			"${config.ClangBin}/llvm-profdata",
+			"${config.ClangBin}/llvm-cov",
+		},
+	}, "outDir", "profdata", "lcov", "objects", "onFailure")
+
 type CoverageProperties struct {
 	Native_coverage *bool
 
+	// List of source file globs that are instrumented for coverage. The globs are matched against
+	// the path relative to the module directory, or to the output directory for generated
+	// sources. Defaults to all sources.
+	Native_coverage_srcs []string
+
+	// List of source file globs that are not instrumented for coverage, e.g. "**/*.pb.cc" for
+	// generated protobuf sources or the sources of bundled third-party code.
+	Native_coverage_exclude_srcs []string
+
 	NeedCoverageVariant bool `blueprint:"mutated"`
 	NeedCoverageBuild   bool `blueprint:"mutated"`
 
@@ -39,6 +81,9 @@ type coverage struct {
 
 	// Whether binaries containing this module need --coverage added to their ldflags
 	linkCoverage bool
+
+	// The JSON summary of the coverage report of host tests
+	hostReport android.OptionalPath
 }
 
 func (cov *coverage) props() []interface{} {
@@ -80,24 +125,35 @@ func (cov *coverage) deps(ctx DepsContext, deps Deps) Deps {
 func (cov *coverage) flags(ctx ModuleContext, flags Flags, deps PathDeps) (Flags, PathDeps) {
 	clangCoverage := ctx.DeviceConfig().ClangCoverageEnabled()
 	gcovCoverage := ctx.DeviceConfig().GcovCoverageEnabled()
+	hostCoverage := ctx.Host() && ctx.Config().IsEnvTrue(envVariableHostNativeCoverage)
 
-	if !gcovCoverage && !clangCoverage {
+	if !gcovCoverage && !clangCoverage && !hostCoverage {
 		return flags, deps
 	}
 
 	if cov.Properties.CoverageEnabled {
 		cov.linkCoverage = true
 
-		if gcovCoverage {
+		// The instrumentation flags are only added to the sources that match
+		// native_coverage_srcs and native_coverage_exclude_srcs.
+		flags.CoverageSrcs = cov.Properties.Native_coverage_srcs
+		flags.CoverageExcludeSrcs = cov.Properties.Native_coverage_exclude_srcs
+
+		if ctx.Host() {
+			flags.CoverageFlags = append(flags.CoverageFlags, hostProfileInstrFlag,
+				"-fcoverage-mapping", "-Wno-pass-failed")
+		} else if gcovCoverage {
 			flags.GcovCoverage = true
-			flags.Local.CommonFlags = append(flags.Local.CommonFlags, "--coverage", "-O0")
+			flags.CoverageFlags = append(flags.CoverageFlags, "--coverage")
+			flags.Local.CommonFlags = append(flags.Local.CommonFlags, "-O0")
 
 			// Override -Wframe-larger-than and non-default optimization
 			// flags that the module may use.
 			flags.Local.CFlags = append(flags.Local.CFlags, "-Wno-frame-larger-than=", "-O0")
 		} else if clangCoverage {
-			flags.Local.CommonFlags = append(flags.Local.CommonFlags, profileInstrFlag,
-				"-fcoverage-mapping", "-Wno-pass-failed", "-D__ANDROID_CLANG_COVERAGE__")
+			flags.CoverageFlags = append(flags.CoverageFlags, profileInstrFlag,
+				"-fcoverage-mapping", "-Wno-pass-failed")
+			flags.Local.CommonFlags = append(flags.Local.CommonFlags, "-D__ANDROID_CLANG_COVERAGE__")
 		}
 	}
 
@@ -140,7 +196,10 @@ func (cov *coverage) flags(ctx ModuleContext, flags Flags, deps PathDeps) (Flags
 	}
 
 	if cov.linkCoverage {
-		if gcovCoverage {
+		if ctx.Host() {
+			// The driver links the profile runtime despite -nodefaultlibs.
+			flags.Local.LdFlags = append(flags.Local.LdFlags, hostProfileInstrFlag)
+		} else if gcovCoverage {
 			flags.Local.LdFlags = append(flags.Local.LdFlags, "--coverage")
 
 			coverage := ctx.GetDirectDepWithTag(getGcovProfileLibraryName(ctx), CoverageDepTag).(*Module)
@@ -161,8 +220,10 @@ func (cov *coverage) flags(ctx ModuleContext, flags Flags, deps PathDeps) (Flags
 
 func (cov *coverage) begin(ctx BaseModuleContext) {
 	if ctx.Host() {
-		// TODO(dwillemsen): because of -nodefaultlibs, we must depend on libclang_rt.profile-*.a
-		// Just turn off for now.
+		// Host modules don't get a coverage variant, HOST_NATIVE_COVERAGE builds all of
+		// them with coverage.
+		cov.Properties.CoverageEnabled = ctx.Config().IsEnvTrue(envVariableHostNativeCoverage) &&
+			!ctx.Windows() && ctx.nativeCoverage() && BoolDefault(cov.Properties.Native_coverage, true)
 	} else {
 		cov.Properties = SetCoverageProperties(ctx, cov.Properties, ctx.nativeCoverage(), ctx.useSdk(), ctx.sdkVersion())
 	}
@@ -245,6 +306,106 @@ func coverageMutator(mctx android.BottomUpMutatorContext) {
 	}
 }
 
+// coverageIncludesSrc returns true if a source file, given by its path relative to the module or
+// generated sources directory, matches the coverage include and exclude globs.
+func coverageIncludesSrc(rel string, include, exclude []string) (bool, error) {
+	matchesAny := func(patterns []string) (bool, error) {
+		for _, pattern := range patterns {
+			if match, err := pathtools.Match(pattern, rel); err != nil {
+				return false, err
+			} else if match {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if len(include) > 0 {
+		if match, err := matchesAny(include); err != nil || !match {
+			return false, err
+		}
+	}
+	excluded, err := matchesAny(exclude)
+	return !excluded, err
+}
+
+// buildHostCoverageReport registers a rule that runs a host test and reports the coverage of the
+// test and the shared libraries it depends on directly. The report is written to the coverage
+// directory of the module: an HTML report in html, an LCOV trace and a JSON summary. The report
+// fails if the test fails, unless HOST_NATIVE_COVERAGE_IGNORE_FAILURES is set.
+func (cov *coverage) buildHostCoverageReport(ctx ModuleContext, test android.Path, unstripped android.Path) {
+	objects := []string{unstripped.String()}
+	implicits := android.Paths{unstripped}
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		ccDep, ok := dep.(*Module)
+		if !ok || ccDep.coverage == nil || !ccDep.coverage.Properties.CoverageEnabled {
+			return
+		}
+		if library, ok := ccDep.linker.(libraryInterface); !ok || !library.shared() {
+			return
+		}
+		if unstrippedDep := ccDep.UnstrippedOutputFile(); unstrippedDep != nil {
+			objects = append(objects, "-object "+unstrippedDep.String())
+			implicits = append(implicits, unstrippedDep)
+		}
+	})
+
+	outDir := android.PathForModuleOut(ctx, "coverage")
+	summary := outDir.Join(ctx, "summary.json")
+	profdata := outDir.Join(ctx, ctx.ModuleName()+".profdata")
+	lcov := outDir.Join(ctx, ctx.ModuleName()+".lcov")
+
+	onFailure := ""
+	if ctx.Config().IsEnvTrue(envVariableHostNativeCoverageIgnoreFailures) {
+		onFailure = "|| echo \"" + test.String() + " failed, the coverage report may be incomplete\" >&2"
+	}
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:            hostCoverageReport,
+		Description:     "coverage report " + ctx.ModuleName(),
+		Output:          summary,
+		ImplicitOutputs: android.WritablePaths{profdata, lcov},
+		Input:           test,
+		Implicits:       implicits,
+		Args: map[string]string{
+			"outDir":    outDir.String(),
+			"profdata":  profdata.String(),
+			"lcov":      lcov.String(),
+			"objects":   strings.Join(objects, " "),
+			"onFailure": onFailure,
+		},
+	})
+
+	cov.hostReport = android.OptionalPathForPath(summary)
+}
+
+func hostCoverageReportSingletonFactory() android.Singleton {
+	return &hostCoverageReportSingleton{}
+}
+
+// hostCoverageReportSingleton adds m coverage-report-<test> for each host test built with
+// HOST_NATIVE_COVERAGE, and m host-coverage-report for all of them.
+type hostCoverageReportSingleton struct{}
+
+func (h *hostCoverageReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var allReports android.Paths
+	moduleReports := make(map[string]android.Paths)
+	ctx.VisitAllModules(func(module android.Module) {
+		if c, ok := module.(*Module); ok && c.Enabled() && c.coverage != nil && c.coverage.hostReport.Valid() {
+			report := c.coverage.hostReport.Path()
+			moduleReports[c.Name()] = append(moduleReports[c.Name()], report)
+			allReports = append(allReports, report)
+		}
+	})
+
+	for _, name := range android.SortedStringKeys(moduleReports) {
+		ctx.Phony("coverage-report-"+name, moduleReports[name]...)
+	}
+	if len(allReports) > 0 {
+		ctx.Phony("host-coverage-report", allReports...)
+	}
+}
+
 func parseSymbolFileForAPICoverage(ctx ModuleContext, symbolFile string) android.ModuleOutPath {
 	apiLevelsJson := android.GetApiLevelsJson(ctx)
 	symbolFilePath := android.PathForModuleSrc(ctx, symbolFile)
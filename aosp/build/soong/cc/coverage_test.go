@@ -0,0 +1,143 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestCoverageIncludesSrc(t *testing.T) {
+	testCases := []struct {
+		rel              string
+		include, exclude []string
+		want             bool
+	}{
+		{"Main.cpp", nil, nil, true},
+		{"Resources.pb.cc", nil, []string{"*.pb.cc"}, false},
+		{"compile/Compile.cpp", []string{"compile/**/*.cpp"}, nil, true},
+		{"link/Linker.cpp", []string{"compile/**/*.cpp"}, nil, false},
+		{"third_party/zlib/inflate.c", nil, []string{"third_party/**/*"}, false},
+		{"compile/Png.cpp", []string{"compile/**/*.cpp"}, []string{"compile/Png.cpp"}, false},
+	}
+	for _, tc := range testCases {
+		got, err := coverageIncludesSrc(tc.rel, tc.include, tc.exclude)
+		if err != nil {
+			t.Errorf("coverageIncludesSrc(%q): unexpected error %s", tc.rel, err)
+		} else if got != tc.want {
+			t.Errorf("coverageIncludesSrc(%q, %q, %q) = %t, want %t", tc.rel, tc.include, tc.exclude, got, tc.want)
+		}
+	}
+}
+
+func TestHostCoverageReport(t *testing.T) {
+	bp := `
+		cc_test {
+			name: "foo_test",
+			host_supported: true,
+			gtest: false,
+			srcs: ["foo_test.c"],
+			shared_libs: ["libfoo"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+		}
+	`
+
+	testCases := []struct {
+		name          string
+		env           map[string]string
+		wantOnFailure string
+	}{
+		{
+			name:          "test failures fail the report",
+			env:           map[string]string{"HOST_NATIVE_COVERAGE": "true"},
+			wantOnFailure: "",
+		},
+		{
+			name: "test failures ignored",
+			env: map[string]string{
+				"HOST_NATIVE_COVERAGE":                 "true",
+				"HOST_NATIVE_COVERAGE_IGNORE_FAILURES": "true",
+			},
+			wantOnFailure: "|| echo",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := android.GroupFixturePreparers(
+				prepareForCcTest,
+				android.FixtureMergeEnv(tc.env),
+			).RunTestWithBp(t, bp)
+
+			variant := result.Config.BuildOSTarget.String()
+			test := result.ModuleForTests("foo_test", variant)
+			android.AssertStringListContains(t, "cflags",
+				strings.Fields(test.Rule("cc").Args["cFlags"]), hostProfileInstrFlag)
+
+			report := test.Description("coverage report foo_test")
+			libfoo := result.ModuleForTests("libfoo", variant+"_shared").Module().(*Module)
+			android.AssertStringDoesContain(t, "objects", report.Args["objects"],
+				"-object "+libfoo.UnstrippedOutputFile().String())
+			if tc.wantOnFailure == "" {
+				android.AssertStringEquals(t, "onFailure", "", report.Args["onFailure"])
+			} else {
+				android.AssertStringDoesContain(t, "onFailure", report.Args["onFailure"], tc.wantOnFailure)
+			}
+		})
+	}
+}
+
+func TestNativeCoverageSrcs(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "foo",
+			host_supported: true,
+			srcs: ["foo.cpp", "third_party/bar.cpp"],
+			cflags: ["-DFOO"],
+			native_coverage_exclude_srcs: ["third_party/**/*"],
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureMergeEnv(map[string]string{"HOST_NATIVE_COVERAGE": "true"}),
+	).RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", result.Config.BuildOSTarget.String())
+
+	// The coverage flags stay after the module's common flags, before its cflags and the flags
+	// that can't be overridden.
+	cflags := strings.Fields(foo.Output("obj/foo.o").Args["cFlags"])
+	instr := indexList(hostProfileInstrFlag, cflags)
+	if instr < 0 {
+		t.Fatalf("expected foo.cpp to be instrumented, got %q", cflags)
+	}
+	if local := indexList("-DFOO", cflags); local < instr {
+		t.Errorf("expected %s before -DFOO, got %q", hostProfileInstrFlag, cflags)
+	}
+	if noOverride := indexList("${config.NoOverrideGlobalCflags}", cflags); noOverride < instr {
+		t.Errorf("expected %s before ${config.NoOverrideGlobalCflags}, got %q", hostProfileInstrFlag, cflags)
+	}
+
+	cflags = strings.Fields(foo.Output("obj/third_party/bar.o").Args["cFlags"])
+	android.AssertStringListDoesNotContain(t, "excluded source cflags", cflags, hostProfileInstrFlag)
+	android.AssertStringListContains(t, "excluded source cflags", cflags, "-DFOO")
+}
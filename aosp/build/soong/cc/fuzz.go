@@ -19,6 +19,7 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -29,8 +30,37 @@ import (
 func init() {
 	android.RegisterModuleType("cc_fuzz", FuzzFactory)
 	android.RegisterSingletonType("cc_fuzz_packaging", fuzzPackagingFactory)
+	android.RegisterSingletonType("cc_fuzz_regress", fuzzRegressFactory)
 }
 
+var (
+	// fuzzRegress runs a fuzz target once over each file of its corpus, failing on any crash. The
+	// corpus files are copied with their paths, as files in different directories may have the
+	// same name, and libFuzzer reads corpus directories recursively.
+	fuzzRegress = pctx.AndroidStaticRule("fuzzRegress",
+		blueprint.RuleParams{
+			Command: "rm -rf $corpusDir $artifactDir && mkdir -p $corpusDir $artifactDir && " +
+				"xargs -r -a ${out}.rsp cp --parents -t $corpusDir && " +
+				"LD_LIBRARY_PATH=$libDirs $fuzzTarget -runs=0 -artifact_prefix=$artifactDir/ $corpusDir && " +
+				"touch $out",
+			Rspfile:        "${out}.rsp",
+			RspfileContent: "$in",
+		}, "fuzzTarget", "libDirs", "corpusDir", "artifactDir")
+
+	// fuzzMinimizeCorpus merges the corpus of a fuzz target into the smallest set of files that
+	// covers the same code. The corpus is copied like in fuzzRegress.
+	fuzzMinimizeCorpus = pctx.AndroidStaticRule("fuzzMinimizeCorpus",
+		blueprint.RuleParams{
+			Command: "rm -rf $corpusDir $minimizedDir && mkdir -p $corpusDir $minimizedDir && " +
+				"xargs -r -a ${out}.rsp cp --parents -t $corpusDir && " +
+				"LD_LIBRARY_PATH=$libDirs $fuzzTarget -merge=1 $minimizedDir $corpusDir && " +
+				"${SoongZipCmd} -o $out -C $minimizedDir -D $minimizedDir",
+			CommandDeps:    []string{"${SoongZipCmd}"},
+			Rspfile:        "${out}.rsp",
+			RspfileContent: "$in",
+		}, "fuzzTarget", "libDirs", "corpusDir", "minimizedDir")
+)
+
 // cc_fuzz creates a host/device fuzzer binary. Host binaries can be found at
 // $ANDROID_HOST_OUT/fuzz/, and device binaries can be found at /data/fuzz on
 // your device, or $ANDROID_PRODUCT_OUT/data/fuzz in your build tree.
@@ -421,3 +451,99 @@ func (s *ccFuzzPackager) MakeVars(ctx android.MakeVarsContext) {
 	// Preallocate the slice of fuzz targets to minimise memory allocations.
 	s.PreallocateSlice(ctx, "ALL_FUZZ_TARGETS")
 }
+
+func fuzzRegressFactory() android.Singleton {
+	return &fuzzRegressSingleton{}
+}
+
+// fuzzRegressSingleton adds m fuzz-regress-<module> for each host cc_fuzz module, which runs the
+// fuzz target over each file of its corpus with -runs=0 so that the corpus serves as a regression
+// test. Inputs that crash are written to out/soong/fuzz-regress/<module>/<arch>/artifacts.
+// m fuzz-regress runs all of them. The fuzz targets with a corpus are also run as part of
+// checkbuild, so that a change that makes a corpus file crash fails the build.
+//
+// m fuzz-minimize-<module> minimizes the corpus with -merge=1 and writes it to
+// out/soong/fuzz-regress/<module>/<arch>/minimized_corpus.zip.
+type fuzzRegressSingleton struct {
+	// The regression stamps of the fuzz targets with a corpus, which checkbuild depends on.
+	checkbuildStamps android.Paths
+}
+
+func (s *fuzzRegressSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	regressStamps := make(map[string]android.Paths)
+	minimizedCorpora := make(map[string]android.Paths)
+	s.checkbuildStamps = nil
+
+	ctx.VisitAllModules(func(module android.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() || ccModule.Properties.PreventInstall ||
+			ccModule.Os() != ctx.Config().BuildOS || !ccModule.OutputFile().Valid() {
+			return
+		}
+		fuzzModule, ok := ccModule.compiler.(*fuzzBinary)
+		if !ok {
+			return
+		}
+
+		name := ccModule.Name()
+		outDir := android.PathForOutput(ctx, "fuzz-regress", name, ccModule.Arch().ArchType.String())
+		fuzzTarget := ccModule.UnstrippedOutputFile()
+		corpus := fuzzModule.fuzzPackagedModule.Corpus
+
+		sharedLibraries := collectAllSharedDependencies(ctx, module)
+		var libDirs []string
+		for _, library := range sharedLibraries {
+			libDirs = append(libDirs, filepath.Dir(library.String()))
+		}
+		implicits := append(android.Paths{fuzzTarget}, sharedLibraries...)
+		libDirsArg := strings.Join(android.FirstUniqueStrings(libDirs), ":")
+
+		stamp := outDir.Join(ctx, "regress.stamp")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        fuzzRegress,
+			Description: "fuzz regress " + name,
+			Output:      stamp,
+			Inputs:      corpus,
+			Implicits:   implicits,
+			Args: map[string]string{
+				"fuzzTarget":  fuzzTarget.String(),
+				"libDirs":     libDirsArg,
+				"corpusDir":   outDir.Join(ctx, "corpus").String(),
+				"artifactDir": outDir.Join(ctx, "artifacts").String(),
+			},
+		})
+		regressStamps[name] = append(regressStamps[name], stamp)
+		if len(corpus) > 0 {
+			s.checkbuildStamps = append(s.checkbuildStamps, stamp)
+		}
+
+		minimizedCorpus := outDir.Join(ctx, "minimized_corpus.zip")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        fuzzMinimizeCorpus,
+			Description: "fuzz minimize corpus " + name,
+			Output:      minimizedCorpus,
+			Inputs:      corpus,
+			Implicits:   implicits,
+			Args: map[string]string{
+				"fuzzTarget":   fuzzTarget.String(),
+				"libDirs":      libDirsArg,
+				"corpusDir":    outDir.Join(ctx, "merge_corpus").String(),
+				"minimizedDir": outDir.Join(ctx, "minimized_corpus").String(),
+			},
+		})
+		minimizedCorpora[name] = append(minimizedCorpora[name], minimizedCorpus)
+	})
+
+	var allStamps android.Paths
+	for _, name := range android.SortedStringKeys(regressStamps) {
+		ctx.Phony("fuzz-regress-"+name, regressStamps[name]...)
+		ctx.Phony("fuzz-minimize-"+name, minimizedCorpora[name]...)
+		allStamps = append(allStamps, regressStamps[name]...)
+	}
+	if len(allStamps) > 0 {
+		ctx.Phony("fuzz-regress", allStamps...)
+	}
+	if len(s.checkbuildStamps) > 0 {
+		ctx.Phony("checkbuild", s.checkbuildStamps...)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestFuzzRegress(t *testing.T) {
+	bp := `
+		cc_fuzz {
+			name: "foo_fuzzer",
+			host_supported: true,
+			srcs: ["foo_fuzzer.c"],
+			shared_libs: ["libfoo"],
+			corpus: [
+				"corpus/a/seed",
+				"corpus/b/seed",
+			],
+		}
+
+		cc_fuzz {
+			name: "bar_fuzzer",
+			host_supported: true,
+			srcs: ["bar_fuzzer.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+		}
+	`
+
+	fuzzRegress := &fuzzRegressSingleton{}
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("cc_fuzz_regress", func() android.Singleton { return fuzzRegress })
+		}),
+		android.MockFS{
+			"corpus/a/seed": nil,
+			"corpus/b/seed": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	variant := result.Config.BuildOSTarget.String()
+	fuzzer := result.ModuleForTests("foo_fuzzer", variant).Module().(*Module)
+	outDir := "fuzz-regress/foo_fuzzer/" + result.Config.BuildOSTarget.Arch.ArchType.String() + "/"
+
+	singleton := result.SingletonForTests("cc_fuzz_regress")
+	regress := singleton.Output(outDir + "regress.stamp")
+	minimize := singleton.Output(outDir + "minimized_corpus.zip")
+
+	for _, rule := range []android.TestingBuildParams{regress, minimize} {
+		// Corpus files with the same name in different directories are all used.
+		android.AssertDeepEquals(t, "corpus", []string{"corpus/a/seed", "corpus/b/seed"}, rule.Inputs.Strings())
+		android.AssertStringListContains(t, "implicits", rule.Implicits.Strings(),
+			fuzzer.UnstrippedOutputFile().String())
+		android.AssertStringDoesContain(t, "libDirs", rule.Args["libDirs"], "/libfoo/")
+	}
+	android.AssertStringDoesContain(t, "corpusDir", regress.Args["corpusDir"], outDir+"corpus")
+	android.AssertStringDoesContain(t, "artifactDir", regress.Args["artifactDir"], outDir+"artifacts")
+	android.AssertStringDoesContain(t, "minimizedDir", minimize.Args["minimizedDir"], outDir+"minimized_corpus")
+
+	// Only the fuzz targets with a corpus are run by checkbuild.
+	singleton.Output("fuzz-regress/bar_fuzzer/" + result.Config.BuildOSTarget.Arch.ArchType.String() + "/regress.stamp")
+	android.AssertPathsRelativeToTopEquals(t, "checkbuild stamps",
+		[]string{"out/soong/" + outDir + "regress.stamp"}, fuzzRegress.checkbuildStamps)
+}
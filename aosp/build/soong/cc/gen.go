@@ -15,10 +15,13 @@
 package cc
 
 import (
+	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
 )
@@ -220,6 +223,206 @@ func genWinMsg(ctx android.ModuleContext, srcFile android.Path, flags builderFla
 	return rcFile, headerFile
 }
 
+type WindowsResourcesProperties struct {
+	// version of the file as 1 to 4 dot separated numbers, e.g. "31.0.0.1". Used for both
+	// the FILEVERSION and the FileVersion string of the VERSIONINFO resource.
+	File_version *string
+
+	// version of the product the file is shipped with, in the same format as file_version.
+	// Defaults to file_version.
+	Product_version *string
+
+	// strings of the VERSIONINFO resource
+	Product_name     *string
+	File_description *string
+	Company_name     *string
+	Legal_copyright  *string
+
+	// .ico file to embed as the application icon
+	Icon *string `android:"path"`
+
+	// application manifest to embed instead of the generated one
+	Manifest *string `android:"path"`
+
+	// whether the generated manifest opts into paths longer than MAX_PATH. Defaults to true.
+	Long_path_aware *bool
+
+	// whether the generated manifest selects UTF-8 as the active code page. Defaults to true.
+	Utf8_code_page *bool
+}
+
+func (props *WindowsResourcesProperties) empty() bool {
+	return props.File_version == nil && props.Product_version == nil &&
+		props.Product_name == nil && props.File_description == nil &&
+		props.Company_name == nil && props.Legal_copyright == nil &&
+		props.Icon == nil && props.Manifest == nil &&
+		props.Long_path_aware == nil && props.Utf8_code_page == nil
+}
+
+// parseWinVersion parses a version of 1 to 4 dot separated numbers into the 4 16-bit
+// numbers used by VERSIONINFO, padding missing ones with 0.
+func parseWinVersion(version string) ([4]uint16, error) {
+	var ret [4]uint16
+	fields := strings.Split(version, ".")
+	if len(fields) > 4 {
+		return ret, fmt.Errorf("%q has more than 4 components", version)
+	}
+	for i, field := range fields {
+		n, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return ret, fmt.Errorf("%q is not a version of 1 to 4 dot separated numbers", version)
+		}
+		ret[i] = uint16(n)
+	}
+	return ret, nil
+}
+
+func winVersionString(v [4]uint16, sep string) string {
+	return fmt.Sprintf("%d%s%d%s%d%s%d", v[0], sep, v[1], sep, v[2], sep, v[3])
+}
+
+// Quotes a string for use in a .rc file, where quotes are escaped by doubling them.
+func rcQuote(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// winManifest returns an application manifest for the given binary.
+func winManifest(name string, version [4]uint16, longPathAware, utf8CodePage bool) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	b.WriteString(`<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0">` + "\n")
+	fmt.Fprintf(&b, `  <assemblyIdentity type="win32" name="%s" version="%s"/>`+"\n",
+		name, winVersionString(version, "."))
+	b.WriteString(`  <compatibility xmlns="urn:schemas-microsoft-com:compatibility.v1">` + "\n")
+	b.WriteString(`    <application>` + "\n")
+	// Windows 7, 8, 8.1 and 10/11.
+	for _, id := range []string{
+		"35138b9a-5d96-4fbd-8e2d-a2440225f93a",
+		"4a2f28e3-53b9-4441-ba9c-d69d4a4a6e38",
+		"1f676c76-80e1-4239-95bb-83d0f6d0da78",
+		"8e0f7a12-bfb3-4fe8-b9a5-48fd50a15a9a",
+	} {
+		fmt.Fprintf(&b, `      <supportedOS Id="{%s}"/>`+"\n", id)
+	}
+	b.WriteString(`    </application>` + "\n")
+	b.WriteString(`  </compatibility>` + "\n")
+	if longPathAware || utf8CodePage {
+		b.WriteString(`  <application xmlns="urn:schemas-microsoft-com:asm.v3">` + "\n")
+		b.WriteString(`    <windowsSettings>` + "\n")
+		if longPathAware {
+			b.WriteString(`      <longPathAware xmlns="http://schemas.microsoft.com/SMI/2016/WindowsSettings">true</longPathAware>` + "\n")
+		}
+		if utf8CodePage {
+			b.WriteString(`      <activeCodePage xmlns="http://schemas.microsoft.com/SMI/2019/WindowsSettings">UTF-8</activeCodePage>` + "\n")
+		}
+		b.WriteString(`    </windowsSettings>` + "\n")
+		b.WriteString(`  </application>` + "\n")
+	}
+	b.WriteString(`</assembly>` + "\n")
+	return b.String()
+}
+
+// winResourceScript returns a .rc file with a VERSIONINFO resource and, if set, the icon
+// and manifest resources. The numeric values of the winver.h and winuser.h constants are
+// used, as windres doesn't get the mingw include paths.
+func winResourceScript(fileName string, fileVersion, productVersion [4]uint16,
+	values [][2]string, icon, manifest string) string {
+
+	var b strings.Builder
+	// 1 VERSIONINFO is VS_VERSION_INFO.
+	b.WriteString("1 VERSIONINFO\n")
+	fmt.Fprintf(&b, "FILEVERSION %s\n", winVersionString(fileVersion, ","))
+	fmt.Fprintf(&b, "PRODUCTVERSION %s\n", winVersionString(productVersion, ","))
+	b.WriteString("FILEFLAGSMASK 0x3fL\n")
+	b.WriteString("FILEFLAGS 0x0L\n")
+	// VOS_NT_WINDOWS32
+	b.WriteString("FILEOS 0x40004L\n")
+	// VFT_APP
+	b.WriteString("FILETYPE 0x1L\n")
+	b.WriteString("FILESUBTYPE 0x0L\n")
+	b.WriteString("BEGIN\n")
+	b.WriteString("  BLOCK \"StringFileInfo\"\n")
+	b.WriteString("  BEGIN\n")
+	// U.S. English, Unicode.
+	b.WriteString("    BLOCK \"040904b0\"\n")
+	b.WriteString("    BEGIN\n")
+	for _, kv := range values {
+		fmt.Fprintf(&b, "      VALUE %s, %s\n", rcQuote(kv[0]), rcQuote(kv[1]))
+	}
+	b.WriteString("    END\n")
+	b.WriteString("  END\n")
+	b.WriteString("  BLOCK \"VarFileInfo\"\n")
+	b.WriteString("  BEGIN\n")
+	b.WriteString("    VALUE \"Translation\", 0x409, 1200\n")
+	b.WriteString("  END\n")
+	b.WriteString("END\n")
+	if icon != "" {
+		fmt.Fprintf(&b, "1 ICON %s\n", rcQuote(icon))
+	}
+	if manifest != "" {
+		// CREATEPROCESS_MANIFEST_RESOURCE_ID RT_MANIFEST
+		fmt.Fprintf(&b, "1 24 %s\n", rcQuote(manifest))
+	}
+	return b.String()
+}
+
+// genWinResources writes a .rc file (and an application manifest, unless one is given)
+// for the Windows resources of a binary and returns it, along with the files it
+// references.
+func genWinResources(ctx android.ModuleContext, props *WindowsResourcesProperties,
+	fileName string) (android.Path, android.Paths) {
+
+	var deps android.Paths
+
+	fileVersion, err := parseWinVersion(proptools.StringDefault(props.File_version, "0"))
+	if err != nil {
+		ctx.PropertyErrorf("windows_resources.file_version", "%s", err)
+	}
+	productVersion := fileVersion
+	if props.Product_version != nil {
+		productVersion, err = parseWinVersion(*props.Product_version)
+		if err != nil {
+			ctx.PropertyErrorf("windows_resources.product_version", "%s", err)
+		}
+	}
+
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	values := [][2]string{
+		{"CompanyName", String(props.Company_name)},
+		{"FileDescription", String(props.File_description)},
+		{"FileVersion", proptools.StringDefault(props.File_version, winVersionString(fileVersion, "."))},
+		{"InternalName", name},
+		{"LegalCopyright", String(props.Legal_copyright)},
+		{"OriginalFilename", fileName},
+		{"ProductName", proptools.StringDefault(props.Product_name, name)},
+		{"ProductVersion", proptools.StringDefault(props.Product_version, winVersionString(productVersion, "."))},
+	}
+
+	icon := ""
+	if props.Icon != nil {
+		iconPath := android.PathForModuleSrc(ctx, *props.Icon)
+		icon = iconPath.String()
+		deps = append(deps, iconPath)
+	}
+
+	var manifestPath android.Path
+	if props.Manifest != nil {
+		manifestPath = android.PathForModuleSrc(ctx, *props.Manifest)
+	} else {
+		generatedManifest := android.PathForModuleGen(ctx, "windres", fileName+".manifest")
+		android.WriteFileRule(ctx, generatedManifest, winManifest(name, fileVersion,
+			BoolDefault(props.Long_path_aware, true), BoolDefault(props.Utf8_code_page, true)))
+		manifestPath = generatedManifest
+	}
+	deps = append(deps, manifestPath)
+
+	rcFile := android.PathForModuleGen(ctx, "windres", fileName+".rc")
+	android.WriteFileRule(ctx, rcFile, winResourceScript(fileName, fileVersion, productVersion,
+		values, icon, manifestPath.String()))
+
+	return rcFile, deps
+}
+
 // Used to communicate information from the genSources method back to the library code that uses
 // it.
 type generatedSourceInfo struct {
@@ -75,3 +75,67 @@ func TestGen(t *testing.T) {
 	})
 
 }
+
+func TestParseWinVersion(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected [4]uint16
+		err      bool
+	}{
+		{input: "31", expected: [4]uint16{31, 0, 0, 0}},
+		{input: "31.0.0", expected: [4]uint16{31, 0, 0, 0}},
+		{input: "2.19.1.7084544", err: true},
+		{input: "1.2.3.4", expected: [4]uint16{1, 2, 3, 4}},
+		{input: "1.2.3.4.5", err: true},
+		{input: "1.x", err: true},
+		{input: "", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := parseWinVersion(tc.input)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestWinResourceScript(t *testing.T) {
+	rc := winResourceScript("aapt2.exe", [4]uint16{2, 19, 0, 0}, [4]uint16{31, 0, 0, 0},
+		[][2]string{{"ProductName", `Android "Asset" Packaging Tool`}}, "icon.ico", "aapt2.exe.manifest")
+
+	for _, expected := range []string{
+		"FILEVERSION 2,19,0,0\n",
+		"PRODUCTVERSION 31,0,0,0\n",
+		`VALUE "ProductName", "Android ""Asset"" Packaging Tool"` + "\n",
+		`1 ICON "icon.ico"` + "\n",
+		`1 24 "aapt2.exe.manifest"` + "\n",
+	} {
+		if !strings.Contains(rc, expected) {
+			t.Errorf("expected .rc file to contain %q, got:\n%s", expected, rc)
+		}
+	}
+}
+
+func TestWinManifest(t *testing.T) {
+	manifest := winManifest("aapt2", [4]uint16{2, 19, 0, 0}, true, false)
+	if !strings.Contains(manifest, `version="2.19.0.0"`) {
+		t.Errorf("expected manifest to contain the assembly version, got:\n%s", manifest)
+	}
+	if !strings.Contains(manifest, "<longPathAware") {
+		t.Errorf("expected manifest to be long path aware, got:\n%s", manifest)
+	}
+	if strings.Contains(manifest, "<activeCodePage") {
+		t.Errorf("expected manifest not to set the active code page, got:\n%s", manifest)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"android/soong/android"
+)
+
+// The IDE project generators (compdb.go and cmakelists.go) visit every cc.Module
+// in the tree by default. The environment variables below restrict them to a
+// set of modules, e.g.
+//     SOONG_GEN_IDE_MODULES=aapt2 SOONG_GEN_COMPDB=1 m nothing
+// Modules selected this way pull in the transitive closure of their static and
+// shared library dependencies, so the generated project is self-contained.
+
+const (
+	// A comma or space separated list of module names. Each entry may be a glob
+	// (e.g. "libaapt2*").
+	envVariableIdeModules = "SOONG_GEN_IDE_MODULES"
+	// A comma or space separated list of source directory prefixes (e.g.
+	// "frameworks/base/tools/aapt2").
+	envVariableIdeDirs = "SOONG_GEN_IDE_DIRS"
+)
+
+type ideModuleFilter struct {
+	patterns []string
+	dirs     []string
+
+	// Names of the selected modules and of their library dependencies.
+	selected map[string]bool
+}
+
+func splitIdeFilterList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// newIdeModuleFilter returns the filter configured in the environment, or nil if
+// all modules should be visited.
+func newIdeModuleFilter(ctx android.SingletonContext) *ideModuleFilter {
+	patterns := splitIdeFilterList(ctx.Config().Getenv(envVariableIdeModules))
+	dirs := splitIdeFilterList(ctx.Config().Getenv(envVariableIdeDirs))
+	if len(patterns) == 0 && len(dirs) == 0 {
+		return nil
+	}
+
+	f := &ideModuleFilter{
+		patterns: patterns,
+		dirs:     dirs,
+		selected: make(map[string]bool),
+	}
+
+	var fringe []android.Module
+	ctx.VisitAllModules(func(module android.Module) {
+		if ccModule, ok := module.(*Module); ok {
+			if f.matches(ccModule.ModuleBase.Name(), ctx.ModuleDir(ccModule)) {
+				fringe = append(fringe, ccModule)
+			}
+		}
+	})
+
+	// Every variant of a module is visited separately, so walk the dependencies
+	// of each of them; only the names are recorded.
+	seen := make(map[android.Module]bool)
+	for i := 0; i < len(fringe); i++ {
+		module := fringe[i]
+		if seen[module] {
+			continue
+		}
+		seen[module] = true
+		f.selected[module.(*Module).ModuleBase.Name()] = true
+
+		ctx.VisitDirectDeps(module, func(dep android.Module) {
+			if isIdeLibraryDependency(dep) && !seen[dep] {
+				fringe = append(fringe, dep)
+			}
+		})
+	}
+
+	return f
+}
+
+// isIdeLibraryDependency returns true if the dependency is a static or shared
+// library whose sources should be part of the IDE project.
+func isIdeLibraryDependency(dep android.Module) bool {
+	ccModule, ok := dep.(*Module)
+	if !ok {
+		return false
+	}
+	library := moduleLibraryInterface(ccModule)
+	if library == nil || ccModule.IsStubs() {
+		return false
+	}
+	return library.static() || library.shared()
+}
+
+// matches returns true if the module is selected by name, glob or directory,
+// without taking dependencies into account.
+func (f *ideModuleFilter) matches(name, dir string) bool {
+	for _, pattern := range f.patterns {
+		if match, err := filepath.Match(pattern, name); err == nil && match {
+			return true
+		}
+	}
+	for _, prefix := range f.dirs {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if dir == prefix || strings.HasPrefix(dir, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// include returns true if the IDE project for the module should be generated.
+// A nil filter includes all modules.
+func (f *ideModuleFilter) include(module *Module) bool {
+	if f == nil {
+		return true
+	}
+	return f.selected[module.ModuleBase.Name()]
+}
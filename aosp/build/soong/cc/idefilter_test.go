@@ -0,0 +1,62 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitIdeFilterList(t *testing.T) {
+	got := splitIdeFilterList(" aapt2,libandroidfw  libziparchive,,\tlibaapt2*")
+	want := []string{"aapt2", "libandroidfw", "libziparchive", "libaapt2*"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestIdeModuleFilterMatches(t *testing.T) {
+	f := &ideModuleFilter{
+		patterns: []string{"aapt2", "libaapt2*"},
+		dirs:     []string{"system/libziparchive/"},
+	}
+
+	testCases := []struct {
+		name string
+		dir  string
+		want bool
+	}{
+		{name: "aapt2", dir: "frameworks/base/tools/aapt2", want: true},
+		{name: "aapt2_tests", dir: "frameworks/base/tools/aapt2", want: false},
+		{name: "libaapt2_jni", dir: "frameworks/base/tools/aapt2", want: true},
+		{name: "libziparchive", dir: "system/libziparchive", want: true},
+		{name: "ziptool", dir: "system/libziparchive/cli", want: true},
+		{name: "libziparchive_fork", dir: "system/libziparchive_fork", want: false},
+		{name: "libbase", dir: "system/libbase", want: false},
+	}
+
+	for _, tc := range testCases {
+		if got := f.matches(tc.name, tc.dir); got != tc.want {
+			t.Errorf("matches(%q, %q): expected %v, got %v", tc.name, tc.dir, tc.want, got)
+		}
+	}
+}
+
+func TestNilIdeModuleFilterIncludesEverything(t *testing.T) {
+	var f *ideModuleFilter
+	if !f.include(&Module{}) {
+		t.Errorf("expected a nil filter to include all modules")
+	}
+}
@@ -72,6 +72,12 @@ type LibraryProperties struct {
 		Versions []string
 	}
 
+	// How mismatches between the exports of the library and its symbol file
+	// (header_abi_checker.symbol_file, stubs.symbol_file or llndk.symbol_file) are reported.
+	// Exports that aren't in header_abi_checker.symbol_file are never reported, as it may list
+	// only a subset of the exports.
+	Symbol_file_check SymbolFileCheckProperties
+
 	// set the name of the output
 	Stem *string `android:"arch_variant"`
 
@@ -95,6 +101,8 @@ type LibraryProperties struct {
 	// Properties for ABI compatibility checker
 	Header_abi_checker struct {
 		// Enable ABI checks (even if this is not an LLNDK/VNDK lib)
+		// Host libraries are only checked if this is set, for linux_glibc and linux_musl, against
+		// the reference dumps in prebuilts/abi-dumps/host/<os>/<arch>/source-based.
 		Enabled *bool
 
 		// Path to a symbol file that specifies the symbols to be included in the generated
@@ -490,6 +498,7 @@ type libraryDecorator struct {
 	flagExporter
 	flagExporterInfo *FlagExporterInfo
 	stripper         Stripper
+	bolt             Bolt
 
 	// For whole_static_libs
 	objects Objects
@@ -506,9 +515,16 @@ type libraryDecorator struct {
 	// linked Source Abi Dump
 	sAbiOutputFile android.OptionalPath
 
-	// Source Abi Diff
+	// Check of the Source Abi Diff, which fails if the ABI isn't compatible
 	sAbiDiff android.OptionalPath
 
+	// JSON report of the Source Abi Diff, merged into the build-wide ABI report
+	sAbiDiffReport android.OptionalPath
+
+	// Path of the gzipped reference Source Abi Dump relative to the source tree, which
+	// update-abi-refs-<module> writes the linked Source Abi Dump to
+	sAbiRefDumpFile string
+
 	// Location of the static library in the sysroot. Empty if the library is
 	// not included in the NDK.
 	ndkSysrootPath android.Path
@@ -516,6 +532,12 @@ type libraryDecorator struct {
 	// Location of the linked, unstripped library for shared libraries
 	unstrippedOutputFile android.Path
 
+	// Location of the CodeView debug info of Windows shared libraries
+	pdbFile android.OptionalPath
+
+	// Location of the import library of Windows shared libraries
+	importLibraryFile android.OptionalPath
+
 	// Location of the file that should be copied to dist dir when requested
 	distFile android.Path
 
@@ -795,7 +817,8 @@ func (library *libraryDecorator) linkerProps() []interface{} {
 		&library.Properties,
 		&library.MutatedProperties,
 		&library.flagExporter.Properties,
-		&library.stripper.StripProperties)
+		&library.stripper.StripProperties,
+		&library.bolt.BoltProperties)
 
 	if library.MutatedProperties.BuildShared {
 		props = append(props, &library.SharedProperties)
@@ -855,6 +878,11 @@ func (library *libraryDecorator) linkerFlags(ctx ModuleContext, flags Flags) Fla
 		}
 
 		flags.Global.LdFlags = append(flags.Global.LdFlags, f...)
+
+		if !library.buildStubs() {
+			flags = library.stripper.pdbFlags(ctx, flags)
+			flags = library.bolt.flags(ctx, flags)
+		}
 	}
 
 	return flags
@@ -1349,6 +1377,14 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 
 		flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--out-implib="+importLibraryPath.String())
 		implicitOutputs = append(implicitOutputs, importLibraryPath)
+		library.importLibraryFile = android.OptionalPathForPath(importLibraryPath)
+
+		if library.stripper.NeedsPdb(ctx) && !library.buildStubs() {
+			var pdbFile android.ModuleOutPath
+			pdbFile, flags = library.stripper.pdbFile(ctx, fileName, flags)
+			implicitOutputs = append(implicitOutputs, pdbFile)
+			library.pdbFile = android.OptionalPathForPath(pdbFile)
+		}
 	}
 
 	builderFlags := flagsToBuilderFlags(flags)
@@ -1375,6 +1411,12 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	}
 	library.unstrippedOutputFile = outputFile
 
+	if library.bolt.enabled(ctx) && !library.buildStubs() {
+		boltedOutputFile := outputFile
+		outputFile = android.PathForModuleOut(ctx, "unbolted", fileName)
+		library.bolt.optimize(ctx, outputFile, boltedOutputFile, library.Properties.Inject_bssl_hash)
+	}
+
 	outputFile = maybeInjectBoringSSLHash(ctx, outputFile, library.Properties.Inject_bssl_hash, fileName)
 
 	if Bool(library.baseLinker.Properties.Use_version_lib) {
@@ -1404,9 +1446,30 @@ func (library *libraryDecorator) linkShared(ctx ModuleContext,
 	linkerDeps = append(linkerDeps, deps.SharedLibsDeps...)
 	linkerDeps = append(linkerDeps, deps.LateSharedLibsDeps...)
 	linkerDeps = append(linkerDeps, objs.tidyFiles...)
+
+	// Lint the symbol files in the implementation variant rather than in every stubs variant, and
+	// check that the implementation library exports the symbols of its symbol file, and nothing
+	// else.
+	var validations android.WritablePaths
+	if !library.buildStubs() && !ctx.IsLlndk() && !ctx.IsVendorPublicLibrary() {
+		checkProperty, _ := library.symbolFileForExportCheck(ctx.Module().(*Module))
+		for _, symbolFile := range library.symbolFiles() {
+			f := lintSymbolFile(ctx, symbolFile.property, symbolFile.path)
+			if f == nil || symbolFile.property != checkProperty {
+				continue
+			}
+			missingLevel, unlistedLevel := library.Properties.Symbol_file_check.levels(ctx)
+			if checkProperty == "header_abi_checker.symbol_file" {
+				unlistedLevel = ""
+			}
+			validations = append(validations,
+				checkSymbolFileExportsRule(ctx, f, outputFile, missingLevel, unlistedLevel))
+		}
+	}
+
 	transformObjToDynamicBinary(ctx, objs.objFiles, sharedLibs,
 		deps.StaticLibs, deps.LateStaticLibs, deps.WholeStaticLibs,
-		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, implicitOutputs, nil)
+		linkerDeps, deps.CrtBegin, deps.CrtEnd, false, builderFlags, outputFile, implicitOutputs, validations)
 
 	objs.coverageFiles = append(objs.coverageFiles, deps.StaticLibObjs.coverageFiles...)
 	objs.coverageFiles = append(objs.coverageFiles, deps.WholeStaticLibObjs.coverageFiles...)
@@ -1456,6 +1519,10 @@ func (library *libraryDecorator) unstrippedOutputFilePath() android.Path {
 	return library.unstrippedOutputFile
 }
 
+func (library *libraryDecorator) pdbFilePath() android.OptionalPath {
+	return library.pdbFile
+}
+
 func (library *libraryDecorator) disableStripping() {
 	library.stripper.StripProperties.Strip.None = BoolPtr(true)
 }
@@ -1472,12 +1539,9 @@ func (library *libraryDecorator) coverageOutputFilePath() android.OptionalPath {
 }
 
 func getRefAbiDumpFile(ctx ModuleContext, vndkVersion, fileName string) android.Path {
-	// The logic must be consistent with classifySourceAbiDump.
-	isNdk := ctx.isNdk(ctx.Config())
-	isLlndkOrVndk := ctx.IsLlndkPublic() || (ctx.useVndk() && ctx.isVndk())
-
-	refAbiDumpTextFile := android.PathForVndkRefAbiDump(ctx, vndkVersion, fileName, isNdk, isLlndkOrVndk, false)
-	refAbiDumpGzipFile := android.PathForVndkRefAbiDump(ctx, vndkVersion, fileName, isNdk, isLlndkOrVndk, true)
+	dir := refAbiDumpDir(ctx, vndkVersion)
+	refAbiDumpTextFile := android.ExistentPathForSource(ctx, dir, fileName+".lsdump")
+	refAbiDumpGzipFile := android.ExistentPathForSource(ctx, dir, fileName+".lsdump.gz")
 
 	if refAbiDumpTextFile.Valid() {
 		if refAbiDumpGzipFile.Valid() {
@@ -1520,12 +1584,15 @@ func (library *libraryDecorator) linkSAbiDumpFiles(ctx ModuleContext, objs Objec
 			library.Properties.Header_abi_checker.Exclude_symbol_versions,
 			library.Properties.Header_abi_checker.Exclude_symbol_tags)
 
-		addLsdumpPath(classifySourceAbiDump(ctx) + ":" + library.sAbiOutputFile.String())
+		abiClass := classifySourceAbiDump(ctx)
+		addLsdumpPath(abiClass + ":" + library.sAbiOutputFile.String())
+
+		library.sAbiRefDumpFile = filepath.Join(refAbiDumpDir(ctx, vndkVersion), fileName+".lsdump.gz")
 
 		refAbiDumpFile := getRefAbiDumpFile(ctx, vndkVersion, fileName)
 		if refAbiDumpFile != nil {
-			library.sAbiDiff = sourceAbiDiff(ctx, library.sAbiOutputFile.Path(),
-				refAbiDumpFile, fileName, exportedHeaderFlags,
+			library.sAbiDiff, library.sAbiDiffReport = sourceAbiDiff(ctx, library.sAbiOutputFile.Path(),
+				refAbiDumpFile, fileName, exportedHeaderFlags, abiClass,
 				Bool(library.Properties.Header_abi_checker.Check_all_apis),
 				ctx.IsLlndk(), ctx.isNdk(ctx.Config()), ctx.IsVndkExt())
 		}
@@ -1777,6 +1844,10 @@ func (library *libraryDecorator) install(ctx ModuleContext, file android.Path) {
 		} else if ctx.directlyInAnyApex() && ctx.IsLlndk() && !isBionic(ctx.baseModuleName()) {
 			// Skip installing LLNDK (non-bionic) libraries moved to APEX.
 			ctx.Module().HideFromMake()
+		} else if ctx.Host() && library.baseLinker.sanitize.isSanitizerEnabled(msan) {
+			// The msan variants of host libraries are installed next to the uninstrumented
+			// ones, msan binaries find them through their runpath.
+			library.baseInstaller.subDir = "msan"
 		}
 
 		library.baseInstaller.install(ctx, file)
@@ -1888,6 +1959,51 @@ func (library *libraryDecorator) symbolFileForAbiCheck(ctx ModuleContext) *strin
 	return nil
 }
 
+// symbolFileForExportCheck returns the property and the path of the symbol file that the exports
+// of the shared library variant m are checked against, or nil if they aren't checked.
+func (library *libraryDecorator) symbolFileForExportCheck(m *Module) (string, *string) {
+	// Only check implementation libraries.
+	if library.buildStubs() || m.IsLlndk() || m.IsVendorPublicLibrary() {
+		return "", nil
+	}
+	// Host variants are often built from fewer sources than the device variants, e.g. liblog
+	// without pmsg, while the symbol file describes the device library.
+	if m.Host() {
+		return "", nil
+	}
+	// Coverage and sanitizer variants export the symbols of their runtimes.
+	if m.isCoverageVariant() || (m.sanitize != nil && !m.sanitize.isVariantOnProductionDevice()) {
+		return "", nil
+	}
+	// The exports are checked against the first symbol file that is set.
+	for _, symbolFile := range library.symbolFiles() {
+		if symbolFile.property != "vendor_public_library.symbol_file" {
+			return symbolFile.property, &symbolFile.path
+		}
+	}
+	return "", nil
+}
+
+type librarySymbolFile struct {
+	property, path string
+}
+
+// symbolFiles returns the symbol files that are set in the properties of the library.
+func (library *libraryDecorator) symbolFiles() []librarySymbolFile {
+	var symbolFiles []librarySymbolFile
+	for _, symbolFile := range []librarySymbolFile{
+		{"header_abi_checker.symbol_file", String(library.Properties.Header_abi_checker.Symbol_file)},
+		{"stubs.symbol_file", String(library.Properties.Stubs.Symbol_file)},
+		{"llndk.symbol_file", String(library.Properties.Llndk.Symbol_file)},
+		{"vendor_public_library.symbol_file", String(library.Properties.Vendor_public_library.Symbol_file)},
+	} {
+		if symbolFile.path != "" {
+			symbolFiles = append(symbolFiles, symbolFile)
+		}
+	}
+	return symbolFiles
+}
+
 func (library *libraryDecorator) hasStubsVariants() bool {
 	// Just having stubs.symbol_file is enough to create a stub variant. In that case
 	// the stub for the future API level is created.
@@ -2282,13 +2398,9 @@ func versionMutator(mctx android.BottomUpMutatorContext) {
 	}
 }
 
-// maybeInjectBoringSSLHash adds a rule to run bssl_inject_hash on the output file if the module has the
-// inject_bssl_hash or if any static library dependencies have inject_bssl_hash set.  It returns the output path
-// that the linked output file should be written to.
-// TODO(b/137267623): Remove this in favor of a cc_genrule when they support operating on shared libraries.
-func maybeInjectBoringSSLHash(ctx android.ModuleContext, outputFile android.ModuleOutPath,
-	inject *bool, fileName string) android.ModuleOutPath {
-	// TODO(b/137267623): Remove this in favor of a cc_genrule when they support operating on shared libraries.
+// injectsBoringSSLHash returns true if the module has inject_bssl_hash set or if any static library
+// dependencies have inject_bssl_hash set.
+func injectsBoringSSLHash(ctx android.ModuleContext, inject *bool) bool {
 	injectBoringSSLHash := Bool(inject)
 	ctx.VisitDirectDeps(func(dep android.Module) {
 		if tag, ok := ctx.OtherModuleDependencyTag(dep).(libraryDependencyTag); ok && tag.static() {
@@ -2301,7 +2413,17 @@ func maybeInjectBoringSSLHash(ctx android.ModuleContext, outputFile android.Modu
 			}
 		}
 	})
-	if injectBoringSSLHash {
+	return injectBoringSSLHash
+}
+
+// maybeInjectBoringSSLHash adds a rule to run bssl_inject_hash on the output file if the module has the
+// inject_bssl_hash or if any static library dependencies have inject_bssl_hash set.  It returns the output path
+// that the linked output file should be written to.
+// TODO(b/137267623): Remove this in favor of a cc_genrule when they support operating on shared libraries.
+func maybeInjectBoringSSLHash(ctx android.ModuleContext, outputFile android.ModuleOutPath,
+	inject *bool, fileName string) android.ModuleOutPath {
+	// TODO(b/137267623): Remove this in favor of a cc_genrule when they support operating on shared libraries.
+	if injectsBoringSSLHash(ctx, inject) {
 		hashedOutputfile := outputFile
 		outputFile = android.PathForModuleOut(ctx, "unhashed", fileName)
 
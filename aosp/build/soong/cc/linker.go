@@ -16,6 +16,7 @@ package cc
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"android/soong/android"
 	"android/soong/cc/config"
@@ -528,6 +529,11 @@ func (linker *baseLinker) linkerFlags(ctx ModuleContext, flags Flags) Flags {
 
 		if !ctx.static() {
 			for _, rpath := range linker.dynamicProperties.RunPaths {
+				if linker.sanitize.isSanitizerEnabled(msan) {
+					// Find the msan variants of shared libraries before the uninstrumented ones.
+					flags.Global.LdFlags = append(flags.Global.LdFlags,
+						"-Wl,-rpath,"+rpathPrefix+filepath.Join(rpath, "msan"))
+				}
 				flags.Global.LdFlags = append(flags.Global.LdFlags, "-Wl,-rpath,"+rpathPrefix+rpath)
 			}
 		}
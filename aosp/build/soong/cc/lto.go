@@ -15,6 +15,10 @@
 package cc
 
 import (
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -37,6 +41,43 @@ import (
 //
 // This file adds support to soong to automatically propogate LTO options to a
 // new variant of all static dependencies for each module with LTO enabled.
+//
+// With distributed ThinLTO the linker only runs the thin link, which writes an
+// index file for each bitcode object with the summaries of the functions the
+// object imports. Each object is then compiled to a native object by a
+// separate ThinLTO backend action, and the native objects are linked. The
+// static libraries are linked as lazily loaded objects between --start-lib and
+// --end-lib rather than as archives, so that the index file of each object has
+// a path that is known when the build actions are generated.
+
+var (
+	// thinLTOIndex runs the thin link of a distributed ThinLTO link and writes the list of the
+	// index files to $out. The index files are written to $newPrefix in place of $oldPrefix in
+	// the paths of the objects. The linker doesn't write index files for native objects, so
+	// the index files listed in $indexFiles that are missing are created empty.
+	thinLTOIndex = pctx.AndroidStaticRule("thinLTOIndex",
+		blueprint.RuleParams{
+			Command: "$ldCmd ${crtBegin} @${out}.rsp ${libFlags} ${crtEnd} -o /dev/null ${ldFlags} ${extraLibFlags} " +
+				"-Wl,--thinlto-index-only=${out} -Wl,--thinlto-prefix-replace='${oldPrefix};${newPrefix}' && " +
+				"xargs touch < ${indexFiles}",
+			CommandDeps:    []string{"$ldCmd"},
+			Rspfile:        "${out}.rsp",
+			RspfileContent: "${in}",
+		}, "ldCmd", "crtBegin", "libFlags", "crtEnd", "ldFlags", "extraLibFlags", "oldPrefix", "newPrefix",
+		"indexFiles")
+
+	// thinLTOBackend compiles a bitcode object to a native object with the functions its index
+	// file imports. Native objects and lazily loaded bitcode objects that the thin link didn't
+	// use have an empty index file. Both are copied, as the final link doesn't use the latter
+	// either.
+	thinLTOBackend = pctx.AndroidStaticRule("thinLTOBackend",
+		blueprint.RuleParams{
+			Command: "if [ -s $index ]; then " +
+				"$ccCmd -c -x ir $in -fthinlto-index=$index -o $out $cFlags; " +
+				"else cp $in $out; fi",
+			CommandDeps: []string{"$ccCmd"},
+		}, "ccCmd", "index", "cFlags")
+)
 
 type LTOProperties struct {
 	// Lto must violate capitialization style for acronyms so that it can be
@@ -45,6 +86,12 @@ type LTOProperties struct {
 		Never *bool `android:"arch_variant"`
 		Full  *bool `android:"arch_variant"`
 		Thin  *bool `android:"arch_variant"`
+
+		// Distributed runs the ThinLTO backend of a binary or shared library with thin: true
+		// as a separate action for each object instead of in the link action, so that the
+		// backend compiles run in parallel and can be cached. Only supported with lld on
+		// Android and Linux.
+		Distributed *bool `android:"arch_variant"`
 	} `android:"arch_variant"`
 
 	// Dep properties indicate that this module needs to be built with LTO
@@ -111,7 +158,9 @@ func (lto *lto) flags(ctx BaseModuleContext, flags Flags) Flags {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-fwhole-program-vtables")
 		}
 
-		if lto.ThinLTO() && ctx.Config().IsEnvTrue("USE_THINLTO_CACHE") && lto.useClangLld(ctx) {
+		if lto.DistributedThinLTO(ctx) {
+			flags.DistributedThinLTO = true
+		} else if lto.ThinLTO() && ctx.Config().IsEnvTrue("USE_THINLTO_CACHE") && lto.useClangLld(ctx) {
 			// Set appropriate ThinLTO cache policy
 			cacheDirFormat := "-Wl,--thinlto-cache-dir="
 			cacheDir := android.PathForOutput(ctx, "thinlto-cache").String()
@@ -151,6 +200,12 @@ func (lto *lto) ThinLTO() bool {
 	return Bool(lto.Properties.Lto.Thin)
 }
 
+// DistributedThinLTO returns true if the ThinLTO backend of the module runs as separate actions.
+func (lto *lto) DistributedThinLTO(ctx BaseModuleContext) bool {
+	return lto.LTO() && lto.ThinLTO() && Bool(lto.Properties.Lto.Distributed) && lto.useClangLld(ctx) &&
+		!ctx.Darwin() && !ctx.Windows()
+}
+
 // Is lto.never explicitly set to true?
 func (lto *lto) Never() bool {
 	return Bool(lto.Properties.Lto.Never)
@@ -246,3 +301,200 @@ func ltoMutator(mctx android.BottomUpMutatorContext) {
 		}
 	}
 }
+
+// thinLTOStaticLibObjs returns the objects of each static library built with ThinLTO that the
+// module links, by the path of the library.
+func thinLTOStaticLibObjs(ctx android.ModuleContext) map[string]android.Paths {
+	libObjs := make(map[string]android.Paths)
+	ctx.WalkDeps(func(dep android.Module, parent android.Module) bool {
+		libTag, ok := ctx.OtherModuleDependencyTag(dep).(libraryDependencyTag)
+		if !ok || !libTag.static() {
+			return false
+		}
+		if ccDep, ok := dep.(*Module); ok && ccDep.lto.LTO() && ccDep.lto.ThinLTO() &&
+			ctx.OtherModuleHasProvider(dep, StaticLibraryInfoProvider) {
+			info := ctx.OtherModuleProvider(dep, StaticLibraryInfoProvider).(StaticLibraryInfo)
+			if len(info.Objects.objFiles) > 0 {
+				libObjs[info.StaticLibrary.String()] = info.Objects.objFiles
+			}
+		}
+		return true
+	})
+	return libObjs
+}
+
+// thinLTOBackendCFlags returns the flags of the ThinLTO backend compiles, which are the flags
+// of the module without the flags that make clang emit bitcode.
+func thinLTOBackendCFlags(flags builderFlags) string {
+	var cFlags []string
+	for _, f := range strings.Fields(strings.Join([]string{flags.globalCommonFlags, flags.globalCFlags,
+		flags.localCommonFlags, flags.localCFlags}, " ")) {
+		if strings.HasPrefix(f, "-flto") || f == "-fsplit-lto-unit" || f == "-fwhole-program-vtables" {
+			continue
+		}
+		cFlags = append(cFlags, f)
+	}
+	cFlags = append(cFlags, "-Wno-unused-command-line-argument")
+	return strings.Join(cFlags, " ")
+}
+
+// transformObjToDynamicBinaryDistributedThinLTO links a binary or shared library like
+// transformObjToDynamicBinary, with the thin link, the ThinLTO backend of each object and the
+// final link of the native objects as separate actions.
+func transformObjToDynamicBinaryDistributedThinLTO(ctx android.ModuleContext,
+	objFiles, sharedLibs, staticLibs, lateStaticLibs, wholeStaticLibs, deps, crtBegin, crtEnd android.Paths,
+	groupLate bool, flags builderFlags, outputFile android.WritablePath,
+	implicitOutputs android.WritablePaths, validations android.WritablePaths) {
+
+	libObjs := thinLTOStaticLibObjs(ctx)
+
+	oldPrefix := android.PathForOutput(ctx, ".intermediates").String() + "/"
+	newPrefix := android.PathForModuleOut(ctx, "thinlto").String() + "/"
+	indexList := android.PathForModuleOut(ctx, "thinlto", outputFile.Base()+".thinlto.list")
+	ccCmd := "${config.ClangBin}/clang"
+	cFlags := thinLTOBackendCFlags(flags)
+
+	// nativeObjs maps each object to the native object that the final link uses in its place, and
+	// indexFiles are the index files of the objects, which the thin link writes.
+	nativeObjs := make(map[string]android.Path)
+	var indexFiles android.WritablePaths
+	native := func(objs android.Paths) android.Paths {
+		var ret android.Paths
+		for _, obj := range objs {
+			if nativeObj, ok := nativeObjs[obj.String()]; ok {
+				ret = append(ret, nativeObj)
+				continue
+			}
+			rel := strings.TrimPrefix(obj.String(), oldPrefix)
+			if rel == obj.String() {
+				// Objects outside of the output directory, e.g. prebuilt objects, are
+				// native objects.
+				nativeObjs[obj.String()] = obj
+				ret = append(ret, obj)
+				continue
+			}
+			nativeObj := android.PathForModuleOut(ctx, "thinlto", pathtools.ReplaceExtension(rel, "native.o"))
+			indexFile := android.PathForModuleOut(ctx, "thinlto", rel+".thinlto.bc")
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        thinLTOBackend,
+				Description: "thinlto backend " + obj.Base(),
+				Output:      nativeObj,
+				Input:       obj,
+				Implicit:    indexFile,
+				Args: map[string]string{
+					"ccCmd":  ccCmd,
+					"index":  indexFile.String(),
+					"cFlags": cFlags,
+				},
+			})
+			nativeObjs[obj.String()] = nativeObj
+			indexFiles = append(indexFiles, indexFile)
+			ret = append(ret, nativeObj)
+		}
+		return ret
+	}
+
+	// libFlags returns the flags that link the libraries, with the static libraries built with
+	// ThinLTO replaced by their objects, and the objects those flags use.
+	libFlags := func(objs func(android.Paths) android.Paths) (string, android.Paths) {
+		var libFlagsList []string
+		var libDeps android.Paths
+		if len(flags.libFlags) > 0 {
+			libFlagsList = append(libFlagsList, flags.libFlags)
+		}
+
+		staticLibFlags := func(libs android.Paths) {
+			for _, lib := range libs {
+				if libObjFiles, ok := libObjs[lib.String()]; ok {
+					libObjFiles = objs(libObjFiles)
+					libFlagsList = append(libFlagsList, "-Wl,--start-lib")
+					libFlagsList = append(libFlagsList, libObjFiles.Strings()...)
+					libFlagsList = append(libFlagsList, "-Wl,--end-lib")
+					libDeps = append(libDeps, libObjFiles...)
+				} else {
+					libFlagsList = append(libFlagsList, lib.String())
+					libDeps = append(libDeps, lib)
+				}
+			}
+		}
+
+		for _, lib := range wholeStaticLibs {
+			if libObjFiles, ok := libObjs[lib.String()]; ok {
+				libObjFiles = objs(libObjFiles)
+				libFlagsList = append(libFlagsList, libObjFiles.Strings()...)
+				libDeps = append(libDeps, libObjFiles...)
+			} else {
+				libFlagsList = append(libFlagsList, "-Wl,--whole-archive", lib.String(), "-Wl,--no-whole-archive")
+				libDeps = append(libDeps, lib)
+			}
+		}
+
+		staticLibFlags(staticLibs)
+
+		if groupLate && len(lateStaticLibs) > 0 {
+			libFlagsList = append(libFlagsList, "-Wl,--start-group")
+		}
+		staticLibFlags(lateStaticLibs)
+		if groupLate && len(lateStaticLibs) > 0 {
+			libFlagsList = append(libFlagsList, "-Wl,--end-group")
+		}
+
+		libFlagsList = append(libFlagsList, sharedLibs.Strings()...)
+
+		return strings.Join(libFlagsList, " "), libDeps
+	}
+
+	ldCmd := "${config.ClangBin}/clang++"
+	ldFlags := flags.globalLdFlags + " " + flags.localLdFlags
+
+	deps = append(deps, crtBegin...)
+	deps = append(deps, crtEnd...)
+
+	// The backend actions are created first, so that the index files of all objects are known.
+	nativeInputs := native(objFiles)
+	nativeLibFlags, nativeLibDeps := libFlags(native)
+
+	indexFilesList := android.PathForModuleOut(ctx, "thinlto", outputFile.Base()+".thinlto.files")
+	android.WriteFileRule(ctx, indexFilesList, strings.Join(indexFiles.Strings(), "\n"))
+
+	bitcodeLibFlags, bitcodeLibDeps := libFlags(func(objs android.Paths) android.Paths { return objs })
+	ctx.Build(pctx, android.BuildParams{
+		Rule:            thinLTOIndex,
+		Description:     "thinlto index " + outputFile.Base(),
+		Output:          indexList,
+		ImplicitOutputs: indexFiles,
+		Inputs:          objFiles,
+		Implicits:       append(android.Paths{indexFilesList}, append(android.CopyOf(deps), bitcodeLibDeps...)...),
+		OrderOnly:       sharedLibs,
+		Args: map[string]string{
+			"ldCmd":         ldCmd,
+			"crtBegin":      strings.Join(crtBegin.Strings(), " "),
+			"libFlags":      bitcodeLibFlags,
+			"extraLibFlags": flags.extraLibFlags,
+			"ldFlags":       ldFlags,
+			"crtEnd":        strings.Join(crtEnd.Strings(), " "),
+			"oldPrefix":     oldPrefix,
+			"newPrefix":     newPrefix,
+			"indexFiles":    indexFilesList.String(),
+		},
+	})
+
+	ctx.Build(pctx, android.BuildParams{
+		Rule:            ld,
+		Description:     "link " + outputFile.Base(),
+		Output:          outputFile,
+		ImplicitOutputs: implicitOutputs,
+		Inputs:          nativeInputs,
+		Implicits:       append(android.CopyOf(deps), nativeLibDeps...),
+		OrderOnly:       sharedLibs,
+		Validations:     validations.Paths(),
+		Args: map[string]string{
+			"ldCmd":         ldCmd,
+			"crtBegin":      strings.Join(crtBegin.Strings(), " "),
+			"libFlags":      nativeLibFlags,
+			"extraLibFlags": flags.extraLibFlags,
+			"ldFlags":       ldFlags,
+			"crtEnd":        strings.Join(crtEnd.Strings(), " "),
+		},
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestDistributedThinLTO(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "foo",
+			srcs: ["foo.c"],
+			static_libs: ["libbar"],
+			lto: {
+				thin: true,
+				distributed: true,
+			},
+		}
+
+		cc_library_static {
+			name: "libbar",
+			srcs: ["bar.c"],
+		}
+	`
+	result := prepareForCcTest.RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "android_arm64_armv8-a")
+	index := foo.Description("thinlto index foo")
+	indexFiles := index.ImplicitOutputs.Strings()
+	android.AssertIntEquals(t, "index files", 2, len(indexFiles))
+	android.AssertStringListContains(t, "implicits", index.Implicits.Strings(),
+		foo.Output("thinlto/foo.thinlto.files").Output.String())
+
+	link := foo.Rule("ld")
+	for _, obj := range []string{"foo.o", "bar.o"} {
+		backend := foo.Description("thinlto backend " + obj)
+		indexFile := backend.Args["index"]
+		android.AssertStringListContains(t, "index files", indexFiles, indexFile)
+		android.AssertStringListContains(t, "backend implicits", backend.Implicits.Strings(), indexFile)
+		if !strings.HasSuffix(backend.Output.String(), strings.TrimSuffix(obj, ".o")+".native.o") {
+			t.Errorf("expected native object for %s, got %s", obj, backend.Output)
+		}
+
+		// The final link uses the native objects in place of the bitcode objects.
+		android.AssertStringDoesContain(t, "link", strings.Join(link.Inputs.Strings(), " ")+" "+
+			link.Args["libFlags"], backend.Output.String())
+	}
+
+	cflags := foo.Description("thinlto backend foo.o").Args["cFlags"]
+	android.AssertStringDoesNotContain(t, "backend cflags", cflags, "-flto")
+}
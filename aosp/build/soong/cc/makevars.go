@@ -140,7 +140,7 @@ func makeVarsProvider(ctx android.MakeVarsContext) {
 	ctx.Strict("EXPERIMENTAL_CPP_STD_VERSION", config.ExperimentalCppStdVersion)
 
 	ctx.Strict("DEFAULT_GLOBAL_TIDY_CHECKS", "${config.TidyDefaultGlobalChecks}")
-	ctx.Strict("DEFAULT_LOCAL_TIDY_CHECKS", joinLocalTidyChecks(config.DefaultLocalTidyChecks))
+	ctx.Strict("DEFAULT_LOCAL_TIDY_CHECKS", joinLocalTidyChecks(config.LoadTidyConfig(ctx)))
 	ctx.Strict("DEFAULT_TIDY_HEADER_DIRS", "${config.TidyDefaultHeaderDirs}")
 	ctx.Strict("WITH_TIDY_FLAGS", "${config.TidyWithTidyFlags}")
 
@@ -337,10 +337,10 @@ func splitSystemIncludes(ctx android.MakeVarsContext, val string) (includes, sys
 	return includes, systemIncludes
 }
 
-func joinLocalTidyChecks(checks []config.PathBasedTidyCheck) string {
-	rets := make([]string, len(checks))
-	for i, check := range config.DefaultLocalTidyChecks {
-		rets[i] = check.PathPrefix + ":" + check.Checks
+func joinLocalTidyChecks(tidyConfig *config.TidyConfig) string {
+	rets := make([]string, len(tidyConfig.Directories))
+	for i, dir := range tidyConfig.Directories {
+		rets[i] = dir.PathPrefix + ":" + tidyConfig.Checks(dir)
 	}
 	return strings.Join(rets, " ")
 }
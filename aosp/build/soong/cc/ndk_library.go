@@ -97,6 +97,11 @@ type libraryProperties struct {
 	// used. This is only needed to work around platform bugs like
 	// https://github.com/android-ndk/ndk/issues/265.
 	Unversioned_until *string
+
+	// How mismatches between the exports of the implementation library and the
+	// symbol file are reported. They are only checked here if the implementation
+	// library doesn't check them itself.
+	Symbol_file_check SymbolFileCheckProperties
 }
 
 type stubDecorator struct {
@@ -109,6 +114,7 @@ type stubDecorator struct {
 	installPath           android.Path
 	abiDumpPath           android.OutputPath
 	abiDiffPaths          android.Paths
+	exportsCheckPath      android.OptionalPath
 
 	apiLevel         android.ApiLevel
 	firstVersion     android.ApiLevel
@@ -269,7 +275,7 @@ func compileStubLibrary(ctx ModuleContext, flags Flags, src android.Path) Object
 		android.Paths{src}, nil, nil)
 }
 
-func (this *stubDecorator) findImplementationLibrary(ctx ModuleContext) android.Path {
+func (this *stubDecorator) findImplementationModule(ctx ModuleContext) *Module {
 	dep := ctx.GetDirectDepWithTag(strings.TrimSuffix(ctx.ModuleName(), ndkLibrarySuffix),
 		stubImplementation)
 	if dep == nil {
@@ -279,6 +285,15 @@ func (this *stubDecorator) findImplementationLibrary(ctx ModuleContext) android.
 	impl, ok := dep.(*Module)
 	if !ok {
 		ctx.ModuleErrorf("Implementation for stub is not correct module type")
+		return nil
+	}
+	return impl
+}
+
+func (this *stubDecorator) findImplementationLibrary(ctx ModuleContext) android.Path {
+	impl := this.findImplementationModule(ctx)
+	if impl == nil {
+		return nil
 	}
 	output := impl.UnstrippedOutputFile()
 	if output == nil {
@@ -403,6 +418,28 @@ func (this *stubDecorator) diffAbi(ctx ModuleContext) {
 	}
 }
 
+// checkImplementationExports checks the exports of the implementation library
+// against the symbol file, unless the implementation library already checks
+// them against its own symbol file when it is linked.
+func (c *stubDecorator) checkImplementationExports(ctx ModuleContext, f *symbolFile) {
+	impl := c.findImplementationModule(ctx)
+	if impl == nil {
+		return
+	}
+	if library, ok := impl.linker.(*libraryDecorator); ok {
+		if _, symbolFile := library.symbolFileForExportCheck(impl); symbolFile != nil {
+			return
+		}
+	}
+	implementationLibrary := c.findImplementationLibrary(ctx)
+	if implementationLibrary == nil {
+		return
+	}
+	missingLevel, unlistedLevel := c.properties.Symbol_file_check.levels(ctx)
+	c.exportsCheckPath = android.OptionalPathForPath(
+		checkSymbolFileExportsRule(ctx, f, implementationLibrary, missingLevel, unlistedLevel))
+}
+
 func (c *stubDecorator) compile(ctx ModuleContext, flags Flags, deps PathDeps) Objects {
 	if !strings.HasSuffix(String(c.properties.Symbol_file), ".map.txt") {
 		ctx.PropertyErrorf("symbol_file", "must end with .map.txt")
@@ -419,6 +456,13 @@ func (c *stubDecorator) compile(ctx ModuleContext, flags Flags, deps PathDeps) O
 	}
 
 	symbolFile := String(c.properties.Symbol_file)
+	// The symbol file and the implementation library are the same for every API
+	// level, so only lint and check them once.
+	if c.apiLevel.IsCurrent() {
+		if f := lintSymbolFile(ctx, "symbol_file", symbolFile); f != nil {
+			c.checkImplementationExports(ctx, f)
+		}
+	}
 	nativeAbiResult := parseNativeAbiDefinition(ctx, symbolFile, c.apiLevel, "")
 	objs := compileStubLibrary(ctx, flags, nativeAbiResult.stubSrc)
 	c.versionScriptPath = nativeAbiResult.versionScript
@@ -98,6 +98,7 @@ func (n *ndkSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 	var staticLibInstallPaths android.Paths
 	var installPaths android.Paths
 	var licensePaths android.Paths
+	var exportsCheckPaths android.Paths
 	ctx.VisitAllModules(func(module android.Module) {
 		if m, ok := module.(android.Module); ok && !m.Enabled() {
 			return
@@ -121,6 +122,9 @@ func (n *ndkSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 		if m, ok := module.(*Module); ok {
 			if installer, ok := m.installer.(*stubDecorator); ok && m.library.buildStubs() {
 				installPaths = append(installPaths, installer.installPath)
+				if installer.exportsCheckPath.Valid() {
+					exportsCheckPaths = append(exportsCheckPaths, installer.exportsCheckPath.Path())
+				}
 			}
 
 			if library, ok := m.linker.(*libraryDecorator); ok {
@@ -147,10 +151,10 @@ func (n *ndkSingleton) GenerateBuildActions(ctx android.SingletonContext) {
 	baseDepPaths := append(installPaths, combinedLicense)
 
 	ctx.Build(pctx, android.BuildParams{
-		Rule:       android.Touch,
-		Output:     getNdkBaseTimestampFile(ctx),
-		Implicits:  baseDepPaths,
-		Validation: getNdkAbiDiffTimestampFile(ctx),
+		Rule:        android.Touch,
+		Output:      getNdkBaseTimestampFile(ctx),
+		Implicits:   baseDepPaths,
+		Validations: append(android.Paths{getNdkAbiDiffTimestampFile(ctx)}, exportsCheckPaths...),
 	})
 
 	fullDepPaths := append(staticLibInstallPaths, getNdkBaseTimestampFile(ctx))
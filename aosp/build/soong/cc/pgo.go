@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -37,9 +38,61 @@ var (
 	}
 )
 
+func init() {
+	pctx.HostBinToolVariable("pgoReportCmd", "pgo_report")
+
+	android.RegisterSingletonType("pgo_report", pgoReportSingletonFactory)
+	android.RegisterSingletonType("pgo_train", pgoTrainSingletonFactory)
+}
+
+var pgoReport = pctx.AndroidStaticRule("pgoReport",
+	blueprint.RuleParams{
+		Command: "$pgoReportCmd --module $module --kind $kind --output $out $flags " +
+			"--llvm-profdata ${config.ClangBin}/llvm-profdata --llvm-nm ${config.ClangBin}/llvm-nm",
+		CommandDeps: []string{
+			"$pgoReportCmd",
+			"${config.ClangBin}/llvm-profdata",
+			"${config.ClangBin}/llvm-nm",
+		},
+	}, "module", "kind", "flags")
+
+var pgoReportSummary = pctx.AndroidStaticRule("pgoReportSummary",
+	blueprint.RuleParams{
+		Command:        "$pgoReportCmd --summary --output $out @${out}.rsp",
+		CommandDeps:    []string{"$pgoReportCmd"},
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "$in",
+	})
+
+var pgoTrain = pctx.AndroidStaticRule("pgoTrain",
+	blueprint.RuleParams{
+		Command: "rm -rf $rawDir && mkdir -p $rawDir && " +
+			"for b in $in; do LLVM_PROFILE_FILE=$rawDir/%p-%m.profraw $$b || exit 1; done && " +
+			"${config.ClangBin}/llvm-profdata merge -o $out $rawDir/*.profraw",
+		CommandDeps: []string{"${config.ClangBin}/llvm-profdata"},
+	}, "rawDir")
+
+// pgoUpdateProfile copies a profile collected by pgo-train-<module> over the profile in the source
+// tree. Its output is phony, so that the profiles are compared again every time the target is
+// built.
+var pgoUpdateProfile = pctx.AndroidStaticRule("pgoUpdateProfile",
+	blueprint.RuleParams{
+		Command: "if cmp -s $in $profileFile; then " +
+			"echo '$profileFile is up to date'; " +
+			"else " +
+			"mkdir -p $$(dirname $profileFile) && cp $in $profileFile && " +
+			"echo 'Updated $profileFile'; " +
+			"fi",
+	}, "profileFile")
+
 var pgoProfileProjectsConfigKey = android.NewOnceKey("PgoProfileProjects")
 
 const profileInstrumentFlag = "-fprofile-generate=/data/local/tmp"
+
+// Host binaries write their profiles to the path in LLVM_PROFILE_FILE, which the pgoTrain rule
+// sets.
+const hostPgoInstrumentFlag = "-fprofile-generate"
+
 const profileUseInstrumentFormat = "-fprofile-use=%s"
 const profileUseSamplingFormat = "-fprofile-sample-accurate -fprofile-sample-use=%s"
 
@@ -55,8 +108,10 @@ func recordMissingProfileFile(ctx BaseModuleContext, missing string) {
 
 type PgoProperties struct {
 	Pgo struct {
-		Instrumentation    *bool
-		Sampling           *bool   `android:"arch_variant"`
+		Instrumentation *bool
+		Sampling        *bool `android:"arch_variant"`
+		// The name of the profile in the PGO profile projects. Defaults to <module>.profdata
+		// for sampling PGO.
 		Profile_file       *string `android:"arch_variant"`
 		Benchmarks         []string
 		Enable_profile_use *bool `android:"arch_variant"`
@@ -73,6 +128,18 @@ type PgoProperties struct {
 
 type pgo struct {
 	Properties PgoProperties
+
+	// The profile the module is compiled with, if any.
+	profileFile android.OptionalPath
+
+	// A profile collected from the current sources of the module, if any, that the pgo report
+	// compares profileFile against.
+	currentProfileFile android.OptionalPath
+
+	// Where m pgo-train-<module> writes the profile it collects for an instrumented host module,
+	// and the profile in the source tree that m update-pgo-profile-<module> copies it to.
+	trainedProfileFile android.WritablePath
+	sourceProfileFile  string
 }
 
 func (props *PgoProperties) isInstrumentation() bool {
@@ -88,12 +155,17 @@ func (pgo *pgo) props() []interface{} {
 }
 
 func (props *PgoProperties) addInstrumentationProfileGatherFlags(ctx ModuleContext, flags Flags) Flags {
+	instrumentFlag := profileInstrumentFlag
+	if ctx.Host() {
+		instrumentFlag = hostPgoInstrumentFlag
+	}
+
 	// Add to C flags iff PGO is explicitly enabled for this module.
 	if props.ShouldProfileModule {
 		flags.Local.CFlags = append(flags.Local.CFlags, props.Pgo.Cflags...)
-		flags.Local.CFlags = append(flags.Local.CFlags, profileInstrumentFlag)
+		flags.Local.CFlags = append(flags.Local.CFlags, instrumentFlag)
 	}
-	flags.Local.LdFlags = append(flags.Local.LdFlags, profileInstrumentFlag)
+	flags.Local.LdFlags = append(flags.Local.LdFlags, instrumentFlag)
 	return flags
 }
 func (props *PgoProperties) addSamplingProfileGatherFlags(ctx ModuleContext, flags Flags) Flags {
@@ -101,8 +173,18 @@ func (props *PgoProperties) addSamplingProfileGatherFlags(ctx ModuleContext, fla
 	return flags
 }
 
+// profileFileName returns the name of the profile of the module in the PGO profile projects. A
+// module with sampling PGO that doesn't set profile_file discovers its profile by the name of the
+// module, <module>.profdata.
+func (props *PgoProperties) profileFileName(ctx BaseModuleContext) string {
+	if props.Pgo.Profile_file != nil {
+		return *props.Pgo.Profile_file
+	}
+	return ctx.ModuleName() + ".profdata"
+}
+
 func (props *PgoProperties) getPgoProfileFile(ctx BaseModuleContext) android.OptionalPath {
-	profileFile := *props.Pgo.Profile_file
+	profileFile := props.profileFileName(ctx)
 
 	// Test if the profile_file is present in any of the PGO profile projects
 	for _, profileProject := range getPgoProfileProjects(ctx.DeviceConfig()) {
@@ -133,12 +215,22 @@ func (props *PgoProperties) getPgoProfileFile(ctx BaseModuleContext) android.Opt
 	}
 
 	// Record that this module's profile file is absent
-	missing := *props.Pgo.Profile_file + ":" + ctx.ModuleDir() + "/Android.bp:" + ctx.ModuleName()
+	missing := profileFile + ":" + ctx.ModuleDir() + "/Android.bp:" + ctx.ModuleName()
 	recordMissingProfileFile(ctx, missing)
 
 	return android.OptionalPathForPath(nil)
 }
 
+// sourceProfileFile returns the source path a profile collected for the module is copied to so
+// that getPgoProfileFile finds it: over the current profile if there is one, or else in the first
+// PGO profile project.
+func (props *PgoProperties) sourceProfileFile(ctx ModuleContext) string {
+	if profileFile := props.getPgoProfileFile(ctx); profileFile.Valid() {
+		return profileFile.String()
+	}
+	return filepath.Join(getPgoProfileProjects(ctx.DeviceConfig())[0], *props.Pgo.Profile_file)
+}
+
 func (props *PgoProperties) profileUseFlag(ctx ModuleContext, file string) string {
 	if props.isInstrumentation() {
 		return fmt.Sprintf(profileUseInstrumentFormat, file)
@@ -155,15 +247,16 @@ func (props *PgoProperties) profileUseFlags(ctx ModuleContext, file string) []st
 	return flags
 }
 
-func (props *PgoProperties) addProfileUseFlags(ctx ModuleContext, flags Flags) Flags {
+func (pgo *pgo) addProfileUseFlags(ctx ModuleContext, flags Flags) Flags {
+	props := &pgo.Properties
+
 	// Return if 'pgo' property is not present in this module.
 	if !props.PgoPresent {
 		return flags
 	}
 
 	if props.PgoCompile {
-		profileFile := props.getPgoProfileFile(ctx)
-		profileFilePath := profileFile.Path()
+		profileFilePath := pgo.profileFile.Path()
 		profileUseFlags := props.profileUseFlags(ctx, profileFilePath.String())
 
 		flags.Local.CFlags = append(flags.Local.CFlags, profileUseFlags...)
@@ -194,13 +287,14 @@ func (props *PgoProperties) isPGO(ctx BaseModuleContext) bool {
 		return false
 	}
 
-	// profileKindPresent and filePresent are mandatory properties.
-	if !profileKindPresent || !filePresent {
+	// profileKindPresent and filePresent are mandatory properties, except that the profile of a
+	// module with sampling PGO is discovered by its name without profile_file.
+	if !profileKindPresent || (!filePresent && !isSampling) {
 		var missing []string
 		if !profileKindPresent {
 			missing = append(missing, "profile kind (either \"instrumentation\" or \"sampling\" property)")
 		}
-		if !filePresent {
+		if !filePresent && !isSampling {
 			missing = append(missing, "profile_file property")
 		}
 		missingProps := strings.Join(missing, ", ")
@@ -220,11 +314,6 @@ func (props *PgoProperties) isPGO(ctx BaseModuleContext) bool {
 }
 
 func (pgo *pgo) begin(ctx BaseModuleContext) {
-	// TODO Evaluate if we need to support PGO for host modules
-	if ctx.Host() {
-		return
-	}
-
 	// Check if PGO is needed for this module
 	pgo.Properties.PgoPresent = pgo.Properties.isPGO(ctx)
 
@@ -258,7 +347,8 @@ func (pgo *pgo) begin(ctx BaseModuleContext) {
 
 	// PGO profile use is not feasible for a Clang coverage build because
 	// -fprofile-use and -fprofile-instr-generate are incompatible.
-	if ctx.DeviceConfig().ClangCoverageEnabled() {
+	if ctx.DeviceConfig().ClangCoverageEnabled() ||
+		(ctx.Host() && ctx.Config().IsEnvTrue(envVariableHostNativeCoverage)) {
 		return
 	}
 
@@ -271,10 +361,6 @@ func (pgo *pgo) begin(ctx BaseModuleContext) {
 }
 
 func (pgo *pgo) flags(ctx ModuleContext, flags Flags) Flags {
-	if ctx.Host() {
-		return flags
-	}
-
 	// Deduce PgoInstrLink property i.e. whether this module needs to be
 	// linked with profile-generation flags.  Here, we're setting it if any
 	// dependency needs PGO instrumentation.  It is initially set in
@@ -309,19 +395,227 @@ func (pgo *pgo) flags(ctx ModuleContext, flags Flags) Flags {
 	}
 
 	props := pgo.Properties
+	if props.PgoCompile {
+		pgo.profileFile = props.getPgoProfileFile(ctx)
+	}
+
+	// Only the build OS variant is trained, as it is the one that can run the benchmarks. The
+	// profile it collects is also the current profile that the pgo report compares against, so m
+	// pgo-report-<module> trains the module when it is instrumented.
+	if ctx.Host() && ctx.Target().String() == ctx.Config().BuildOSTarget.String() &&
+		props.ShouldProfileModule && props.isInstrumentation() {
+		pgo.trainedProfileFile = android.PathForOutput(ctx, "pgo-train", ctx.ModuleName(),
+			filepath.Base(*props.Pgo.Profile_file))
+		pgo.currentProfileFile = android.OptionalPathForPath(pgo.trainedProfileFile)
+		pgo.sourceProfileFile = props.sourceProfileFile(ctx)
+	}
+
 	// Add flags to profile this module based on its profile_kind
 	if (props.ShouldProfileModule && props.isInstrumentation()) || props.PgoInstrLink {
 		// Instrumentation PGO use and gather flags cannot coexist.
 		return props.addInstrumentationProfileGatherFlags(ctx, flags)
 	} else if props.ShouldProfileModule && props.isSampling() {
 		flags = props.addSamplingProfileGatherFlags(ctx, flags)
-	} else if ctx.DeviceConfig().SamplingPGO() {
+	} else if ctx.Device() && ctx.DeviceConfig().SamplingPGO() {
 		flags = props.addSamplingProfileGatherFlags(ctx, flags)
 	}
 
 	if !ctx.Config().IsEnvTrue("ANDROID_PGO_NO_PROFILE_USE") {
-		flags = props.addProfileUseFlags(ctx, flags)
+		flags = pgo.addProfileUseFlags(ctx, flags)
 	}
 
 	return flags
 }
+
+func (props *PgoProperties) profileKind() string {
+	if props.isSampling() {
+		return "sampling"
+	}
+	return "instrumentation"
+}
+
+func pgoReportSingletonFactory() android.Singleton {
+	return &pgoReportSingleton{}
+}
+
+// pgoReportSingleton reports, for each variant of a module with a pgo property, which profile the
+// variant was compiled with. If a profile collected from the current sources of the module is
+// available, the report lists the functions whose control flow hash no longer matches the
+// profile. Sampling profiles are compared with the unstripped binary of the variant instead, and
+// the report lists the functions of the profile that the binary no longer defines. The reports
+// are written to
+// out/soong/pgo-reports/<module dir>/<module>/<variant>.json, built by m pgo-report-<module>,
+// and merged into out/soong/pgo-report.json, built by m pgo-report. The age of the profiles, from
+// the git history of the profile projects, depends on when the reports are read, so it isn't part
+// of them, e.g.
+//
+//	pgo_report --show out/soong/pgo-report.json
+type pgoReportSingleton struct{}
+
+func (p *pgoReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var allReports android.Paths
+	moduleReports := make(map[string]android.Paths)
+
+	ctx.VisitAllModules(func(module android.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() || ccModule.pgo == nil || !ccModule.pgo.Properties.PgoPresent {
+			return
+		}
+
+		name := ccModule.Name()
+		report := android.PathForOutput(ctx, "pgo-reports", ctx.ModuleDir(module), name,
+			ctx.ModuleSubDir(module)+".json")
+
+		var implicits android.Paths
+		var flags []string
+		if profileFile := ccModule.pgo.profileFile; profileFile.Valid() {
+			implicits = append(implicits, profileFile.Path())
+			flags = append(flags, "--profile "+profileFile.String())
+			if currentProfileFile := ccModule.pgo.currentProfileFile; currentProfileFile.Valid() {
+				implicits = append(implicits, currentProfileFile.Path())
+				flags = append(flags, "--current-profile "+currentProfileFile.String())
+			} else if binary := pgoReportBinary(ccModule); binary != nil {
+				implicits = append(implicits, binary)
+				flags = append(flags, "--binary "+binary.String())
+			}
+		}
+
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        pgoReport,
+			Description: "pgo report " + name,
+			Output:      report,
+			Implicits:   implicits,
+			Args: map[string]string{
+				"module": name,
+				"kind":   ccModule.pgo.Properties.profileKind(),
+				"flags":  strings.Join(flags, " "),
+			},
+		})
+
+		allReports = append(allReports, report)
+		moduleReports[name] = append(moduleReports[name], report)
+	})
+
+	for _, name := range android.SortedStringKeys(moduleReports) {
+		ctx.Phony("pgo-report-"+name, moduleReports[name]...)
+	}
+
+	if len(allReports) > 0 {
+		summary := android.PathForOutput(ctx, "pgo-report.json")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        pgoReportSummary,
+			Description: "pgo report summary",
+			Output:      summary,
+			Inputs:      allReports,
+		})
+		ctx.Phony("pgo-report", summary)
+	}
+}
+
+// pgoReportBinary returns the binary that the sampling profile of a module is compared with: the
+// unstripped binary or shared library, or the archive of a static library.
+func pgoReportBinary(c *Module) android.Path {
+	if !c.pgo.Properties.isSampling() {
+		return nil
+	}
+	if binary := c.UnstrippedOutputFile(); binary != nil {
+		return binary
+	}
+	if c.OutputFile().Valid() {
+		return c.OutputFile().Path()
+	}
+	return nil
+}
+
+// pgoTrainingBinary returns the installed binary of a host benchmark or test, which can be run
+// to collect a profile.
+func pgoTrainingBinary(c *Module) android.Path {
+	if !c.Host() || !c.OutputFile().Valid() {
+		return nil
+	}
+	switch installer := c.installer.(type) {
+	case *benchmarkDecorator:
+		return installer.baseInstaller.path
+	case *testBinary:
+		return installer.baseInstaller.path
+	}
+	return nil
+}
+
+func pgoTrainSingletonFactory() android.Singleton {
+	return &pgoTrainSingleton{}
+}
+
+// pgoTrainSingleton adds m pgo-train-<module> for each host module with instrumentation PGO that
+// is instrumented with ANDROID_PGO_INSTRUMENT. It runs the host benchmarks and tests listed in
+// pgo.benchmarks and merges the profiles they write into
+// out/soong/pgo-train/<module>/<profile_file>. Like update-abi-refs, copying the merged profile
+// to where getPgoProfileFile finds it is a separate step, m update-pgo-profile-<module>, e.g.
+//
+//	ANDROID_PGO_INSTRUMENT=aapt2_benchmark m pgo-train-aapt2
+//	ANDROID_PGO_INSTRUMENT=aapt2_benchmark m update-pgo-profile-aapt2
+type pgoTrainSingleton struct{}
+
+func (p *pgoTrainSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	buildOSTarget := ctx.Config().BuildOSTarget.String()
+
+	trainingBinaries := make(map[string]android.Paths)
+	// The static and shared variants of a library share a trained profile, so only one variant
+	// of each module is trained, preferring the shared library over the static one.
+	trainedModules := make(map[string]*Module)
+	var trainedNames []string
+	ctx.VisitAllModules(func(module android.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() || ccModule.Target().String() != buildOSTarget {
+			return
+		}
+		if binary := pgoTrainingBinary(ccModule); binary != nil {
+			trainingBinaries[ccModule.Name()] = append(trainingBinaries[ccModule.Name()], binary)
+		}
+		if ccModule.pgo != nil && ccModule.pgo.trainedProfileFile != nil && ccModule.OutputFile().Valid() {
+			name := ccModule.Name()
+			if trained, ok := trainedModules[name]; !ok {
+				trainedNames = append(trainedNames, name)
+				trainedModules[name] = ccModule
+			} else if trained.static() && !ccModule.static() {
+				trainedModules[name] = ccModule
+			}
+		}
+	})
+
+	for _, name := range trainedNames {
+		ccModule := trainedModules[name]
+		var binaries android.Paths
+		for _, benchmark := range ccModule.pgo.Properties.Pgo.Benchmarks {
+			binaries = append(binaries, trainingBinaries[benchmark]...)
+		}
+		if len(binaries) == 0 {
+			ctx.Errorf("module %q: none of the pgo benchmarks %q is a host benchmark or test",
+				name, ccModule.pgo.Properties.Pgo.Benchmarks)
+			continue
+		}
+
+		profile := ccModule.pgo.trainedProfileFile
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        pgoTrain,
+			Description: "pgo train " + name,
+			Output:      profile,
+			Inputs:      binaries,
+			Implicits:   android.Paths{ccModule.OutputFile().Path()},
+			Args: map[string]string{
+				"rawDir": android.PathForOutput(ctx, "pgo-train", name, "raw").String(),
+			},
+		})
+		ctx.Phony("pgo-train-"+name, profile)
+
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        pgoUpdateProfile,
+			Description: "update pgo profile " + name,
+			Output:      android.PathForPhony(ctx, "update-pgo-profile-"+name),
+			Input:       profile,
+			Args: map[string]string{
+				"profileFile": ccModule.pgo.sourceProfileFile,
+			},
+		})
+	}
+}
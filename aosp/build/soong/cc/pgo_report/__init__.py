@@ -0,0 +1,412 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Reports which PGO profile a module uses and whether it is stale.
+
+For a module variant with a pgo property, the report lists the profile that was
+found for it. If a profile collected from the current sources is available,
+e.g. by pgo-train-<module>, the functions of both profiles are compared with
+llvm-profdata show. Functions whose control flow hash differs were changed
+since the profile was collected, and their profile data is ignored by the
+compiler. Sampling profiles can't be collected by the build, so they are
+compared with the binary built from the current sources instead: functions of
+the profile that the binary doesn't define anymore were renamed or removed
+since the profile was collected.
+
+The reports are built by ninja, so they don't contain anything that changes
+without their inputs changing, like the age of the profile. The reports of all
+modules can be merged into a summary, and --show prints reports or summaries
+with the age of each profile. The age is that of the last commit that modified
+the profile in the git project of the profile, as a checkout or copy of the
+profile changes its mtime.
+"""
+import argparse
+from dataclasses import dataclass
+import json
+from pathlib import Path
+import re
+import subprocess
+import sys
+import time
+from typing import (Any, Callable, Dict, Iterable, List, Optional, Set, TextIO,
+                    Tuple)
+
+SECONDS_PER_DAY = 24 * 60 * 60
+
+# llvm-profdata show --all-functions lists the functions of an instrumentation
+# profile as "  <name>:" under "Counters:", followed by "    Hash: <hash>".
+INSTR_FUNCTION_RE = re.compile(r'^  (\S.*):$')
+INSTR_HASH_RE = re.compile(r'^\s+Hash: (0x[0-9a-fA-F]+)$')
+
+# llvm-profdata show --sample --all-functions lists the functions of a sampling
+# profile as "Function: <name>: <total>, <head>, <lines> sampled lines". The
+# functions of profiles with pseudo probes are followed by
+# "!CFGChecksum: <checksum>".
+SAMPLE_FUNCTION_RE = re.compile(
+    r'^Function: (.+): \d+, \d+, \d+ sampled lines$')
+SAMPLE_HASH_RE = re.compile(r'^\s*!CFGChecksum: (\d+)$')
+
+
+@dataclass
+class Report:
+    """The PGO report of a module variant."""
+    module: str
+    kind: str
+    profile: Optional[str] = None
+    profile_functions: int = 0
+    # The profile collected from the current sources, if one is available.
+    current_profile: Optional[str] = None
+    # The binary built from the current sources that a sampling profile is
+    # compared with, if there is no current profile.
+    binary: Optional[str] = None
+    # The functions whose hash changed or that are missing from the binary, or
+    # None if the profile wasn't compared with the current sources.
+    stale_functions: Optional[List[str]] = None
+
+    def to_json(self) -> Dict[str, Any]:
+        """Returns the representation of the report in JSON."""
+        return {
+            'module': self.module,
+            'kind': self.kind,
+            'profile': self.profile,
+            'profile_functions': self.profile_functions,
+            'current_profile': self.current_profile,
+            'binary': self.binary,
+            'stale_functions': self.stale_functions,
+        }
+
+    @staticmethod
+    def from_json(obj: Dict[str, Any]) -> 'Report':
+        """Reads a report written by to_json."""
+        return Report(module=obj['module'],
+                      kind=obj['kind'],
+                      profile=obj.get('profile'),
+                      profile_functions=obj.get('profile_functions', 0),
+                      current_profile=obj.get('current_profile'),
+                      binary=obj.get('binary'),
+                      stale_functions=obj.get('stale_functions'))
+
+
+def normalize_function_name(name: str) -> str:
+    """Returns the symbol name of a function in a profile.
+
+    Instrumentation profiles prefix functions with internal linkage with their
+    source file, e.g. "foo.cpp;_ZL3barv" or "foo.cpp:_ZL3barv". Sampling
+    profiles may keep suffixes added by the optimizer, e.g.
+    "_Z3foov.llvm.1234" or "_Z3foov.__uniq.5678".
+    """
+    name = re.split(r'[;:]', name)[-1]
+    return name.split('.', 1)[0]
+
+
+def parse_profile_hashes(show_output: Iterable[str],
+                         kind: str) -> Dict[str, Optional[str]]:
+    """Parses llvm-profdata show --all-functions.
+
+    Returns the hash of the control flow of each function, or None for
+    functions without a hash, like those of sampling profiles without pseudo
+    probes.
+    """
+    function_re = SAMPLE_FUNCTION_RE if kind == 'sampling' else INSTR_FUNCTION_RE
+    hash_re = SAMPLE_HASH_RE if kind == 'sampling' else INSTR_HASH_RE
+    hashes: Dict[str, Optional[str]] = {}
+    function = None
+    for line in show_output:
+        line = line.rstrip('\n')
+        match = function_re.match(line)
+        if match is not None:
+            function = normalize_function_name(match.group(1))
+            hashes.setdefault(function, None)
+            continue
+        match = hash_re.match(line)
+        if match is not None and function is not None:
+            hashes[function] = match.group(1)
+    return hashes
+
+
+def stale_functions(profile_hashes: Dict[str, Optional[str]],
+                    current_hashes: Dict[str, Optional[str]],
+                    kind: str) -> List[str]:
+    """Returns the functions of a profile that no longer match the sources.
+
+    Those are the functions whose hash differs from the hash in the profile
+    collected from the current sources. An instrumentation profile has every
+    instrumented function, even if it never ran, so functions that are missing
+    from the current profile were renamed or removed. A sampling profile only
+    has the functions that were sampled.
+    """
+    stale = []
+    for function, profile_hash in profile_hashes.items():
+        if function not in current_hashes:
+            if kind == 'instrumentation':
+                stale.append(function)
+            continue
+        current_hash = current_hashes[function]
+        if profile_hash is not None and current_hash is not None and (
+                profile_hash != current_hash):
+            stale.append(function)
+    return sorted(stale)
+
+
+def missing_functions(profile_hashes: Dict[str, Optional[str]],
+                      binary_functions: Set[str]) -> List[str]:
+    """Returns the functions of a profile that a binary doesn't define.
+
+    The top-level functions of a sampling profile are the functions that were
+    sampled outside of their callers, so they are still defined by a binary
+    built from the same sources.
+    """
+    return sorted(f for f in profile_hashes if f not in binary_functions)
+
+
+def parse_defined_functions(nm_output: Iterable[str]) -> Set[str]:
+    """Parses llvm-nm --defined-only --just-symbol-name.
+
+    The symbols of the members of an archive follow a "<member>:" line.
+    """
+    functions = set()
+    for line in nm_output:
+        line = line.strip()
+        if not line or line.endswith(':'):
+            continue
+        functions.add(normalize_function_name(line))
+    return functions
+
+
+def run_lines(cmd: List[str]) -> List[str]:
+    """Runs a command and returns the lines of its output."""
+    return subprocess.run(cmd,
+                          stdout=subprocess.PIPE,
+                          text=True,
+                          check=True).stdout.splitlines()
+
+
+def show_profile(llvm_profdata: Path, profile: Path,
+                 kind: str) -> Dict[str, Optional[str]]:
+    """Returns the function hashes of a profile."""
+    show = [str(llvm_profdata), 'show', '--all-functions']
+    if kind == 'sampling':
+        show.append('--sample')
+    return parse_profile_hashes(run_lines(show + [str(profile)]), kind)
+
+
+def defined_functions(llvm_nm: Path, binary: Path) -> Set[str]:
+    """Returns the functions defined by a binary, object or archive."""
+    return parse_defined_functions(
+        run_lines([
+            str(llvm_nm), '--defined-only', '--just-symbol-name',
+            str(binary)
+        ]))
+
+
+def last_commit_time(path: str) -> Optional[int]:
+    """Returns the time of the last commit that modified a file.
+
+    Returns None if the file isn't in a git project or was never committed.
+    """
+    result = subprocess.run(
+        ['git', 'log', '-1', '--format=%ct', '--',
+         Path(path).name],
+        cwd=Path(path).parent,
+        stdout=subprocess.PIPE,
+        stderr=subprocess.DEVNULL,
+        text=True,
+        check=False)
+    if result.returncode != 0 or not result.stdout.strip():
+        return None
+    return int(result.stdout.strip())
+
+
+def build_report(args: argparse.Namespace) -> Report:
+    """Builds the report of a module variant from the command line."""
+    report = Report(module=args.module, kind=args.kind)
+    if args.profile is None:
+        return report
+
+    report.profile = str(args.profile)
+    hashes = show_profile(args.llvm_profdata, args.profile, args.kind)
+    report.profile_functions = len(hashes)
+
+    if args.current_profile is not None:
+        report.current_profile = str(args.current_profile)
+        current_hashes = show_profile(args.llvm_profdata, args.current_profile,
+                                      args.kind)
+        report.stale_functions = stale_functions(hashes, current_hashes,
+                                                 args.kind)
+    elif args.kind == 'sampling' and args.binary is not None:
+        report.binary = str(args.binary)
+        report.stale_functions = missing_functions(
+            hashes, defined_functions(args.llvm_nm, args.binary))
+    return report
+
+
+def write_summary(summary_file: TextIO, reports: List[Report]) -> None:
+    """Writes the reports of all modules, those without a profile first."""
+    reports = sorted(reports, key=lambda r: (r.profile is not None, r.module))
+    json.dump([r.to_json() for r in reports],
+              summary_file,
+              indent=2,
+              sort_keys=True)
+    summary_file.write('\n')
+
+
+def show_reports(output: TextIO, reports: List[Report], now: float,
+                 commit_time: Callable[[str], Optional[int]]) -> None:
+    """Prints the reports with the age of their profiles at now.
+
+    The age of a profile is that of the last commit that modified it, as
+    returned by commit_time. The oldest profiles are printed first, after the
+    modules without a profile and before the profiles that aren't committed.
+    """
+    committed: Dict[str, Optional[int]] = {}
+    for report in reports:
+        if report.profile is not None and report.profile not in committed:
+            committed[report.profile] = commit_time(report.profile)
+
+    def sort_key(report: Report) -> Tuple[int, float, str]:
+        if report.profile is None:
+            return (0, 0, report.module)
+        time_committed = committed[report.profile]
+        if time_committed is None:
+            return (2, 0, report.module)
+        return (1, time_committed, report.module)
+
+    for report in sorted(reports, key=sort_key):
+        if report.profile is None:
+            output.write(f'{report.module}: no {report.kind} profile found\n')
+            continue
+        time_committed = committed[report.profile]
+        if time_committed is None:
+            line = f'{report.module}: {report.profile} is not committed'
+        else:
+            age = int((now - time_committed) // SECONDS_PER_DAY)
+            line = f'{report.module}: {report.profile} is {age} days old'
+        if report.stale_functions is None:
+            line += ', not compared with the current sources'
+        else:
+            line += (f', {len(report.stale_functions)} of '
+                     f'{report.profile_functions} functions are stale')
+        output.write(line + '\n')
+        for function in report.stale_functions or []:
+            output.write(f'  {function}\n')
+
+
+def read_reports(paths: List[Path]) -> List[Report]:
+    """Reads module reports and summaries."""
+    reports = []
+    for path in paths:
+        with path.open() as report_file:
+            obj = json.load(report_file)
+        if isinstance(obj, list):
+            reports.extend(Report.from_json(r) for r in obj)
+        else:
+            reports.append(Report.from_json(obj))
+    return reports
+
+
+def expand_inputs(inputs: List[str]) -> List[Path]:
+    """Expands @file arguments to the whitespace separated list in file."""
+    paths = []
+    for arg in inputs:
+        if arg.startswith('@'):
+            with open(arg[1:]) as rsp_file:
+                paths.extend(Path(p) for p in rsp_file.read().split())
+        else:
+            paths.append(Path(arg))
+    return paths
+
+
+def parse_args() -> argparse.Namespace:
+    """Parses and returns command line arguments."""
+    parser = argparse.ArgumentParser()
+
+    parser.add_argument('--output',
+                        type=Path,
+                        help='Path to write the report to.')
+    parser.add_argument('--module', help='Name of the module.')
+    parser.add_argument('--kind',
+                        choices=['instrumentation', 'sampling'],
+                        help='Kind of the profile.')
+    parser.add_argument('--profile',
+                        type=Path,
+                        help='Profile used by the module, if one was found.')
+    parser.add_argument(
+        '--current-profile',
+        type=Path,
+        help='Profile collected from the current sources of the module.')
+    parser.add_argument(
+        '--binary',
+        type=Path,
+        help='Binary built from the current sources of the module, that a '
+        'sampling profile is compared with.')
+    parser.add_argument('--llvm-profdata',
+                        type=Path,
+                        default=Path('llvm-profdata'),
+                        help='Path to llvm-profdata.')
+    parser.add_argument('--llvm-nm',
+                        type=Path,
+                        default=Path('llvm-nm'),
+                        help='Path to llvm-nm.')
+    parser.add_argument(
+        '--summary',
+        action='store_true',
+        help='Merge the module reports given as inputs into a summary.')
+    parser.add_argument(
+        '--show',
+        action='store_true',
+        help='Print the reports or summaries given as inputs with the age of '
+        'their profiles.')
+    parser.add_argument('inputs',
+                        nargs='*',
+                        help='Module reports to summarize, or @file lists.')
+
+    return parser.parse_args()
+
+
+def main() -> None:
+    """Program entry point."""
+    args = parse_args()
+
+    if args.show:
+        show_reports(sys.stdout, read_reports(expand_inputs(args.inputs)),
+                     time.time(), last_commit_time)
+        return
+
+    if args.output is None:
+        sys.exit('error: --output is required')
+
+    if args.summary:
+        reports = read_reports(expand_inputs(args.inputs))
+        with args.output.open('w') as summary_file:
+            write_summary(summary_file, reports)
+        return
+
+    if args.module is None or args.kind is None:
+        sys.exit('error: --module and --kind are required')
+
+    report = build_report(args)
+    with args.output.open('w') as report_file:
+        json.dump(report.to_json(), report_file, indent=2, sort_keys=True)
+        report_file.write('\n')
+
+    if report.stale_functions:
+        print(f'{report.module}: {len(report.stale_functions)} of '
+              f'{report.profile_functions} functions in {report.profile} '
+              'changed since the profile was collected')
+
+
+if __name__ == '__main__':
+    main()
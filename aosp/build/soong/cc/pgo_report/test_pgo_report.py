@@ -0,0 +1,193 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for pgo_report."""
+import io
+import json
+import os
+from pathlib import Path
+import subprocess
+import tempfile
+import textwrap
+import unittest
+from unittest import mock
+
+import pgo_report
+from pgo_report import Report
+
+# pylint: disable=missing-docstring
+
+
+class ParseProfileHashesTest(unittest.TestCase):
+    def test_instrumentation(self) -> None:
+        show_output = textwrap.dedent("""\
+            Counters:
+              _Z3foov:
+                Hash: 0x0000000000000018
+                Counters: 2
+                Function count: 10
+              frameworks/foo/foo.cpp;_ZL3barv:
+                Hash: 0x0000000000000004
+                Counters: 1
+                Function count: 3
+            Instrumentation level: Front-end
+            Functions shown: 2
+            Total functions: 2
+            """).splitlines()
+        self.assertEqual(
+            {
+                '_Z3foov': '0x0000000000000018',
+                '_ZL3barv': '0x0000000000000004',
+            },
+            pgo_report.parse_profile_hashes(show_output, 'instrumentation'))
+
+    def test_sampling(self) -> None:
+        show_output = textwrap.dedent("""\
+            Function: _Z3foov: 1234, 10, 4 sampled lines
+            Samples collected in the function's body {
+              0: 10
+            }
+            !CFGChecksum: 563022570642068
+            Function: _Z3barv.llvm.42: 20, 1, 1 sampled lines
+            Samples collected in the function's body {
+              1: 20
+            }
+            """).splitlines()
+        self.assertEqual({
+            '_Z3foov': '563022570642068',
+            '_Z3barv': None,
+        }, pgo_report.parse_profile_hashes(show_output, 'sampling'))
+
+
+class StaleFunctionsTest(unittest.TestCase):
+    def test_changed_hash(self) -> None:
+        profile = {'_Z3foov': '0x1', '_Z3barv': '0x2', '_Z3bazv': None}
+        current = {'_Z3foov': '0x1', '_Z3barv': '0x3', '_Z3bazv': '0x4'}
+        self.assertEqual(['_Z3barv'],
+                         pgo_report.stale_functions(profile, current,
+                                                    'instrumentation'))
+
+    def test_missing_function(self) -> None:
+        profile = {'_Z3foov': '0x1', '_Z3barv': '0x2'}
+        current = {'_Z3foov': '0x1'}
+        self.assertEqual(['_Z3barv'],
+                         pgo_report.stale_functions(profile, current,
+                                                    'instrumentation'))
+        # Sampling profiles only have the functions that were sampled.
+        self.assertEqual([],
+                         pgo_report.stale_functions(profile, current,
+                                                    'sampling'))
+
+
+class MissingFunctionsTest(unittest.TestCase):
+    def test_archive(self) -> None:
+        nm_output = textwrap.dedent("""\
+
+            foo.o:
+            _Z3foov
+            _Z3barv.llvm.42
+
+            baz.o:
+            _ZL3bazv
+            """).splitlines()
+        defined = pgo_report.parse_defined_functions(nm_output)
+        self.assertEqual({'_Z3foov', '_Z3barv', '_ZL3bazv'}, defined)
+
+        profile = {'_Z3foov': None, '_Z3barv': '1', '_Z3quxv': None}
+        self.assertEqual(['_Z3quxv'],
+                         pgo_report.missing_functions(profile, defined))
+
+
+class SummaryTest(unittest.TestCase):
+    def test_missing_first(self) -> None:
+        missing = Report('libmissing', 'sampling')
+        bar = Report('libbar', 'sampling', 'bar.profdata', 10, None,
+                     'libbar.so', [])
+        foo = Report('libfoo', 'instrumentation', 'foo.profdata', 10,
+                     'current.profdata', None, ['_Z3foov'])
+        summary_file = io.StringIO()
+        pgo_report.write_summary(summary_file, [foo, missing, bar])
+        summary_file.seek(0)
+        self.assertEqual(
+            [missing, bar, foo],
+            [Report.from_json(r) for r in json.load(summary_file)])
+
+
+class ShowReportsTest(unittest.TestCase):
+    def test_age(self) -> None:
+        day = pgo_report.SECONDS_PER_DAY
+        reports = [
+            Report('libnew', 'sampling', 'new.profdata', 10, None,
+                   'libnew.so', []),
+            Report('libuncommitted', 'sampling', 'uncommitted.profdata', 10),
+            Report('libmissing', 'sampling'),
+            Report('libold', 'instrumentation', 'old.profdata', 10,
+                   'current.profdata', None, ['_Z3foov']),
+        ]
+        committed = {'new.profdata': 9 * day, 'old.profdata': 0}
+        output = io.StringIO()
+        pgo_report.show_reports(output, reports, 10 * day, committed.get)
+        self.assertEqual(
+            textwrap.dedent("""\
+                libmissing: no sampling profile found
+                libold: old.profdata is 10 days old, 1 of 10 functions are stale
+                  _Z3foov
+                libnew: new.profdata is 1 days old, 0 of 10 functions are stale
+                libuncommitted: uncommitted.profdata is not committed, not compared with the current sources
+                """), output.getvalue())
+
+
+class LastCommitTimeTest(unittest.TestCase):
+    def test_git(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            env = dict(os.environ,
+                       GIT_AUTHOR_DATE='@1000000000 +0000',
+                       GIT_COMMITTER_DATE='@1000000000 +0000')
+
+            def git(*args: str) -> None:
+                subprocess.run(['git', '-c', 'user.name=test', '-c',
+                                'user.email=test@example.com', *args],
+                               cwd=tmp, env=env, check=True,
+                               stdout=subprocess.DEVNULL)
+
+            git('init', '-q')
+            profile = Path(tmp, 'foo.profdata')
+            profile.write_text('profile')
+            self.assertIsNone(pgo_report.last_commit_time(str(profile)))
+
+            git('add', 'foo.profdata')
+            git('commit', '-q', '-m', 'Add profile')
+            # Checking out the profile again doesn't change its age.
+            profile.touch()
+            self.assertEqual(1000000000,
+                             pgo_report.last_commit_time(str(profile)))
+
+    def test_not_in_git(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            profile = Path(tmp, 'foo.profdata')
+            profile.write_text('profile')
+            # Don't find a git project that the temporary directory is in.
+            with mock.patch.dict(os.environ,
+                                 {'GIT_CEILING_DIRECTORIES': tmp}):
+                self.assertIsNone(pgo_report.last_commit_time(str(profile)))
+
+def main() -> None:
+    suite = unittest.TestLoader().loadTestsFromName(__name__)
+    unittest.TextTestRunner(verbosity=3).run(suite)
+
+
+if __name__ == '__main__':
+    main()
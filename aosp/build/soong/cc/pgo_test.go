@@ -0,0 +1,210 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"strings"
+	"testing"
+
+	"android/soong/android"
+)
+
+const pgoTestBp = `
+	cc_binary {
+		name: "foo",
+		host_supported: true,
+		srcs: ["foo.c"],
+		pgo: {
+			instrumentation: true,
+			profile_file: "foo/foo.profdata",
+			benchmarks: ["foo_test"],
+		},
+	}
+
+	cc_test {
+		name: "foo_test",
+		host_supported: true,
+		gtest: false,
+		srcs: ["foo_test.c"],
+	}
+`
+
+var prepareForPgoTest = android.GroupFixturePreparers(
+	prepareForCcTest,
+	android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+		ctx.RegisterSingletonType("pgo_report", pgoReportSingletonFactory)
+		ctx.RegisterSingletonType("pgo_train", pgoTrainSingletonFactory)
+	}),
+)
+
+func TestPgoHostProfileUse(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForPgoTest,
+		android.MockFS{
+			"toolchain/pgo-profiles/foo/foo.profdata": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, pgoTestBp)
+
+	foo := result.ModuleForTests("foo", "linux_glibc_x86_64")
+	cflags := strings.Fields(foo.Rule("cc").Args["cFlags"])
+	android.AssertStringListContains(t, "cflags", cflags,
+		"-fprofile-use=toolchain/pgo-profiles/foo/foo.profdata")
+	android.AssertStringListDoesNotContain(t, "cflags", cflags, hostPgoInstrumentFlag)
+
+	// Modules are only trained when they are instrumented.
+	if result.SingletonForTests("pgo_train").MaybeOutput("pgo-train/foo/foo.profdata").Rule != nil {
+		t.Errorf("expected no pgo-train rule for an uninstrumented module")
+	}
+}
+
+func TestPgoTrain(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForPgoTest,
+		android.FixtureMergeEnv(map[string]string{
+			"ANDROID_PGO_INSTRUMENT": "foo_test",
+		}),
+		android.MockFS{
+			"toolchain/pgo-profiles/foo/foo.profdata": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, pgoTestBp)
+
+	foo := result.ModuleForTests("foo", "linux_glibc_x86_64")
+	android.AssertStringListContains(t, "cflags",
+		strings.Fields(foo.Rule("cc").Args["cFlags"]), hostPgoInstrumentFlag)
+	android.AssertStringListContains(t, "ldflags",
+		strings.Fields(foo.Rule("ld").Args["ldFlags"]), hostPgoInstrumentFlag)
+
+	train := result.SingletonForTests("pgo_train")
+	profile := train.Output("pgo-train/foo/foo.profdata")
+	android.AssertIntEquals(t, "training binaries", 1, len(profile.Inputs))
+	android.AssertStringEquals(t, "training binary", "foo_test", profile.Inputs[0].Base())
+
+	// The trained profile is only copied to the source tree by update-pgo-profile-foo.
+	update := train.Output("update-pgo-profile-foo")
+	android.AssertStringEquals(t, "input", profile.Output.String(), update.Input.String())
+	android.AssertStringEquals(t, "profileFile",
+		"toolchain/pgo-profiles/foo/foo.profdata", update.Args["profileFile"])
+
+	// The report compares the checked in profile against the trained one.
+	report := result.SingletonForTests("pgo_report").Output("pgo-reports/foo/linux_glibc_x86_64.json")
+	android.AssertStringDoesContain(t, "report flags", report.Args["flags"],
+		"--current-profile "+profile.Output.String())
+}
+
+func TestPgoTrainWithoutProfile(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForPgoTest,
+		android.FixtureMergeEnv(map[string]string{
+			"ANDROID_PGO_INSTRUMENT": "foo_test",
+		}),
+	).RunTestWithBp(t, pgoTestBp)
+
+	// A module without a profile yet gets one in the first PGO profile project.
+	update := result.SingletonForTests("pgo_train").Output("update-pgo-profile-foo")
+	android.AssertStringEquals(t, "profileFile",
+		"toolchain/pgo-profiles/foo/foo.profdata", update.Args["profileFile"])
+}
+
+func TestPgoTrainLibrary(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForPgoTest,
+		android.FixtureMergeEnv(map[string]string{
+			"ANDROID_PGO_INSTRUMENT": "libfoo_test",
+		}),
+	).RunTestWithBp(t, `
+		cc_library {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+			pgo: {
+				instrumentation: true,
+				profile_file: "libfoo/libfoo.profdata",
+				benchmarks: ["libfoo_test"],
+			},
+		}
+
+		cc_test {
+			name: "libfoo_test",
+			host_supported: true,
+			gtest: false,
+			srcs: ["foo_test.c"],
+			shared_libs: ["libfoo"],
+		}
+	`)
+
+	// The static and shared variants share a trained profile, so only the shared library is
+	// trained.
+	train := result.SingletonForTests("pgo_train")
+	var profiles, updates int
+	for _, output := range train.AllOutputs() {
+		switch {
+		case strings.HasSuffix(output, "pgo-train/libfoo/libfoo.profdata"):
+			profiles++
+		case strings.HasSuffix(output, "update-pgo-profile-libfoo"):
+			updates++
+		}
+	}
+	android.AssertIntEquals(t, "pgo-train rules", 1, profiles)
+	android.AssertIntEquals(t, "update-pgo-profile rules", 1, updates)
+
+	profile := train.Output("pgo-train/libfoo/libfoo.profdata")
+	android.AssertIntEquals(t, "implicits", 1, len(profile.Implicits))
+	android.AssertStringEquals(t, "trained library", "libfoo.so", profile.Implicits[0].Base())
+}
+
+func TestPgoSamplingProfileDiscovery(t *testing.T) {
+	result := android.GroupFixturePreparers(
+		prepareForPgoTest,
+		android.MockFS{
+			"toolchain/pgo-profiles/libbar.profdata": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, `
+		cc_library_shared {
+			name: "libbar",
+			srcs: ["bar.c"],
+			pgo: {
+				sampling: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbaz",
+			srcs: ["baz.c"],
+			pgo: {
+				sampling: true,
+			},
+		}
+	`)
+
+	// The profile of libbar is discovered by its name.
+	libbar := result.ModuleForTests("libbar", "android_arm64_armv8-a_shared")
+	android.AssertStringDoesContain(t, "cflags", libbar.Rule("cc").Args["cFlags"],
+		"-fprofile-sample-use=toolchain/pgo-profiles/libbar.profdata")
+
+	// The sampling profile is compared with the unstripped library.
+	report := result.SingletonForTests("pgo_report").Output(
+		"pgo-reports/libbar/android_arm64_armv8-a_shared.json")
+	unstripped := libbar.Module().(*Module).UnstrippedOutputFile()
+	android.AssertStringDoesContain(t, "report flags", report.Args["flags"],
+		"--profile toolchain/pgo-profiles/libbar.profdata --binary "+unstripped.String())
+
+	// libbaz doesn't have a profile.
+	libbaz := result.ModuleForTests("libbaz", "android_arm64_armv8-a_shared")
+	android.AssertStringDoesNotContain(t, "cflags", libbaz.Rule("cc").Args["cFlags"],
+		"-fprofile-sample-use")
+	report = result.SingletonForTests("pgo_report").Output(
+		"pgo-reports/libbaz/android_arm64_armv8-a_shared.json")
+	android.AssertStringEquals(t, "report flags", "", report.Args["flags"])
+}
@@ -15,8 +15,12 @@
 package cc
 
 import (
+	"path/filepath"
+	"strings"
 	"sync"
 
+	"github.com/google/blueprint"
+
 	"android/soong/android"
 	"android/soong/cc/config"
 )
@@ -26,6 +30,37 @@ var (
 	lsdumpPathsLock sync.Mutex
 )
 
+func init() {
+	android.RegisterSingletonType("abi_report", abiReportSingletonFactory)
+	android.RegisterSingletonType("update_abi_refs", updateAbiRefsSingletonFactory)
+}
+
+var abiReportMerge = pctx.AndroidStaticRule("abiReportMerge",
+	blueprint.RuleParams{
+		Command:        "$abiDiffReportCmd --merge --output $out @${out}.rsp",
+		CommandDeps:    []string{"$abiDiffReportCmd"},
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "$in",
+	})
+
+// updateAbiRef gzips a linked ABI dump to the reference dump in the source tree, or only prints
+// what would change if dryRun is set. An existing uncompressed reference dump is replaced, as
+// getRefAbiDumpFile doesn't allow both. Its outputs are phony, so that the reference dump is
+// compared again every time the target is built.
+var updateAbiRef = pctx.AndroidStaticRule("updateAbiRef",
+	blueprint.RuleParams{
+		Command: "if ([ -f $refDump ] && [ ! -f $textRefDump ] && gunzip -c $refDump | cmp -s - $in) || " +
+			"([ -f $textRefDump ] && [ ! -f $refDump ] && cmp -s $textRefDump $in); then " +
+			"echo '$refDump is up to date'; " +
+			"elif [ -n '$dryRun' ]; then " +
+			"echo 'Would update $refDump'; " +
+			"if [ -f $textRefDump ]; then echo 'Would remove $textRefDump'; fi; " +
+			"else " +
+			"mkdir -p $$(dirname $refDump) && gzip -c -n $in > $refDump && rm -f $textRefDump && " +
+			"echo 'Updated $refDump'; " +
+			"fi",
+	}, "refDump", "textRefDump", "dryRun")
+
 type SAbiProperties struct {
 	// Whether ABI dump should be created for this module.
 	// Set by `sabiDepsMutator` if this module is a shared library that needs ABI check, or a static
@@ -70,6 +105,13 @@ func classifySourceAbiDump(ctx android.BaseModuleContext) string {
 	if m.library.headerAbiCheckerExplicitlyDisabled() {
 		return ""
 	}
+	// Host libraries are only checked if they enable the checker.
+	if m.Host() {
+		if m.library.headerAbiCheckerEnabled() {
+			return "HOST"
+		}
+		return ""
+	}
 	// Return NDK if the library is both NDK and LLNDK.
 	if m.IsNdk(ctx.Config()) {
 		return "NDK"
@@ -101,8 +143,8 @@ func classifySourceAbiDump(ctx android.BaseModuleContext) string {
 // Called from sabiDepsMutator to check whether ABI dumps should be created for this module.
 // ctx should be wrapping a native library type module.
 func shouldCreateSourceAbiDumpForLibrary(ctx android.BaseModuleContext) bool {
-	// Only generate ABI dump for device modules.
-	if !ctx.Device() {
+	// Only generate ABI dump for device modules and ELF host modules.
+	if !ctx.Device() && !isAbiCheckedHostOs(ctx.Os()) {
 		return false
 	}
 
@@ -167,6 +209,42 @@ func shouldCreateSourceAbiDumpForLibrary(ctx android.BaseModuleContext) bool {
 	return classifySourceAbiDump(ctx) != ""
 }
 
+// Returns true if the ABI of libraries for the host OS can be checked. header-abi-linker only reads
+// the symbols of ELF files.
+func isAbiCheckedHostOs(os android.OsType) bool {
+	return os == android.Linux || os == android.LinuxMusl
+}
+
+// Returns the directory of the reference ABI dumps of host libraries, which are kept per host OS
+// and architecture, e.g. prebuilts/abi-dumps/host/linux_glibc/x86_64/source-based.
+func hostRefAbiDumpDir(os android.OsType, arch android.ArchType) string {
+	return filepath.Join("prebuilts", "abi-dumps", "host", os.String(), arch.String(), "source-based")
+}
+
+// refAbiDumpDir returns the directory of the reference ABI dumps of a library variant, which are
+// read by getRefAbiDumpFile and written by update-abi-refs-<module>. The logic must be consistent
+// with classifySourceAbiDump.
+func refAbiDumpDir(ctx ModuleContext, vndkVersion string) string {
+	if ctx.Host() {
+		return hostRefAbiDumpDir(ctx.Os(), ctx.Arch().ArchType)
+	}
+
+	dirName := "platform"
+	if ctx.isNdk(ctx.Config()) {
+		dirName = "ndk"
+	} else if ctx.IsLlndkPublic() || (ctx.useVndk() && ctx.isVndk()) {
+		dirName = "vndk"
+	}
+
+	archNameAndVariant := ctx.Arch().ArchType.String()
+	if ctx.Arch().ArchVariant != "" {
+		archNameAndVariant += "_" + ctx.Arch().ArchVariant
+	}
+
+	return filepath.Join("prebuilts", "abi-dumps", dirName, vndkVersion,
+		ctx.DeviceConfig().BinderBitness(), archNameAndVariant, "source-based")
+}
+
 // Mark the direct and transitive dependencies of libraries that need ABI check, so that ABI dumps
 // of their dependencies would be generated.
 func sabiDepsMutator(mctx android.TopDownMutatorContext) {
@@ -200,3 +278,109 @@ func addLsdumpPath(lsdumpPath string) {
 	defer lsdumpPathsLock.Unlock()
 	lsdumpPaths = append(lsdumpPaths, lsdumpPath)
 }
+
+func abiReportSingletonFactory() android.Singleton {
+	return &abiReportSingleton{}
+}
+
+// abiReportSingleton merges the JSON reports of the ABI diffs of all libraries into a single
+// report at out/soong/abi-report.json, grouped by the class returned by classifySourceAbiDump.
+// It is built with m abi-report, and copied to the dist dir by m dist abi-report.
+type abiReportSingleton struct {
+	report android.OptionalPath
+}
+
+func (a *abiReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var reports android.Paths
+	ctx.VisitAllModules(func(module android.Module) {
+		c, ok := module.(*Module)
+		if !ok || !c.Enabled() {
+			return
+		}
+		if library, ok := c.linker.(*libraryDecorator); ok && library.sAbiDiffReport.Valid() {
+			reports = append(reports, library.sAbiDiffReport.Path())
+		}
+	})
+
+	if len(reports) > 0 {
+		report := android.PathForOutput(ctx, "abi-report.json")
+		ctx.Build(pctx, android.BuildParams{
+			Rule:        abiReportMerge,
+			Description: "abi report",
+			Output:      report,
+			Inputs:      reports,
+		})
+		ctx.Phony("abi-report", report)
+		a.report = android.OptionalPathForPath(report)
+	}
+}
+
+func (a *abiReportSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if !a.report.Valid() {
+		return
+	}
+
+	ctx.DistForGoal("abi-report", a.report.Path())
+}
+
+func updateAbiRefsSingletonFactory() android.Singleton {
+	return &updateAbiRefsSingleton{}
+}
+
+// updateAbiRefsSingleton defines update-abi-refs-<module> to write the linked ABI dumps of every
+// variant of a library to its reference dumps, and update-abi-refs-<module>-dry-run to list the
+// reference dumps that would change.
+type updateAbiRefsSingleton struct{}
+
+func (u *updateAbiRefsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	updates := make(map[string]android.Paths)
+	dryRuns := make(map[string]android.Paths)
+	seenRefDumps := make(map[string]bool)
+	ctx.VisitAllModules(func(module android.Module) {
+		c, ok := module.(*Module)
+		if !ok || !c.Enabled() {
+			return
+		}
+		library, ok := c.linker.(*libraryDecorator)
+		if !ok || !library.sAbiOutputFile.Valid() || library.sAbiRefDumpFile == "" {
+			return
+		}
+		// Variants that share a reference dump, e.g. APEX variants, are only written once.
+		refDump := library.sAbiRefDumpFile
+		if seenRefDumps[refDump] {
+			return
+		}
+		seenRefDumps[refDump] = true
+
+		name := c.Name()
+		for _, dryRun := range []bool{false, true} {
+			output := android.PathForPhony(ctx, "update-abi-refs-"+name+"-"+ctx.ModuleSubDir(module))
+			dryRunArg := ""
+			if dryRun {
+				output = android.PathForPhony(ctx, "update-abi-refs-"+name+"-dry-run-"+ctx.ModuleSubDir(module))
+				dryRunArg = "true"
+			}
+			ctx.Build(pctx, android.BuildParams{
+				Rule:        updateAbiRef,
+				Description: "update abi ref " + filepath.Base(refDump),
+				Output:      output,
+				Input:       library.sAbiOutputFile.Path(),
+				Args: map[string]string{
+					"refDump":     refDump,
+					"textRefDump": strings.TrimSuffix(refDump, ".gz"),
+					"dryRun":      dryRunArg,
+				},
+			})
+			if dryRun {
+				dryRuns[name] = append(dryRuns[name], output)
+			} else {
+				updates[name] = append(updates[name], output)
+			}
+		}
+	})
+
+	for _, name := range android.SortedStringKeys(updates) {
+		ctx.Phony("update-abi-refs-"+name, updates[name]...)
+		ctx.Phony("update-abi-refs-"+name+"-dry-run", dryRuns[name]...)
+	}
+}
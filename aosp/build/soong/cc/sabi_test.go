@@ -0,0 +1,103 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestHostAbiCheck(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			host_supported: true,
+			export_include_dirs: ["include"],
+			header_abi_checker: {
+				enabled: true,
+			},
+		}
+
+		cc_library_shared {
+			name: "libbar",
+			host_supported: true,
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.MockFS{
+			"prebuilts/abi-dumps/host/linux_glibc/x86_64/source-based/libfoo.so.lsdump": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	libfoo := result.ModuleForTests("libfoo", "linux_glibc_x86_64_shared")
+	libfoo.Output("libfoo.so.lsdump")
+
+	diff := libfoo.Output("libfoo.so.abidiff")
+	android.AssertStringEquals(t, "reference dump",
+		"prebuilts/abi-dumps/host/linux_glibc/x86_64/source-based/libfoo.so.lsdump",
+		diff.Args["referenceDump"])
+
+	check := libfoo.Output("libfoo.so.abidiff.check")
+	android.AssertStringEquals(t, "hint", "m update-abi-refs-libfoo", check.Args["hint"])
+
+	report := libfoo.Output("libfoo.so.abidiff.json")
+	android.AssertStringEquals(t, "ABI class", "HOST", report.Args["abiClass"])
+
+	// Host libraries are only checked if they enable the checker.
+	libbar := result.ModuleForTests("libbar", "linux_glibc_x86_64_shared")
+	if libbar.MaybeOutput("libbar.so.lsdump").Rule != nil {
+		t.Errorf("expected no ABI dump for libbar")
+	}
+}
+
+func TestUpdateAbiRefs(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			host_supported: true,
+			header_abi_checker: {
+				enabled: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("update_abi_refs", updateAbiRefsSingletonFactory)
+		}),
+		android.MockFS{
+			"prebuilts/abi-dumps/host/linux_glibc/x86_64/source-based/libfoo.so.lsdump": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	singleton := result.SingletonForTests("update_abi_refs")
+	variant := "linux_glibc_x86_64_shared"
+	lsdump := result.ModuleForTests("libfoo", variant).Output("libfoo.so.lsdump").Output
+
+	update := singleton.Output("update-abi-refs-libfoo-" + variant)
+	android.AssertStringEquals(t, "input", lsdump.String(), update.Input.String())
+	android.AssertStringEquals(t, "refDump",
+		"prebuilts/abi-dumps/host/linux_glibc/x86_64/source-based/libfoo.so.lsdump.gz", update.Args["refDump"])
+	android.AssertStringEquals(t, "textRefDump",
+		"prebuilts/abi-dumps/host/linux_glibc/x86_64/source-based/libfoo.so.lsdump", update.Args["textRefDump"])
+	android.AssertStringEquals(t, "dryRun", "", update.Args["dryRun"])
+
+	dryRun := singleton.Output("update-abi-refs-libfoo-dry-run-" + variant)
+	android.AssertStringEquals(t, "dryRun", "true", dryRun.Args["dryRun"])
+}
@@ -39,6 +39,13 @@ var (
 	}
 	asanLdflags = []string{"-Wl,-u,__asan_preinit"}
 
+	// Track the origins of uninitialized values through stores, which makes the reports point at
+	// the allocation that wasn't initialized.
+	msanCflags = []string{
+		"-fno-omit-frame-pointer",
+		"-fsanitize-memory-track-origins=2",
+	}
+
 	hwasanCflags = []string{"-fno-omit-frame-pointer", "-Wno-frame-larger-than=",
 		"-fsanitize-hwaddress-abi=platform",
 		// The following improves debug location information
@@ -78,6 +85,7 @@ const (
 	Asan SanitizerType = iota + 1
 	Hwasan
 	tsan
+	msan
 	intOverflow
 	scs
 	Fuzzer
@@ -89,6 +97,7 @@ var Sanitizers = []SanitizerType{
 	Asan,
 	Hwasan,
 	tsan,
+	msan,
 	intOverflow,
 	scs,
 	Fuzzer,
@@ -105,6 +114,8 @@ func (t SanitizerType) variationName() string {
 		return "hwasan"
 	case tsan:
 		return "tsan"
+	case msan:
+		return "msan"
 	case intOverflow:
 		return "intOverflow"
 	case cfi:
@@ -131,6 +142,8 @@ func (t SanitizerType) name() string {
 		return "memtag_heap"
 	case tsan:
 		return "thread"
+	case msan:
+		return "memory"
 	case intOverflow:
 		return "integer_overflow"
 	case cfi:
@@ -146,7 +159,7 @@ func (t SanitizerType) name() string {
 
 func (t SanitizerType) registerMutators(ctx android.RegisterMutatorsContext) {
 	switch t {
-	case Asan, Hwasan, Fuzzer, scs, tsan, cfi:
+	case Asan, Hwasan, Fuzzer, scs, tsan, msan, cfi:
 		ctx.TopDown(t.variationName()+"_deps", sanitizerDepsMutator(t))
 		ctx.BottomUp(t.variationName(), sanitizerMutator(t))
 	case memtag_heap, intOverflow:
@@ -164,6 +177,8 @@ func (*Module) SanitizerSupported(t SanitizerType) bool {
 		return true
 	case tsan:
 		return true
+	case msan:
+		return true
 	case intOverflow:
 		return true
 	case cfi:
@@ -196,6 +211,12 @@ type SanitizeUserProps struct {
 	// Use of thread sanitizer disables cfi and scudo sanitizers.
 	// Hwaddress sanitizer takes precedence over this sanitizer.
 	Thread *bool `android:"arch_variant"`
+	// MSan (Memory sanitizer), only supported for x86_64 Linux host modules and incompatible with
+	// static binaries. Always runs in a diagnostic mode.
+	// As all code must be instrumented to avoid false positives, static and shared libraries
+	// including libc++ get msan variants. Use of memory sanitizer disables address, thread, cfi
+	// and scudo sanitizers.
+	Memory *bool `android:"arch_variant"`
 	// HWASan (Hardware Address sanitizer).
 	// Use of hwasan sanitizer disables cfi, address, thread, and scudo sanitizers.
 	Hwaddress *bool `android:"arch_variant"`
@@ -229,7 +250,7 @@ type SanitizeUserProps struct {
 
 	// Sanitizers to run in the diagnostic mode (as opposed to the release mode).
 	// Replaces abort() on error with a human-readable error message.
-	// Address and Thread sanitizers always run in diagnostic mode.
+	// Address, Thread and Memory sanitizers always run in diagnostic mode.
 	Diag struct {
 		// Undefined behavior sanitizer, diagnostic mode
 		Undefined *bool `android:"arch_variant"`
@@ -262,6 +283,18 @@ type SanitizeUserProps struct {
 
 	// value to pass to -fsanitize-ignorelist
 	Blocklist *string
+
+	// file with runtime options of the sanitizers, e.g. detect_leaks=0, separated by colons or
+	// newlines. Lines starting with # are comments. Options after a section header like [asan]
+	// only apply to that runtime, the others apply to all runtimes. The options are built into
+	// binaries and tests through __asan_default_options and the corresponding functions of the
+	// other sanitizers, and set in ASAN_OPTIONS, UBSAN_OPTIONS, etc. in the config of tests.
+	Runtime_options *string `android:"path,arch_variant"`
+
+	// file with suppressions of the sanitizers, e.g. leak:libfoo.so. It is installed next to
+	// binaries and packaged with tests, and passed to the sanitizers in the suppressions runtime
+	// option.
+	Suppressions *string `android:"path,arch_variant"`
 }
 
 type SanitizeProperties struct {
@@ -278,8 +311,31 @@ type SanitizeProperties struct {
 
 type sanitize struct {
 	Properties SanitizeProperties
+
+	// runtimes are the names of the sanitizer runtimes that read the runtime options, e.g. asan.
+	runtimes       []string
+	runtimeOptions android.OptionalPath
+	suppressions   android.OptionalPath
 }
 
+var (
+	// sanitizerDefaultOptions generates the source of the default options functions of the
+	// runtimes, which return the runtime options of each runtime.
+	sanitizerDefaultOptions = pctx.AndroidStaticRule("sanitizerDefaultOptions",
+		blueprint.RuleParams{
+			Command:     "$sanitizerOptionsCmd --runtimes $runtimes $flags --source $out",
+			CommandDeps: []string{"$sanitizerOptionsCmd"},
+		}, "runtimes", "flags")
+
+	// sanitizerTestConfig adds the runtime options of each runtime to a test config as the
+	// environment variable the runtime reads them from.
+	sanitizerTestConfig = pctx.AndroidStaticRule("sanitizerTestConfig",
+		blueprint.RuleParams{
+			Command:     "$sanitizerOptionsCmd --runtimes $runtimes $flags --test-config $in --output $out",
+			CommandDeps: []string{"$sanitizerOptionsCmd"},
+		}, "runtimes", "flags")
+)
+
 // Mark this tag with a check to see if apex dependency check should be skipped
 func (t libraryDependencyTag) SkipApexAllowedDependenciesCheck() bool {
 	return t.skipApexAllowedDependenciesCheck
@@ -288,6 +344,8 @@ func (t libraryDependencyTag) SkipApexAllowedDependenciesCheck() bool {
 var _ android.SkipApexAllowedDependenciesCheck = (*libraryDependencyTag)(nil)
 
 func init() {
+	pctx.HostBinToolVariable("sanitizerOptionsCmd", "sanitizer_options")
+
 	android.RegisterMakeVarsProvider(pctx, cfiMakeVarsProvider)
 	android.RegisterMakeVarsProvider(pctx, hwasanMakeVarsProvider)
 }
@@ -323,6 +381,8 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 	var globalSanitizersDiag []string
 
 	if ctx.Host() {
+		// SANITIZE_HOST doesn't apply to Windows modules, which are only sanitized when their
+		// sanitize properties ask for it.
 		if !ctx.Windows() {
 			globalSanitizers = ctx.Config().SanitizeHost()
 		}
@@ -352,6 +412,10 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 			s.Thread = proptools.BoolPtr(true)
 		}
 
+		if found, globalSanitizers = removeFromList("memory", globalSanitizers); found && s.Memory == nil {
+			s.Memory = proptools.BoolPtr(true)
+		}
+
 		if found, globalSanitizers = removeFromList("fuzzer", globalSanitizers); found && s.Fuzzer == nil {
 			s.Fuzzer = proptools.BoolPtr(true)
 		}
@@ -471,8 +535,9 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		s.Diag.Cfi = nil
 	}
 
-	// Disable sanitizers that depend on the UBSan runtime for windows/darwin/musl builds.
-	if !ctx.Os().Linux() || ctx.Os() == android.LinuxMusl {
+	// Disable sanitizers that depend on the UBSan runtime for darwin/musl builds. Windows builds
+	// use the minimal runtime instead, see below.
+	if (!ctx.Os().Linux() && !ctx.Windows()) || ctx.Os() == android.LinuxMusl {
 		s.Cfi = nil
 		s.Diag.Cfi = nil
 		s.Misc_undefined = nil
@@ -504,6 +569,12 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		s.Address = nil
 		s.Fuzzer = nil
 		s.Thread = nil
+		s.Memory = nil
+	}
+
+	// MSan runtime libraries are only available for x86_64 Linux with glibc.
+	if !ctx.Host() || ctx.Os() != android.Linux || ctx.Arch().ArchType != android.X86_64 {
+		s.Memory = nil
 	}
 
 	if Bool(s.All_undefined) {
@@ -517,17 +588,49 @@ func (sanitize *sanitize) begin(ctx BaseModuleContext) {
 		// TODO(ccross): error for compile_multilib = "32"?
 	}
 
-	if ctx.Os() != android.Windows && (Bool(s.All_undefined) || Bool(s.Undefined) || Bool(s.Address) || Bool(s.Thread) ||
+	if ctx.Windows() {
+		// Only ASan and UBSan with the minimal runtime are supported for Windows, and only
+		// for 64-bit as there are no 32-bit runtime libraries.
+		if config.WindowsAddressSanitizerRuntimeDll(ctx.toolchain()) == "" {
+			s.Address = nil
+			s.All_undefined = nil
+			s.Undefined = nil
+			s.Misc_undefined = nil
+			s.Integer_overflow = nil
+		}
+		s.Thread = nil
+		s.Fuzzer = nil
+		s.Safestack = nil
+		s.Cfi = nil
+		s.Scudo = nil
+		s.Hwaddress = nil
+		s.Scs = nil
+		s.Memtag_heap = nil
+		s.Diag.Undefined = nil
+		s.Diag.Integer_overflow = nil
+		s.Diag.Misc_undefined = nil
+		s.Diag.Cfi = nil
+	}
+
+	if Bool(s.All_undefined) || Bool(s.Undefined) || Bool(s.Address) || Bool(s.Thread) || Bool(s.Memory) ||
 		Bool(s.Fuzzer) || Bool(s.Safestack) || Bool(s.Cfi) || Bool(s.Integer_overflow) || len(s.Misc_undefined) > 0 ||
-		Bool(s.Scudo) || Bool(s.Hwaddress) || Bool(s.Scs) || Bool(s.Memtag_heap)) {
+		Bool(s.Scudo) || Bool(s.Hwaddress) || Bool(s.Scs) || Bool(s.Memtag_heap) {
 		sanitize.Properties.SanitizerEnabled = true
 	}
 
-	// Disable Scudo if ASan or TSan is enabled, or if it's disabled globally.
-	if Bool(s.Address) || Bool(s.Thread) || Bool(s.Hwaddress) || ctx.Config().DisableScudo() {
+	// Disable Scudo if ASan, TSan or MSan is enabled, or if it's disabled globally.
+	if Bool(s.Address) || Bool(s.Thread) || Bool(s.Memory) || Bool(s.Hwaddress) || ctx.Config().DisableScudo() {
 		s.Scudo = nil
 	}
 
+	// MSan has its own allocator and shadow memory, which can't be combined with the other
+	// memory error detectors.
+	if Bool(s.Memory) {
+		s.Address = nil
+		s.Thread = nil
+		s.Cfi = nil
+	}
+
 	if Bool(s.Hwaddress) {
 		s.Address = nil
 		s.Thread = nil
@@ -582,11 +685,19 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 	minimalRuntimePath := "${config.ClangAsanLibDir}/" + minimalRuntimeLib
 	builtinsRuntimeLib := config.BuiltinsRuntimeLibrary(ctx.toolchain()) + ".a"
 	builtinsRuntimePath := "${config.ClangAsanLibDir}/" + builtinsRuntimeLib
+	// The MinGW linker doesn't support --exclude-libs.
+	excludeMinimalRuntimeFlag := "-Wl,--exclude-libs," + minimalRuntimeLib
+	if ctx.Windows() {
+		minimalRuntimeLib = config.WindowsUndefinedBehaviorSanitizerMinimalRuntimeLibrary(ctx.toolchain())
+		minimalRuntimePath = "${config.WindowsClangRuntimeLibDir}/" + minimalRuntimeLib
+		excludeMinimalRuntimeFlag = ""
+	}
 
 	if sanitize.Properties.MinimalRuntimeDep {
-		flags.Local.LdFlags = append(flags.Local.LdFlags,
-			minimalRuntimePath,
-			"-Wl,--exclude-libs,"+minimalRuntimeLib)
+		flags.Local.LdFlags = append(flags.Local.LdFlags, minimalRuntimePath)
+		if excludeMinimalRuntimeFlag != "" {
+			flags.Local.LdFlags = append(flags.Local.LdFlags, excludeMinimalRuntimeFlag)
+		}
 	}
 
 	if sanitize.Properties.BuiltinsDep {
@@ -597,6 +708,16 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		return flags
 	}
 
+	sanitize.setRuntimeOptions(ctx)
+	if sanitize.hasRuntimeOptions() && ctx.binary() && ctx.Device() && !ctx.staticBinary() {
+		// The default options functions must be visible to the runtime, which is a shared
+		// library on Android.
+		for _, runtime := range sanitize.runtimes {
+			flags.Local.LdFlags = append(flags.Local.LdFlags,
+				"-Wl,--export-dynamic-symbol=__"+runtime+"_default_options")
+		}
+	}
+
 	if Bool(sanitize.Properties.Sanitize.Address) {
 		if ctx.Arch().ArchType == android.Arm {
 			// Frame pointer based unwinder in ASan requires ARM frame setup.
@@ -604,17 +725,21 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 			flags.RequiredInstructionSet = "arm"
 		}
 		flags.Local.CFlags = append(flags.Local.CFlags, asanCflags...)
-		flags.Local.LdFlags = append(flags.Local.LdFlags, asanLdflags...)
+		if !ctx.Windows() {
+			flags.Local.LdFlags = append(flags.Local.LdFlags, asanLdflags...)
+		}
 
 		if Bool(sanitize.Properties.Sanitize.Writeonly) {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-mllvm", "-asan-instrument-reads=0")
 		}
 
-		if ctx.Host() {
+		// For Windows the driver links the import library of the runtime DLL, which
+		// installWindowsRuntime installs next to the executables.
+		if ctx.Host() && !ctx.Windows() {
 			// -nodefaultlibs (provided with libc++) prevents the driver from linking
 			// libraries needed with -fsanitize=address. http://b/18650275 (WAI)
 			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--no-as-needed")
-		} else {
+		} else if ctx.Device() {
 			flags.Local.CFlags = append(flags.Local.CFlags, "-mllvm", "-asan-globals=0")
 			if ctx.bootstrap() {
 				flags.DynamicLinker = "/system/bin/bootstrap/linker_asan"
@@ -627,6 +752,13 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		}
 	}
 
+	if Bool(sanitize.Properties.Sanitize.Memory) {
+		flags.Local.CFlags = append(flags.Local.CFlags, msanCflags...)
+		// -nodefaultlibs (provided with libc++) prevents the driver from linking
+		// libraries needed with -fsanitize=memory.
+		flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--no-as-needed")
+	}
+
 	if Bool(sanitize.Properties.Sanitize.Hwaddress) {
 		flags.Local.CFlags = append(flags.Local.CFlags, hwasanCflags...)
 		if Bool(sanitize.Properties.Sanitize.Writeonly) {
@@ -715,8 +847,10 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 		if enableMinimalRuntime(sanitize) {
 			flags.Local.CFlags = append(flags.Local.CFlags, strings.Join(minimalRuntimeFlags, " "))
 			flags.libFlags = append([]string{minimalRuntimePath}, flags.libFlags...)
-			flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--exclude-libs,"+minimalRuntimeLib)
-			if !ctx.toolchain().Bionic() {
+			if excludeMinimalRuntimeFlag != "" {
+				flags.Local.LdFlags = append(flags.Local.LdFlags, excludeMinimalRuntimeFlag)
+			}
+			if !ctx.toolchain().Bionic() && !ctx.Windows() {
 				flags.libFlags = append([]string{builtinsRuntimePath}, flags.libFlags...)
 			}
 		}
@@ -763,6 +897,126 @@ func (sanitize *sanitize) flags(ctx ModuleContext, flags Flags) Flags {
 	return flags
 }
 
+// setRuntimeOptions sets the runtime_options and suppressions files and determines the sanitizer
+// runtimes the module is linked against.
+func (sanitize *sanitize) setRuntimeOptions(ctx ModuleContext) {
+	props := sanitize.Properties.Sanitize
+	sanitize.runtimeOptions = android.OptionalPathForModuleSrc(ctx, props.Runtime_options)
+	sanitize.suppressions = android.OptionalPathForModuleSrc(ctx, props.Suppressions)
+
+	if Bool(props.Address) {
+		sanitize.runtimes = append(sanitize.runtimes, "asan", "lsan")
+	}
+	if Bool(props.Hwaddress) {
+		sanitize.runtimes = append(sanitize.runtimes, "hwasan")
+	}
+	if Bool(props.Thread) {
+		sanitize.runtimes = append(sanitize.runtimes, "tsan")
+	}
+	if Bool(props.Memory) {
+		sanitize.runtimes = append(sanitize.runtimes, "msan")
+	}
+	// The minimal runtime doesn't have any options.
+	if (len(sanitize.Properties.Sanitizers) > 0 || sanitize.Properties.UbsanRuntimeDep) &&
+		!enableMinimalRuntime(sanitize) {
+		sanitize.runtimes = append(sanitize.runtimes, "ubsan")
+	}
+}
+
+func (sanitize *sanitize) hasRuntimeOptions() bool {
+	return sanitize != nil && len(sanitize.runtimes) > 0 &&
+		(sanitize.runtimeOptions.Valid() || sanitize.suppressions.Valid())
+}
+
+// runtimeOptionsArgs returns the args of the sanitizer_options rules and the files they read.
+func (sanitize *sanitize) runtimeOptionsArgs() (map[string]string, android.Paths) {
+	var flags []string
+	var implicits android.Paths
+	if sanitize.runtimeOptions.Valid() {
+		flags = append(flags, "--options", sanitize.runtimeOptions.String())
+		implicits = append(implicits, sanitize.runtimeOptions.Path())
+	}
+	if sanitize.suppressions.Valid() {
+		// The runtimes look up relative paths next to the executable.
+		flags = append(flags, "--option", "suppressions="+sanitize.suppressions.Path().Rel())
+	}
+	return map[string]string{
+		"runtimes": strings.Join(sanitize.runtimes, ","),
+		"flags":    strings.Join(flags, " "),
+	}, implicits
+}
+
+// compileDefaultOptions compiles the default options functions for the runtime options of a binary.
+func (sanitize *sanitize) compileDefaultOptions(ctx ModuleContext, flags builderFlags) android.OptionalPath {
+	if !sanitize.hasRuntimeOptions() {
+		return android.OptionalPath{}
+	}
+
+	src := android.PathForModuleGen(ctx, "sanitizer", "sanitizer_default_options.c")
+	args, implicits := sanitize.runtimeOptionsArgs()
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sanitizerDefaultOptions,
+		Description: "sanitizer default options",
+		Output:      src,
+		Implicits:   implicits,
+		Args:        args,
+	})
+
+	// The generated source doesn't need to be tidied or instrumented for coverage.
+	flags.tidy = false
+	flags.gcovCoverage = false
+	flags.sAbiDump = false
+	flags.emitXrefs = false
+	objs := transformSourceToObj(ctx, "sanitizer", android.Paths{src}, flags, nil, nil)
+	return android.OptionalPathForPath(objs.objFiles[0])
+}
+
+// installSuppressions installs the suppressions file next to an installed binary, where the runtimes
+// look for it.
+func (sanitize *sanitize) installSuppressions(ctx ModuleContext, dir android.InstallPath) {
+	if sanitize == nil || !sanitize.suppressions.Valid() {
+		return
+	}
+	suppressions := sanitize.suppressions.Path()
+	ctx.InstallFile(dir, suppressions.Rel(), suppressions)
+}
+
+// needsWindowsRuntime returns true if the module links against the ASan runtime DLL, which has to
+// be installed next to its executables.
+func (sanitize *sanitize) needsWindowsRuntime(ctx BaseModuleContext) bool {
+	return sanitize != nil && ctx.Windows() && Bool(sanitize.Properties.Sanitize.Address)
+}
+
+// installWindowsRuntime installs the ASan runtime DLL next to an installed Windows executable, as
+// Windows loads DLLs from the directory of the executable.
+func (sanitize *sanitize) installWindowsRuntime(ctx ModuleContext, dir android.InstallPath) {
+	if !sanitize.needsWindowsRuntime(ctx) {
+		return
+	}
+	dll := config.WindowsAddressSanitizerRuntimeDll(ctx.toolchain())
+	ctx.InstallFile(dir, dll, config.ClangRuntimeLibPath(ctx, "windows", dll))
+}
+
+// testConfig returns the test config with the runtime options of each runtime set in the
+// environment variable the runtime reads them from.
+func (sanitize *sanitize) testConfig(ctx ModuleContext, testConfig android.Path) android.Path {
+	if testConfig == nil || !sanitize.hasRuntimeOptions() {
+		return testConfig
+	}
+
+	out := android.PathForModuleOut(ctx, "sanitizer", ctx.ModuleName()+".config")
+	args, implicits := sanitize.runtimeOptionsArgs()
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        sanitizerTestConfig,
+		Description: "sanitizer test config",
+		Output:      out,
+		Input:       testConfig,
+		Implicits:   implicits,
+		Args:        args,
+	})
+	return out
+}
+
 func (sanitize *sanitize) AndroidMkEntries(ctx AndroidMkContext, entries *android.AndroidMkEntries) {
 	// Add a suffix for cfi/hwasan/scs-enabled static/header libraries to allow surfacing
 	// both the sanitized and non-sanitized variants to make without a name conflict.
@@ -777,6 +1031,12 @@ func (sanitize *sanitize) AndroidMkEntries(ctx AndroidMkContext, entries *androi
 			entries.SubName += ".scs"
 		}
 	}
+	// Both the msan and the uninstrumented variants of static and shared libraries are exported
+	// to make. Only the uninstrumented variant of header libraries is, see sanitizerMutator.
+	if Bool(sanitize.Properties.Sanitize.Memory) && (entries.Class == "STATIC_LIBRARIES" ||
+		entries.Class == "SHARED_LIBRARIES") {
+		entries.SubName += ".msan"
+	}
 }
 
 func (sanitize *sanitize) inSanitizerDir() bool {
@@ -792,6 +1052,8 @@ func (sanitize *sanitize) getSanitizerBoolPtr(t SanitizerType) *bool {
 		return sanitize.Properties.Sanitize.Hwaddress
 	case tsan:
 		return sanitize.Properties.Sanitize.Thread
+	case msan:
+		return sanitize.Properties.Sanitize.Memory
 	case intOverflow:
 		return sanitize.Properties.Sanitize.Integer_overflow
 	case cfi:
@@ -812,6 +1074,7 @@ func (sanitize *sanitize) isUnsanitizedVariant() bool {
 	return !sanitize.isSanitizerEnabled(Asan) &&
 		!sanitize.isSanitizerEnabled(Hwasan) &&
 		!sanitize.isSanitizerEnabled(tsan) &&
+		!sanitize.isSanitizerEnabled(msan) &&
 		!sanitize.isSanitizerEnabled(cfi) &&
 		!sanitize.isSanitizerEnabled(scs) &&
 		!sanitize.isSanitizerEnabled(memtag_heap) &&
@@ -823,6 +1086,7 @@ func (sanitize *sanitize) isVariantOnProductionDevice() bool {
 	return !sanitize.isSanitizerEnabled(Asan) &&
 		!sanitize.isSanitizerEnabled(Hwasan) &&
 		!sanitize.isSanitizerEnabled(tsan) &&
+		!sanitize.isSanitizerEnabled(msan) &&
 		!sanitize.isSanitizerEnabled(Fuzzer)
 }
 
@@ -838,6 +1102,8 @@ func (sanitize *sanitize) SetSanitizer(t SanitizerType, b bool) {
 		sanitize.Properties.Sanitize.Hwaddress = bPtr
 	case tsan:
 		sanitize.Properties.Sanitize.Thread = bPtr
+	case msan:
+		sanitize.Properties.Sanitize.Memory = bPtr
 	case intOverflow:
 		sanitize.Properties.Sanitize.Integer_overflow = bPtr
 	case cfi:
@@ -1093,6 +1359,11 @@ func sanitizerRuntimeMutator(mctx android.BottomUpMutatorContext) {
 			sanitizers = append(sanitizers, "thread")
 		}
 
+		if Bool(c.sanitize.Properties.Sanitize.Memory) {
+			sanitizers = append(sanitizers, "memory")
+			diagSanitizers = append(diagSanitizers, "memory")
+		}
+
 		if Bool(c.sanitize.Properties.Sanitize.Safestack) {
 			sanitizers = append(sanitizers, "safe-stack")
 		}
@@ -1308,11 +1579,11 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 				modules[0].(PlatformSanitizeable).SetSanitizer(t, true)
 			} else if c.IsSanitizerEnabled(t) || c.SanitizeDep() {
 				isSanitizerEnabled := c.IsSanitizerEnabled(t)
-				if c.StaticallyLinked() || c.Header() || t == Fuzzer {
+				if c.StaticallyLinked() || c.Header() || t == Fuzzer || t == msan {
 					// Static and header libs are split into non-sanitized and sanitized variants.
-					// Shared libs are not split. However, for asan and fuzzer, we split even for shared
-					// libs because a library sanitized for asan/fuzzer can't be linked from a library
-					// that isn't sanitized for asan/fuzzer.
+					// Shared libs are not split. However, for asan, msan and fuzzer, we split even for
+					// shared libs because a library sanitized for asan/msan/fuzzer can't be linked from
+					// a library that isn't sanitized for asan/msan/fuzzer.
 					//
 					// Note for defaultVariation: since we don't split for shared libs but for static/header
 					// libs, it is possible for the sanitized variant of a static/header lib to depend
@@ -1338,10 +1609,16 @@ func sanitizerMutator(t SanitizerType) func(android.BottomUpMutatorContext) {
 						modules[1].(PlatformSanitizeable).SetSanitizer(cfi, false)
 					}
 
-					// For cfi/scs/hwasan, we can export both sanitized and un-sanitized variants
+					// For cfi/scs/hwasan/msan, we can export both sanitized and un-sanitized variants
 					// to Make, because the sanitized version has a different suffix in name.
+					// The msan variants of shared libraries are installed to a msan subdirectory.
 					// For other types of sanitizers, suppress the variation that is disabled.
-					if t != cfi && t != scs && t != Hwasan {
+					if t == msan && c.Header() {
+						// Header libraries don't have an msan suffix, and both variants export
+						// the same headers.
+						modules[1].(PlatformSanitizeable).SetPreventInstall()
+						modules[1].(PlatformSanitizeable).SetHideFromMake()
+					} else if t != cfi && t != scs && t != Hwasan && t != msan {
 						if isSanitizerEnabled {
 							modules[0].(PlatformSanitizeable).SetPreventInstall()
 							modules[0].(PlatformSanitizeable).SetHideFromMake()
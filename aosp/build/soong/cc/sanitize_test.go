@@ -16,10 +16,12 @@ package cc
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"android/soong/android"
+	"android/soong/cc/config"
 )
 
 var prepareForAsanTest = android.FixtureAddFile("asan/Android.bp", []byte(`
@@ -205,6 +207,307 @@ func TestAsan(t *testing.T) {
 	t.Run("device", func(t *testing.T) { check(t, result, "android_arm64_armv8-a") })
 }
 
+func TestMsan(t *testing.T) {
+	bp := `
+		cc_binary {
+			name: "bin_with_msan",
+			host_supported: true,
+			shared_libs: ["libshared"],
+			static_libs: ["libstatic"],
+			header_libs: ["libheaders"],
+			sanitize: {
+				memory: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_msan",
+			host_supported: true,
+			shared_libs: ["libshared"],
+			static_libs: ["libstatic"],
+			header_libs: ["libheaders"],
+		}
+
+		cc_library_shared {
+			name: "libshared",
+			host_supported: true,
+		}
+
+		cc_library_static {
+			name: "libstatic",
+			host_supported: true,
+		}
+
+		cc_library_headers {
+			name: "libheaders",
+			host_supported: true,
+		}
+	`
+
+	result := prepareForCcTest.RunTestWithBp(t, bp)
+
+	variant := result.Config.BuildOSTarget.String()
+	sharedVariant := variant + "_shared"
+	staticVariant := variant + "_static"
+
+	binWithMsan := result.ModuleForTests("bin_with_msan", variant+"_msan")
+	binNoMsan := result.ModuleForTests("bin_no_msan", variant)
+
+	expectSharedLinkDep := func(from, to android.TestingModule) {
+		t.Helper()
+		fromLink := from.Description("link")
+		toLink := to.Description("strip")
+
+		if g, w := fromLink.OrderOnly.Strings(), toLink.Output.String(); !android.InList(w, g) {
+			t.Errorf("%s should link against %s, expected %q, got %q",
+				from.Module(), to.Module(), w, g)
+		}
+	}
+
+	expectStaticLinkDep := func(from, to android.TestingModule) {
+		t.Helper()
+		fromLink := from.Description("link")
+		toLink := to.Description("static link")
+
+		if g, w := fromLink.Implicits.Strings(), toLink.Output.String(); !android.InList(w, g) {
+			t.Errorf("%s should link against %s, expected %q, got %q",
+				from.Module(), to.Module(), w, g)
+		}
+	}
+
+	// The msan binary links against the msan variants of all of its dependencies, including
+	// libc++, and the uninstrumented binary against the uninstrumented ones.
+	expectSharedLinkDep(binWithMsan, result.ModuleForTests("libshared", sharedVariant+"_msan"))
+	expectSharedLinkDep(binWithMsan, result.ModuleForTests("libc++", sharedVariant+"_msan"))
+	expectStaticLinkDep(binWithMsan, result.ModuleForTests("libstatic", staticVariant+"_msan"))
+
+	expectSharedLinkDep(binNoMsan, result.ModuleForTests("libshared", sharedVariant))
+	expectSharedLinkDep(binNoMsan, result.ModuleForTests("libc++", sharedVariant))
+	expectStaticLinkDep(binNoMsan, result.ModuleForTests("libstatic", staticVariant))
+
+	// The msan variants of shared libraries are installed next to the uninstrumented ones.
+	libShared := result.ModuleForTests("libshared", sharedVariant).Description("install").Output
+	if !strings.HasSuffix(libShared.String(), "/lib64/libshared.so") {
+		t.Errorf("expected libshared to be installed to lib64, got %q", libShared)
+	}
+	libSharedMsan := result.ModuleForTests("libshared", sharedVariant+"_msan").Description("install").Output
+	if !strings.HasSuffix(libSharedMsan.String(), "/lib64/msan/libshared.so") {
+		t.Errorf("expected the msan variant of libshared to be installed to lib64/msan, got %q", libSharedMsan)
+	}
+
+	// The msan binary looks for them first.
+	ldFlags := strings.Fields(binWithMsan.Rule("ld").Args["ldFlags"])
+	android.AssertStringListContains(t, "msan rpath", ldFlags, `-Wl,-rpath,\$$ORIGIN/../lib64/msan`)
+	android.AssertStringListContains(t, "rpath", ldFlags, `-Wl,-rpath,\$$ORIGIN/../lib64`)
+	android.AssertStringListDoesNotContain(t, "msan rpath",
+		strings.Fields(binNoMsan.Rule("ld").Args["ldFlags"]), `-Wl,-rpath,\$$ORIGIN/../lib64/msan`)
+
+	// Both variants of static and shared libraries are exported to make, the msan ones with a
+	// suffix. Only the uninstrumented variant of header libraries is.
+	subName := func(name, variant string) string {
+		module := result.ModuleForTests(name, variant).Module()
+		return android.AndroidMkEntriesForTest(t, result.TestContext, module)[0].SubName
+	}
+	android.AssertStringEquals(t, "libshared SubName", "", subName("libshared", sharedVariant))
+	android.AssertStringEquals(t, "libshared msan SubName", ".msan", subName("libshared", sharedVariant+"_msan"))
+	android.AssertStringEquals(t, "libstatic msan SubName", ".msan", subName("libstatic", staticVariant+"_msan"))
+
+	libHeadersMsan := result.ModuleForTests("libheaders", variant+"_msan").Module().(*Module)
+	if !libHeadersMsan.IsHideFromMake() {
+		t.Errorf("expected the msan variant of libheaders to be hidden from make")
+	}
+	libHeaders := result.ModuleForTests("libheaders", variant).Module().(*Module)
+	if libHeaders.IsHideFromMake() {
+		t.Errorf("expected the uninstrumented variant of libheaders to be exported to make")
+	}
+}
+
+func TestWindowsSanitize(t *testing.T) {
+	bp := `
+		cc_defaults {
+			name: "windows_defaults",
+			host_supported: true,
+			stl: "none",
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}
+
+		cc_test {
+			name: "test_with_asan",
+			defaults: ["windows_defaults"],
+			gtest: false,
+			srcs: ["test.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_asan",
+			defaults: ["windows_defaults"],
+			srcs: ["bin.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+
+		cc_binary {
+			name: "bin_with_ubsan",
+			defaults: ["windows_defaults"],
+			srcs: ["bin.c"],
+			sanitize: {
+				misc_undefined: ["signed-integer-overflow"],
+			},
+		}
+
+		cc_binary {
+			name: "bin_no_sanitize",
+			defaults: ["windows_defaults"],
+			srcs: ["bin.c"],
+		}
+	`
+
+	dll := "libclang_rt.asan_dynamic-x86_64.dll"
+	dllSrc := "prebuilts/clang/host/linux-x86/" + config.ClangDefaultVersion + "/lib64/clang/" +
+		config.ClangDefaultShortVersion + "/lib/windows/" + dll
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		PrepareForTestOnWindows,
+		android.FixtureModifyConfig(func(c android.Config) {
+			c.Targets[android.Windows] = []android.Target{
+				{Os: android.Windows, Arch: android.Arch{ArchType: android.X86_64}},
+			}
+		}),
+		android.FixtureModifyProductVariables(func(variables android.FixtureProductVariables) {
+			variables.SanitizeHost = []string{"address"}
+		}),
+		android.MockFS{
+			dllSrc: nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	installedDll := func(m android.TestingModule) string {
+		for _, output := range m.AllOutputs() {
+			if strings.HasSuffix(output, "/"+dll) {
+				return output
+			}
+		}
+		return ""
+	}
+
+	// Tests are installed to their own directory, next to the ASan runtime DLL.
+	testWithAsan := result.ModuleForTests("test_with_asan", "windows_x86_64_asan")
+	android.AssertStringListContains(t, "cflags",
+		strings.Fields(testWithAsan.Rule("cc").Args["cFlags"]), "-fsanitize=address")
+	android.AssertStringListDoesNotContain(t, "ldflags",
+		strings.Fields(testWithAsan.Rule("ld").Args["ldFlags"]), "-Wl,-u,__asan_preinit")
+	dllInstall := installedDll(testWithAsan)
+	if !strings.HasSuffix(dllInstall, "/test_with_asan/"+dll) {
+		t.Fatalf("expected %s to be installed next to test_with_asan, got %q", dll, dllInstall)
+	}
+	android.AssertStringEquals(t, "runtime DLL", dllSrc, testWithAsan.Output(dllInstall).Input.String())
+
+	// Binaries with ASan are installed to their own directory instead of the shared bin
+	// directory, next to the ASan runtime DLL.
+	binWithAsan := result.ModuleForTests("bin_with_asan", "windows_x86_64")
+	android.AssertStringListContains(t, "cflags",
+		strings.Fields(binWithAsan.Rule("cc").Args["cFlags"]), "-fsanitize=address")
+	dllInstall = installedDll(binWithAsan)
+	if !strings.HasSuffix(dllInstall, "/bin/bin_with_asan/"+dll) {
+		t.Fatalf("expected %s to be installed next to bin_with_asan, got %q", dll, dllInstall)
+	}
+	android.AssertStringEquals(t, "runtime DLL", dllSrc, binWithAsan.Output(dllInstall).Input.String())
+	binInstall := binWithAsan.Module().(*Module).installer.(*binaryDecorator).baseInstaller.path
+	android.AssertStringEquals(t, "installed binary", filepath.Dir(dllInstall), filepath.Dir(binInstall.String()))
+
+	// Binaries without ASan stay in the shared bin directory.
+	binWithUbsanInstall := result.ModuleForTests("bin_with_ubsan", "windows_x86_64").Module().(*Module).
+		installer.(*binaryDecorator).baseInstaller.path
+	android.AssertStringEquals(t, "bin directory", "bin", filepath.Base(filepath.Dir(binWithUbsanInstall.String())))
+
+	// UBSan uses the static minimal runtime of the mingw targets.
+	binWithUbsan := result.ModuleForTests("bin_with_ubsan", "windows_x86_64")
+	android.AssertStringDoesContain(t, "libflags", binWithUbsan.Rule("ld").Args["libFlags"],
+		"${config.WindowsClangRuntimeLibDir}/libclang_rt.ubsan_minimal-x86_64.a")
+	android.AssertStringDoesNotContain(t, "ldflags", binWithUbsan.Rule("ld").Args["ldFlags"],
+		"--exclude-libs")
+
+	// SANITIZE_HOST only applies to Linux host modules.
+	result.ModuleForTests("bin_no_sanitize", result.Config.BuildOSTarget.String()+"_asan")
+	binNoSanitize := result.ModuleForTests("bin_no_sanitize", "windows_x86_64")
+	android.AssertStringListDoesNotContain(t, "cflags",
+		strings.Fields(binNoSanitize.Rule("cc").Args["cFlags"]), "-fsanitize=address")
+}
+
+func TestSanitizeRuntimeOptions(t *testing.T) {
+	bp := `
+		cc_test {
+			name: "test_with_options",
+			gtest: false,
+			srcs: ["test.c"],
+			sanitize: {
+				address: true,
+				runtime_options: "asan_options.txt",
+				suppressions: "suppressions.txt",
+			},
+		}
+
+		cc_test {
+			name: "test_without_options",
+			gtest: false,
+			srcs: ["test.c"],
+			sanitize: {
+				address: true,
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		prepareForAsanTest,
+		android.MockFS{
+			"asan_options.txt": nil,
+			"suppressions.txt": nil,
+		}.AddToFixture(),
+	).RunTestWithBp(t, bp)
+
+	variant := "android_arm64_armv8-a"
+	testWithOptions := result.ModuleForTests("test_with_options", variant)
+
+	// The default options are generated from the options file by the build.
+	defaultOptions := testWithOptions.Description("sanitizer default options")
+	android.AssertStringEquals(t, "runtimes", "asan,lsan", defaultOptions.Args["runtimes"])
+	android.AssertStringEquals(t, "flags", "--options asan_options.txt --option suppressions=suppressions.txt",
+		defaultOptions.Args["flags"])
+	android.AssertStringListContains(t, "implicits", defaultOptions.Implicits.Strings(), "asan_options.txt")
+
+	link := testWithOptions.Rule("ld")
+	android.AssertStringDoesContain(t, "link inputs", strings.Join(link.Inputs.Strings(), " "),
+		"/sanitizer_default_options.o")
+	ldFlags := strings.Fields(link.Args["ldFlags"])
+	android.AssertStringListContains(t, "ldflags", ldFlags, "-Wl,--export-dynamic-symbol=__asan_default_options")
+	android.AssertStringListContains(t, "ldflags", ldFlags, "-Wl,--export-dynamic-symbol=__lsan_default_options")
+
+	// The options are added to the environment of the test by its test config.
+	testConfig := testWithOptions.Description("sanitizer test config")
+	android.AssertStringEquals(t, "runtimes", "asan,lsan", testConfig.Args["runtimes"])
+	entries := android.AndroidMkEntriesForTest(t, result.TestContext, testWithOptions.Module())[0]
+	android.AssertStringListContains(t, "LOCAL_FULL_TEST_CONFIG", entries.EntryMap["LOCAL_FULL_TEST_CONFIG"],
+		testConfig.Output.String())
+
+	testWithoutOptions := result.ModuleForTests("test_without_options", variant)
+	if testWithoutOptions.MaybeDescription("sanitizer test config").Rule != nil {
+		t.Errorf("expected no sanitizer test config for test_without_options")
+	}
+	if testWithoutOptions.MaybeDescription("sanitizer default options").Rule != nil {
+		t.Errorf("expected no sanitizer default options for test_without_options")
+	}
+}
+
 type MemtagNoteType int
 
 const (
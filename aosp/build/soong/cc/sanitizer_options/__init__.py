@@ -0,0 +1,173 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Generates the sanitizer runtime options of a module.
+
+The options of the sanitize.runtime_options file of a module are built into
+its binaries through __asan_default_options and the corresponding functions of
+the other sanitizer runtimes, and set in ASAN_OPTIONS, UBSAN_OPTIONS, etc. in
+the test config of its tests.
+
+The file contains name=value options separated by colons or whitespace. Lines
+starting with # are comments. Options before the first section apply to all
+runtimes, and options after a section header such as [asan] only apply to that
+runtime.
+"""
+import argparse
+from pathlib import Path
+import re
+import sys
+from typing import Dict, Iterable, List
+from xml.sax.saxutils import quoteattr
+
+RUNTIMES = ('asan', 'lsan', 'hwasan', 'tsan', 'msan', 'ubsan')
+
+SECTION_RE = re.compile(r'^\[(\w+)\]$')
+OPTION_SEPARATOR_RE = re.compile(r'[:\s]+')
+
+
+def parse_runtime_options(text: str) -> Dict[str, List[str]]:
+    """Parses a runtime options file.
+
+    Returns the options of each section. The options that apply to all
+    runtimes are in the '' section.
+    """
+    sections: Dict[str, List[str]] = {'': []}
+    section = ''
+    for line in text.splitlines():
+        line = line.strip()
+        if line.startswith('#'):
+            continue
+        match = SECTION_RE.match(line)
+        if match:
+            section = match.group(1)
+            if section not in RUNTIMES:
+                raise ValueError(f'unknown runtime [{section}], expected one '
+                                 f'of {", ".join(RUNTIMES)}')
+            sections.setdefault(section, [])
+            continue
+        for option in OPTION_SEPARATOR_RE.split(line):
+            if not option:
+                continue
+            if '=' not in option or option.startswith('='):
+                raise ValueError(
+                    f'invalid option "{option}", expected name=value')
+            sections[section].append(option)
+    return sections
+
+
+def runtime_options(sections: Dict[str, List[str]], runtimes: Iterable[str],
+                    extra_options: Iterable[str]) -> Dict[str, List[str]]:
+    """Returns the options of each runtime that has any."""
+    options = {}
+    for runtime in runtimes:
+        runtime_opts = sections.get('', []) + sections.get(runtime, [])
+        runtime_opts += extra_options
+        if runtime_opts:
+            options[runtime] = runtime_opts
+    return options
+
+
+def c_string(value: str) -> str:
+    """Returns value as a C string literal."""
+    return '"' + value.replace('\\', '\\\\').replace('"', '\\"') + '"'
+
+
+def sanitizer_default_options_source(options: Dict[str, List[str]]) -> str:
+    """Returns C source that defines the default options functions."""
+    lines = [
+        '// Generated from sanitize.runtime_options and sanitize.suppressions.'
+    ]
+    for runtime, runtime_opts in options.items():
+        lines.append('__attribute__((visibility("default"), used))')
+        lines.append(f'const char* __{runtime}_default_options(void) '
+                     f'{{ return {c_string(":".join(runtime_opts))}; }}')
+    return '\n'.join(lines) + '\n'
+
+
+def insert_test_config_options(config: str,
+                               options: Dict[str, List[str]]) -> str:
+    """Returns the test config with the options in environment variables."""
+    end = config.rfind('</configuration>')
+    if end < 0:
+        raise ValueError('no </configuration> in test config')
+    lines = []
+    for runtime, runtime_opts in options.items():
+        lines.append(f'    <option name="environment-variable" '
+                     f'key="{runtime.upper()}_OPTIONS" '
+                     f'value={quoteattr(":".join(runtime_opts))} />\n')
+    return config[:end] + ''.join(lines) + config[end:]
+
+
+def parse_args() -> argparse.Namespace:
+    """Parses and returns command line arguments."""
+    parser = argparse.ArgumentParser()
+
+    parser.add_argument('--runtimes',
+                        required=True,
+                        help='Comma separated runtimes the module uses.')
+    parser.add_argument('--options',
+                        type=Path,
+                        help='The sanitize.runtime_options file.')
+    parser.add_argument('--option',
+                        action='append',
+                        default=[],
+                        help='Additional option of all runtimes.')
+    parser.add_argument('--source',
+                        type=Path,
+                        help='Path to write the default options source to.')
+    parser.add_argument('--test-config',
+                        type=Path,
+                        help='Test config to add the options to.')
+    parser.add_argument('--output',
+                        type=Path,
+                        help='Path to write the test config to.')
+
+    return parser.parse_args()
+
+
+def main() -> None:
+    """Program entry point."""
+    args = parse_args()
+
+    sections: Dict[str, List[str]] = {}
+    if args.options is not None:
+        try:
+            sections = parse_runtime_options(args.options.read_text())
+        except ValueError as ex:
+            sys.exit(f'error: {args.options}: {ex}')
+    runtimes = [r for r in args.runtimes.split(',') if r]
+    for runtime in runtimes:
+        if runtime not in RUNTIMES:
+            sys.exit(f'error: unknown runtime {runtime}')
+    options = runtime_options(sections, runtimes, args.option)
+
+    if args.source is not None:
+        args.source.write_text(sanitizer_default_options_source(options))
+        return
+
+    if args.test_config is None or args.output is None:
+        sys.exit('error: --source or --test-config and --output are required')
+    try:
+        config = insert_test_config_options(args.test_config.read_text(),
+                                            options)
+    except ValueError as ex:
+        sys.exit(f'error: {args.test_config}: {ex}')
+    args.output.write_text(config)
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,142 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for sanitizer_options."""
+import textwrap
+import unittest
+
+import sanitizer_options
+
+# pylint: disable=missing-docstring
+
+
+class ParseRuntimeOptionsTest(unittest.TestCase):
+    def test_separators(self) -> None:
+        text = textwrap.dedent("""\
+            # Leaks of libfoo are suppressed instead.
+            detect_leaks=1:halt_on_error=0
+            print_stacktrace=1 verbosity=0\r
+            """)
+        self.assertEqual(
+            {
+                '': [
+                    'detect_leaks=1', 'halt_on_error=0', 'print_stacktrace=1',
+                    'verbosity=0'
+                ]
+            }, sanitizer_options.parse_runtime_options(text))
+
+    def test_sections(self) -> None:
+        text = textwrap.dedent("""\
+            halt_on_error=0
+            [asan]
+            detect_stack_use_after_return=1
+            [ubsan]
+            print_stacktrace=1
+            """)
+        self.assertEqual(
+            {
+                '': ['halt_on_error=0'],
+                'asan': ['detect_stack_use_after_return=1'],
+                'ubsan': ['print_stacktrace=1'],
+            }, sanitizer_options.parse_runtime_options(text))
+
+    def test_invalid_option(self) -> None:
+        with self.assertRaisesRegex(ValueError, 'invalid option "verbosity"'):
+            sanitizer_options.parse_runtime_options('verbosity\n')
+        with self.assertRaisesRegex(ValueError, 'invalid option "=1"'):
+            sanitizer_options.parse_runtime_options('=1\n')
+
+    def test_unknown_runtime(self) -> None:
+        with self.assertRaisesRegex(ValueError, r'unknown runtime \[foo\]'):
+            sanitizer_options.parse_runtime_options('[foo]\n')
+
+
+class RuntimeOptionsTest(unittest.TestCase):
+    def test_sections_apply_to_their_runtime(self) -> None:
+        sections = {
+            '': ['halt_on_error=0'],
+            'asan': ['detect_stack_use_after_return=1'],
+            'tsan': ['history_size=7'],
+        }
+        self.assertEqual(
+            {
+                'asan': [
+                    'halt_on_error=0', 'detect_stack_use_after_return=1',
+                    'suppressions=foo.txt'
+                ],
+                'ubsan': ['halt_on_error=0', 'suppressions=foo.txt'],
+            },
+            sanitizer_options.runtime_options(sections, ['asan', 'ubsan'],
+                                              ['suppressions=foo.txt']))
+
+    def test_runtimes_without_options(self) -> None:
+        sections = {'': [], 'asan': ['detect_leaks=0']}
+        self.assertEqual({'asan': ['detect_leaks=0']},
+                         sanitizer_options.runtime_options(
+                             sections, ['asan', 'ubsan'], []))
+
+
+class SanitizerDefaultOptionsSourceTest(unittest.TestCase):
+    def test_source(self) -> None:
+        options = {
+            'asan': ['detect_leaks=0', 'suppressions=foo.txt'],
+            'ubsan': ['print_stacktrace=1'],
+        }
+        self.assertEqual(
+            textwrap.dedent("""\
+                // Generated from sanitize.runtime_options and sanitize.suppressions.
+                __attribute__((visibility("default"), used))
+                const char* __asan_default_options(void) { return "detect_leaks=0:suppressions=foo.txt"; }
+                __attribute__((visibility("default"), used))
+                const char* __ubsan_default_options(void) { return "print_stacktrace=1"; }
+                """),
+            sanitizer_options.sanitizer_default_options_source(options))
+
+    def test_escapes(self) -> None:
+        source = sanitizer_options.sanitizer_default_options_source(
+            {'asan': ['log_path="/data/local/tmp\\asan"']})
+        self.assertIn(r'return "log_path=\"/data/local/tmp\\asan\"";', source)
+
+
+class InsertTestConfigOptionsTest(unittest.TestCase):
+    def test_insert(self) -> None:
+        config = textwrap.dedent("""\
+            <configuration description="Runs foo_test.">
+                <test class="com.android.tradefed.testtype.GTest" />
+            </configuration>
+            """)
+        options = {'asan': ['detect_leaks=0', 'suppressions=a&b.txt']}
+        self.assertEqual(
+            textwrap.dedent("""\
+                <configuration description="Runs foo_test.">
+                    <test class="com.android.tradefed.testtype.GTest" />
+                    <option name="environment-variable" key="ASAN_OPTIONS" value="detect_leaks=0:suppressions=a&amp;b.txt" />
+                </configuration>
+                """),
+            sanitizer_options.insert_test_config_options(config, options))
+
+    def test_invalid_config(self) -> None:
+        with self.assertRaisesRegex(ValueError, 'no </configuration>'):
+            sanitizer_options.insert_test_config_options('<foo />', {})
+
+
+def main() -> None:
+    suite = unittest.TestLoader().loadTestsFromName(__name__)
+    unittest.TextTestRunner(verbosity=3).run(suite)
+
+
+if __name__ == '__main__':
+    main()
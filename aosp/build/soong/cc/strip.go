@@ -15,11 +15,16 @@
 package cc
 
 import (
+	"path/filepath"
 	"strings"
 
 	"android/soong/android"
 )
 
+func init() {
+	android.RegisterSingletonType("windows_pdb_symbols", windowsPdbSymbolsSingletonFactory)
+}
+
 // StripProperties defines the type of stripping applied to the module.
 type StripProperties struct {
 	Strip struct {
@@ -41,6 +46,12 @@ type StripProperties struct {
 
 		// keep_symbols_and_debug_frame enables stripping but keeps all symbols and debug frames.
 		Keep_symbols_and_debug_frame *bool `android:"arch_variant"`
+
+		// pdb makes Windows executables and DLLs emit CodeView debug info into a separate .pdb
+		// file, which is collected into the Windows symbols zip, and enables stripping. The
+		// stripped output keeps the reference to the .pdb file that debuggers use to find it.
+		// Defaults to true if WINDOWS_PDB is set in the environment.
+		Pdb *bool `android:"arch_variant"`
 	} `android:"arch_variant"`
 }
 
@@ -55,15 +66,45 @@ func (stripper *Stripper) NeedsStrip(actx android.ModuleContext) bool {
 	defaultEnable := (!actx.Config().KatiEnabled() || actx.Device())
 	forceEnable := Bool(stripper.StripProperties.Strip.All) ||
 		Bool(stripper.StripProperties.Strip.Keep_symbols) ||
-		Bool(stripper.StripProperties.Strip.Keep_symbols_and_debug_frame)
+		Bool(stripper.StripProperties.Strip.Keep_symbols_and_debug_frame) ||
+		stripper.NeedsPdb(actx)
 	return !forceDisable && (forceEnable || defaultEnable)
 }
 
+// NeedsPdb determines if a Windows module should write its debug info to a separate .pdb file.
+func (stripper *Stripper) NeedsPdb(actx android.ModuleContext) bool {
+	if !actx.Windows() || Bool(stripper.StripProperties.Strip.None) {
+		return false
+	}
+	return BoolDefault(stripper.StripProperties.Strip.Pdb, actx.Config().IsEnvTrue("WINDOWS_PDB"))
+}
+
+// pdbFlags adds the flags to emit CodeView debug info when NeedsPdb is true.
+func (stripper *Stripper) pdbFlags(actx android.ModuleContext, flags Flags) Flags {
+	if stripper.NeedsPdb(actx) {
+		flags.Local.CFlags = append(flags.Local.CFlags, "-gcodeview")
+	}
+	return flags
+}
+
+// pdbFile returns the path of the .pdb file for a Windows executable or DLL, and adds the
+// flags for lld to write it to the given flags.
+func (stripper *Stripper) pdbFile(actx android.ModuleContext, fileName string, flags Flags) (android.ModuleOutPath, Flags) {
+	pdbFile := android.PathForModuleOut(actx, "unstripped",
+		strings.TrimSuffix(fileName, filepath.Ext(fileName))+".pdb")
+	flags.Local.LdFlags = append(flags.Local.LdFlags, "-Wl,--pdb="+pdbFile.String())
+	return pdbFile, flags
+}
+
 // Keep this consistent with //build/bazel/rules/stripped_shared_library.bzl.
 func (stripper *Stripper) strip(actx android.ModuleContext, in android.Path, out android.ModuleOutPath,
 	flags StripFlags, isStaticLib bool) {
 	if actx.Darwin() {
 		transformDarwinStrip(actx, in, out)
+	} else if actx.Windows() {
+		// The debug info of PE files isn't split like ELF's, it either stays in the DWARF
+		// sections or was written to the .pdb file by lld.
+		transformWindowsStrip(actx, in, out, Bool(stripper.StripProperties.Strip.All))
 	} else {
 		if Bool(stripper.StripProperties.Strip.Keep_symbols) {
 			flags.StripKeepSymbols = true
@@ -96,3 +137,61 @@ func (stripper *Stripper) StripStaticLib(actx android.ModuleContext, in android.
 	flags StripFlags) {
 	stripper.strip(actx, in, out, flags, true)
 }
+
+// pdbProducer is implemented by linkers that can write the debug info of their Windows outputs
+// to a separate .pdb file.
+type pdbProducer interface {
+	pdbFilePath() android.OptionalPath
+}
+
+func windowsPdbSymbolsSingletonFactory() android.Singleton {
+	return &windowsPdbSymbolsSingleton{}
+}
+
+// windowsPdbSymbolsSingleton collects the .pdb files of all Windows modules into a zip, as the
+// unstripped ELF outputs are collected into the symbols zip. The files are stored under
+// <os>_<arch>/<module>/, as modules of different types may have the same stem.
+type windowsPdbSymbolsSingleton struct {
+	symbolsZip android.OptionalPath
+}
+
+func (s *windowsPdbSymbolsSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var pdbFiles android.Paths
+	var prefixes []string
+	ctx.VisitAllModules(func(module android.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() || ccModule.Os() != android.Windows {
+			return
+		}
+		if producer, ok := ccModule.linker.(pdbProducer); ok && producer.pdbFilePath().Valid() {
+			pdbFiles = append(pdbFiles, producer.pdbFilePath().Path())
+			prefixes = append(prefixes, filepath.Join(ccModule.Os().Name+"_"+ccModule.Arch().ArchType.Name,
+				ccModule.Name()))
+		}
+	})
+
+	if len(pdbFiles) == 0 {
+		return
+	}
+
+	symbolsZip := android.PathForOutput(ctx, "windows-symbols.zip")
+	rule := android.NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command().BuiltTool("soong_zip").FlagWithOutput("-o ", symbolsZip)
+	for i, pdbFile := range pdbFiles {
+		cmd.FlagWithArg("-P ", prefixes[i]).
+			FlagWithArg("-C ", filepath.Dir(pdbFile.String())).
+			FlagWithInput("-f ", pdbFile)
+	}
+	rule.Build("windows_pdb_symbols", "zip windows pdb symbols")
+
+	s.symbolsZip = android.OptionalPathForPath(symbolsZip)
+}
+
+func (s *windowsPdbSymbolsSingleton) MakeVars(ctx android.MakeVarsContext) {
+	if !s.symbolsZip.Valid() {
+		return
+	}
+
+	ctx.Strict("SOONG_WINDOWS_SYMBOLS_ZIP", s.symbolsZip.String())
+	ctx.DistForGoal("win_sdk", s.symbolsZip.Path())
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+
+	"android/soong/android"
+)
+
+func TestWindowsPdbSymbols(t *testing.T) {
+	bp := `
+		cc_defaults {
+			name: "windows_defaults",
+			host_supported: true,
+			stl: "none",
+			strip: {
+				pdb: true,
+			},
+			target: {
+				windows: {
+					enabled: true,
+				},
+			},
+		}
+
+		cc_binary {
+			name: "foo",
+			defaults: ["windows_defaults"],
+			srcs: ["foo.c"],
+		}
+
+		cc_library_shared {
+			name: "libfoo",
+			defaults: ["windows_defaults"],
+			srcs: ["foo.c"],
+			stem: "foo",
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		PrepareForTestOnWindows,
+		android.FixtureModifyConfig(func(c android.Config) {
+			c.Targets[android.Windows] = []android.Target{
+				{Os: android.Windows, Arch: android.Arch{ArchType: android.X86_64}},
+			}
+		}),
+		android.FixtureRegisterWithContext(func(ctx android.RegistrationContext) {
+			ctx.RegisterSingletonType("windows_pdb_symbols", windowsPdbSymbolsSingletonFactory)
+		}),
+	).RunTestWithBp(t, bp)
+
+	foo := result.ModuleForTests("foo", "windows_x86_64")
+	android.AssertStringDoesContain(t, "ldflags", foo.Rule("ld").Args["ldFlags"], "-Wl,--pdb=")
+	fooPdb := foo.Output("unstripped/foo.pdb").Output
+	libfooPdb := result.ModuleForTests("libfoo", "windows_x86_64_shared").Output("unstripped/foo.pdb").Output
+
+	// Both modules write foo.pdb, so the zip entries are keyed by module name.
+	zip := result.SingletonForTests("windows_pdb_symbols").Output("windows-symbols.zip")
+	android.AssertStringListContains(t, "inputs", zip.Implicits.Strings(), fooPdb.String())
+	android.AssertStringListContains(t, "inputs", zip.Implicits.Strings(), libfooPdb.String())
+	android.AssertStringDoesContain(t, "command", zip.RuleParams.Command, "-P windows_x86_64/foo -C ")
+	android.AssertStringDoesContain(t, "command", zip.RuleParams.Command, "-P windows_x86_64/libfoo -C ")
+}
@@ -0,0 +1,567 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/proptools"
+
+	"android/soong/android"
+)
+
+// Symbol files (.map.txt) are version scripts annotated with tags, which ndkstubgen turns into
+// stub libraries. The format is parsed by the symbolfile Python package when the stubs are
+// generated. The parser here follows the same grammar to report problems in a symbol file while
+// the module is analyzed, with the file and line they are at.
+
+// symbolFileArches are the architectures that can be used as tags.
+var symbolFileArches = []string{"arm", "arm64", "x86", "x86_64"}
+
+// symbolFileTags are the tags without a value that ndkstubgen understands.
+var symbolFileTags = []string{"apex", "systemapi", "llndk", "platform-only", "future", "var", "weak"}
+
+const (
+	// symbolFileModeNdk, symbolFileModeApex and symbolFileModeLlndk are the kinds of stubs a
+	// symbol can be included in.
+	symbolFileModeNdk = 1 << iota
+	symbolFileModeApex
+	symbolFileModeLlndk
+
+	symbolFileAllModes = symbolFileModeNdk | symbolFileModeApex | symbolFileModeLlndk
+)
+
+// symbolFileImplicitExports are exported by shared libraries without being listed in their symbol
+// files: the symbols defined by the linker, and the __cfi_check of CFI variants, which is
+// exported by cfi_exports.map.
+var symbolFileImplicitExports = []string{
+	"_init", "_fini", "_end", "_edata", "_etext", "__bss_start", "__end__", "__cfi_check",
+}
+
+// checkSymbolFileExports compares the dynamic symbol table of an implementation library with the
+// symbols of its symbol file, which are listed in $symbols as "<name> <file>:<line>" if they are
+// defined for the architecture of the library and as "<name>" otherwise. It reports the symbols
+// the library doesn't export as $missingLevel, and, unless $unlistedLevel is empty, the symbols
+// the library exports that aren't in any version as $unlistedLevel. Mangled C++ symbols are only
+// reported if the symbol file has no extern "C++" blocks. Only the mismatches reported as error
+// fail the build.
+var checkSymbolFileExports = pctx.AndroidStaticRule("checkSymbolFileExports",
+	blueprint.RuleParams{
+		Command: "${config.ClangBin}/llvm-nm --dynamic --defined-only --just-symbol-name $in | " +
+			"sed 's/@.*//' | sort -u > ${out}.exports && " +
+			"awk -v missing=$missingLevel -v unlisted='$unlistedLevel' -v cpp='$cppSymbols' " +
+			"-v implicit='" + strings.Join(symbolFileImplicitExports, " ") + "' " +
+			"'FILENAME == ARGV[1] { exported[$$1] = 1; next } " +
+			"{ covered[$$1] = 1 } " +
+			"NF > 1 && !($$1 in exported) { print $$2 \": \" missing \": \" $$1 \" is not in the dynamic symbol table of $lib\"; " +
+			"if (missing == \"error\") failed = 1 } " +
+			"END { if (unlisted == \"\") exit failed; split(implicit, l); for (i in l) covered[l[i]] = 1; " +
+			"for (s in exported) if (!(s in covered) && !(cpp && s ~ /^_Z/)) { " +
+			"print \"$symbolFile: \" unlisted \": $lib exports \" s \", which is not in any version\"; " +
+			"if (unlisted == \"error\") failed = 1 } " +
+			"exit failed }' ${out}.exports $symbols && " +
+			"touch $out",
+		CommandDeps: []string{"${config.ClangBin}/llvm-nm"},
+	}, "symbols", "lib", "symbolFile", "cppSymbols", "missingLevel", "unlistedLevel")
+
+// SymbolFileCheckProperties sets how mismatches between a symbol file and the exports of the
+// implementation library are reported.
+type SymbolFileCheckProperties struct {
+	// Whether symbols of the symbol file that the implementation library doesn't export are an
+	// "error" or a "warning". Defaults to "error".
+	Missing_symbols *string
+
+	// Whether symbols the implementation library exports that aren't in any version of the
+	// symbol file are an "error" or a "warning". Defaults to "warning".
+	Unlisted_exports *string
+}
+
+type symbolFileSymbol struct {
+	name string
+	line int
+	tags []string
+}
+
+type symbolFileVersion struct {
+	name    string
+	base    string
+	line    int
+	tags    []string
+	symbols []symbolFileSymbol
+}
+
+func (v symbolFileVersion) private() bool {
+	return strings.HasSuffix(v.name, "_PRIVATE") || strings.HasSuffix(v.name, "_PLATFORM")
+}
+
+type symbolFile struct {
+	path     string
+	versions []symbolFileVersion
+
+	// cppSymbols is true if the symbol file has extern "C++" blocks, whose patterns match
+	// mangled symbols.
+	cppSymbols bool
+}
+
+// symbolFileError is a problem at a line of a symbol file.
+type symbolFileError struct {
+	path string
+	line int
+	msg  string
+}
+
+func (e symbolFileError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.path, e.line, e.msg)
+}
+
+func (f *symbolFile) errorf(line int, format string, args ...interface{}) error {
+	return symbolFileError{f.path, line, fmt.Sprintf(format, args...)}
+}
+
+// symbolFileLineTags returns the tags in the comment of a line.
+func symbolFileLineTags(line string) []string {
+	if i := strings.Index(line, "#"); i >= 0 {
+		return strings.Fields(line[i+1:])
+	}
+	return nil
+}
+
+// parseSymbolFile parses the contents of the symbol file at path. It stops at the first syntax
+// error. Symbols in local scopes and extern "C++" blocks are skipped.
+func parseSymbolFile(path string, contents string) (*symbolFile, error) {
+	f := &symbolFile{path: path}
+	var version *symbolFileVersion
+	globalScope, cppSymbols := true, false
+	lines := strings.Split(contents, "\n")
+	for i, line := range lines {
+		lineNum := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if version == nil {
+			if !strings.Contains(line, "{") {
+				return nil, f.errorf(lineNum, "unexpected contents at top level: %s", trimmed)
+			}
+			name := strings.TrimSpace(strings.SplitN(line, "{", 2)[0])
+			if name == "" {
+				return nil, f.errorf(lineNum, "missing version name")
+			}
+			version = &symbolFileVersion{name: name, line: lineNum, tags: symbolFileLineTags(line)}
+			globalScope, cppSymbols = true, false
+			continue
+		}
+
+		code := strings.TrimSpace(strings.SplitN(line, "#", 2)[0])
+		switch {
+		case strings.Contains(code, "}"):
+			// The line is like "} BASE; # tags", the base and tags are optional.
+			base := strings.TrimSpace(strings.SplitN(code, "}", 2)[1])
+			if !strings.HasSuffix(base, ";") {
+				return nil, f.errorf(lineNum, "unterminated version or extern \"C++\" block, expected ;")
+			}
+			if cppSymbols {
+				cppSymbols = false
+				continue
+			}
+			version.base = strings.TrimSpace(strings.TrimSuffix(base, ";"))
+			f.versions = append(f.versions, *version)
+			version = nil
+		case strings.Contains(line, `extern "C++" {`):
+			cppSymbols = true
+			f.cppSymbols = true
+		case !cppSymbols && strings.Contains(code, ":"):
+			switch visibility := strings.TrimSpace(strings.SplitN(code, ":", 2)[0]); visibility {
+			case "local":
+				globalScope = false
+			case "global":
+				globalScope = true
+			default:
+				return nil, f.errorf(lineNum, "unknown visibility label %q", visibility)
+			}
+		case globalScope && !cppSymbols:
+			if !strings.Contains(code, ";") {
+				return nil, f.errorf(lineNum, "expected ; to terminate symbol: %s", trimmed)
+			}
+			if strings.Contains(code, "*") {
+				return nil, f.errorf(lineNum, "wildcard global symbols are not permitted")
+			}
+			version.symbols = append(version.symbols, symbolFileSymbol{
+				name: strings.TrimSpace(strings.SplitN(code, ";", 2)[0]),
+				line: lineNum,
+				tags: symbolFileLineTags(line),
+			})
+		}
+	}
+	if version != nil {
+		return nil, f.errorf(len(lines), "unexpected end of file in version %s", version.name)
+	}
+	return f, nil
+}
+
+// symbolFileArchesOf returns the architectures that the tags limit a version or symbol to, or all
+// architectures if there are no architecture tags.
+func symbolFileArchesOf(tags []string) []string {
+	var arches []string
+	for _, tag := range tags {
+		if inList(tag, symbolFileArches) {
+			arches = append(arches, tag)
+		}
+	}
+	if len(arches) == 0 {
+		return symbolFileArches
+	}
+	return arches
+}
+
+// commonSymbolFileArches returns the architectures that are in both a and b.
+func commonSymbolFileArches(a, b []string) []string {
+	var common []string
+	for _, arch := range a {
+		if inList(arch, b) {
+			common = append(common, arch)
+		}
+	}
+	return common
+}
+
+// symbolFileModesOf returns the kinds of stubs that the tags limit a version or symbol to.
+func symbolFileModesOf(tags []string) int {
+	modes := 0
+	if inList("apex", tags) || inList("systemapi", tags) {
+		modes |= symbolFileModeApex
+	}
+	if inList("llndk", tags) {
+		modes |= symbolFileModeLlndk
+	}
+	if modes == 0 {
+		return symbolFileAllModes
+	}
+	return modes
+}
+
+// symbolFileIntroduced returns the API level that the tags introduce a version in for arch, or 0 if
+// there is no introduced tag.
+func symbolFileIntroduced(tags []string, arch string,
+	decodeApiLevel func(string) (int, error)) int {
+
+	// An architecture specific tag overrides the common one.
+	introduced, archSpecific := "", false
+	for _, tag := range tags {
+		if tag == "future" {
+			return android.FutureApiLevel.FinalOrFutureInt()
+		} else if strings.HasPrefix(tag, "introduced-"+arch+"=") {
+			introduced, archSpecific = strings.SplitN(tag, "=", 2)[1], true
+		} else if strings.HasPrefix(tag, "introduced=") && !archSpecific {
+			introduced = strings.SplitN(tag, "=", 2)[1]
+		}
+	}
+	if introduced == "" {
+		return 0
+	}
+	level, err := decodeApiLevel(introduced)
+	if err != nil {
+		// Reported by lintTags.
+		return 0
+	}
+	return level
+}
+
+// lint returns the unknown tags, duplicate symbols and versions out of order in the symbol file.
+// decodeApiLevel decodes the API level of introduced and versioned tags.
+func (f *symbolFile) lint(decodeApiLevel func(string) (int, error)) []error {
+	var errs []error
+	for _, version := range f.versions {
+		errs = append(errs, f.lintTags(version.line, version.tags, decodeApiLevel)...)
+		for _, symbol := range version.symbols {
+			errs = append(errs, f.lintTags(symbol.line, symbol.tags, decodeApiLevel)...)
+		}
+	}
+	errs = append(errs, f.lintDuplicateSymbols()...)
+	errs = append(errs, f.lintVersionOrder(decodeApiLevel)...)
+	return errs
+}
+
+func (f *symbolFile) lintTags(line int, tags []string,
+	decodeApiLevel func(string) (int, error)) []error {
+
+	var errs []error
+	for _, tag := range tags {
+		if inList(tag, symbolFileTags) || inList(tag, symbolFileArches) {
+			continue
+		}
+		key, value := tag, ""
+		if i := strings.Index(tag, "="); i >= 0 {
+			key, value = tag[:i], tag[i+1:]
+		}
+		switch {
+		case key == "introduced" || key == "versioned":
+		case strings.HasPrefix(key, "introduced-") &&
+			inList(strings.TrimPrefix(key, "introduced-"), symbolFileArches):
+		default:
+			errs = append(errs, f.errorf(line, "unknown tag %q", tag))
+			continue
+		}
+		if _, err := decodeApiLevel(value); err != nil {
+			errs = append(errs, f.errorf(line, "invalid API level in tag %q: %s", tag, err))
+		}
+	}
+	return errs
+}
+
+// lintDuplicateSymbols reports symbols that are defined more than once for the same architecture
+// and kind of stubs. Private versions aren't included in stubs, like in the symbolfile package.
+func (f *symbolFile) lintDuplicateSymbols() []error {
+	type definition struct {
+		line   int
+		arches []string
+		modes  int
+	}
+	definitions := make(map[string][]definition)
+	var errs []error
+	for _, version := range f.versions {
+		if version.private() || inList("platform-only", version.tags) {
+			continue
+		}
+		versionArches := symbolFileArchesOf(version.tags)
+		versionModes := symbolFileModesOf(version.tags)
+		for _, symbol := range version.symbols {
+			d := definition{
+				line:   symbol.line,
+				arches: commonSymbolFileArches(symbolFileArchesOf(symbol.tags), versionArches),
+				modes:  symbolFileModesOf(symbol.tags) & versionModes,
+			}
+			for _, prev := range definitions[symbol.name] {
+				if prev.modes&d.modes != 0 && len(commonSymbolFileArches(prev.arches, d.arches)) > 0 {
+					errs = append(errs, f.errorf(symbol.line, "duplicate symbol %q, first defined at line %d",
+						symbol.name, prev.line))
+					break
+				}
+			}
+			definitions[symbol.name] = append(definitions[symbol.name], d)
+		}
+	}
+	return errs
+}
+
+// lintVersionOrder reports versions that inherit from a version that isn't defined before them,
+// and public versions that are introduced at a lower API level than a tagged version before
+// them.
+func (f *symbolFile) lintVersionOrder(decodeApiLevel func(string) (int, error)) []error {
+	var errs []error
+	defined := make(map[string]bool)
+	// The latest public version and its API level for each architecture.
+	latest := make(map[string]symbolFileVersion)
+	latestIntroduced := make(map[string]int)
+	for _, version := range f.versions {
+		if defined[version.name] {
+			errs = append(errs, f.errorf(version.line, "duplicate version %q", version.name))
+		}
+		if version.base != "" && !defined[version.base] {
+			errs = append(errs, f.errorf(version.line, "version %q inherits from %q, which isn't defined before it",
+				version.name, version.base))
+		}
+		defined[version.name] = true
+
+		if version.private() {
+			continue
+		}
+		for _, arch := range symbolFileArches {
+			introduced := symbolFileIntroduced(version.tags, arch, decodeApiLevel)
+			if introduced == 0 {
+				// Versions without an introduced tag have always been available, and may
+				// be listed anywhere.
+				continue
+			}
+			if prev, ok := latest[arch]; ok && introduced < latestIntroduced[arch] {
+				errs = append(errs, f.errorf(version.line,
+					"version %q is introduced before version %q at line %d for %s, versions must be in the order they were introduced",
+					version.name, prev.name, prev.line, arch))
+				break
+			}
+			latest[arch] = version
+			latestIntroduced[arch] = introduced
+		}
+	}
+	return errs
+}
+
+// symbolsForArch returns the global symbols of all versions, including private ones, that are
+// defined for arch.
+func (f *symbolFile) symbolsForArch(arch string) []symbolFileSymbol {
+	var symbols []symbolFileSymbol
+	seen := make(map[string]bool)
+	for _, version := range f.versions {
+		if !inList(arch, symbolFileArchesOf(version.tags)) {
+			continue
+		}
+		for _, symbol := range version.symbols {
+			if !seen[symbol.name] && inList(arch, symbolFileArchesOf(symbol.tags)) {
+				seen[symbol.name] = true
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+	return symbols
+}
+
+// lintSymbolFile parses the symbol file at symbolFile, relative to the module directory, and
+// reports its problems as errors in property. It returns nil if the file couldn't be parsed, or if
+// it is generated by another module.
+func lintSymbolFile(ctx ModuleContext, property, symbolFile string) *symbolFile {
+	if android.SrcIsModule(symbolFile) != "" {
+		return nil
+	}
+	path := android.PathForModuleSrc(ctx, symbolFile)
+	ctx.AddNinjaFileDeps(path.String())
+	// Read through the file system of the config, which is the mock file system in tests.
+	r, err := ctx.Config().Fs().Open(path.String())
+	if os.IsNotExist(err) {
+		// Reported by PathForModuleSrc.
+		return nil
+	} else if err != nil {
+		ctx.PropertyErrorf(property, "%s", err)
+		return nil
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		ctx.PropertyErrorf(property, "%s", err)
+		return nil
+	}
+
+	report := reportSymbolFileOnce(ctx.Config(), path.String())
+	f, err := parseSymbolFile(path.String(), string(data))
+	if err != nil {
+		if report {
+			ctx.PropertyErrorf(property, "%s", err)
+		}
+		return nil
+	}
+	if !report {
+		return f
+	}
+	errs := f.lint(func(raw string) (int, error) {
+		level, err := android.ApiLevelFromUser(ctx, raw)
+		if err != nil {
+			return 0, err
+		}
+		return level.FinalOrFutureInt(), nil
+	})
+	for _, err := range errs {
+		ctx.PropertyErrorf(property, "%s", err)
+	}
+	return f
+}
+
+var symbolFileLintKey = android.NewOnceKey("symbolFileLint")
+
+// reportSymbolFileOnce returns true the first time it is called for path. The problems of a symbol
+// file are only reported once, and not by every variant and every module that uses it.
+func reportSymbolFileOnce(config android.Config, path string) bool {
+	reported := config.Once(symbolFileLintKey, func() interface{} {
+		return &sync.Map{}
+	}).(*sync.Map)
+	_, loaded := reported.LoadOrStore(path, true)
+	return !loaded
+}
+
+// exportsCheckList returns the lines of the symbols file of the checkSymbolFileExports rule for
+// arch, which lists the global symbols of all versions and the location of the ones that are
+// defined for arch.
+func (f *symbolFile) exportsCheckList(arch string) []string {
+	located := make(map[string]string)
+	for _, symbol := range f.symbolsForArch(arch) {
+		located[symbol.name] = fmt.Sprintf("%s:%d", f.path, symbol.line)
+	}
+	seen := make(map[string]bool)
+	var lines []string
+	for _, version := range f.versions {
+		for _, symbol := range version.symbols {
+			if seen[symbol.name] {
+				continue
+			}
+			seen[symbol.name] = true
+			if location, ok := located[symbol.name]; ok {
+				lines = append(lines, symbol.name+" "+location)
+			} else {
+				lines = append(lines, symbol.name)
+			}
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// symbolFileCheckLevel returns whether a kind of mismatch between a symbol file and the exports
+// of the implementation library is an "error" or a "warning", as set by property.
+func symbolFileCheckLevel(ctx ModuleContext, property string, level *string, defaultLevel string) string {
+	switch value := proptools.StringDefault(level, defaultLevel); value {
+	case "error", "warning":
+		return value
+	default:
+		ctx.PropertyErrorf(property, "must be \"error\" or \"warning\", got %q", value)
+		return defaultLevel
+	}
+}
+
+// levels returns how symbols missing from the implementation library and exports that aren't in
+// the symbol file are reported.
+func (p *SymbolFileCheckProperties) levels(ctx ModuleContext) (missingLevel, unlistedLevel string) {
+	missingLevel = symbolFileCheckLevel(ctx, "symbol_file_check.missing_symbols", p.Missing_symbols, "error")
+	unlistedLevel = symbolFileCheckLevel(ctx, "symbol_file_check.unlisted_exports", p.Unlisted_exports, "warning")
+	return missingLevel, unlistedLevel
+}
+
+// checkSymbolFileExportsRule registers an action that compares the exports of the implementation
+// library with the symbol file for the architecture of the module, and returns its output.
+// missingLevel and unlistedLevel are "error" or "warning", an empty unlistedLevel skips the check
+// for exports that aren't in the symbol file.
+func checkSymbolFileExportsRule(ctx ModuleContext, f *symbolFile, implementation android.Path,
+	missingLevel, unlistedLevel string) android.WritablePath {
+
+	symbols := android.PathForModuleOut(ctx, "symbol_file_exports", "symbols.txt")
+	android.WriteFileRule(ctx, symbols, strings.Join(f.exportsCheckList(ctx.Arch().ArchType.Name), "\n"))
+
+	cppSymbols := ""
+	if f.cppSymbols {
+		cppSymbols = "true"
+	}
+
+	output := android.PathForModuleOut(ctx, "symbol_file_exports", implementation.Base()+".stamp")
+	ctx.Build(pctx, android.BuildParams{
+		Rule:        checkSymbolFileExports,
+		Description: "check symbol file exports " + implementation.Base(),
+		Output:      output,
+		Input:       implementation,
+		Implicit:    symbols,
+		Args: map[string]string{
+			"symbols":       symbols.String(),
+			"lib":           implementation.Base(),
+			"symbolFile":    f.path,
+			"cppSymbols":    cppSymbols,
+			"missingLevel":  missingLevel,
+			"unlistedLevel": unlistedLevel,
+		},
+	})
+	return output
+}
@@ -0,0 +1,338 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"android/soong/android"
+)
+
+func testDecodeApiLevel(raw string) (int, error) {
+	switch raw {
+	case "current":
+		return 10000, nil
+	case "O":
+		return 26, nil
+	}
+	level, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("unknown API level %q", raw)
+	}
+	return level, nil
+}
+
+func TestParseSymbolFile(t *testing.T) {
+	f, err := parseSymbolFile("libfoo.map.txt", `# A comment.
+LIBFOO { # introduced=21
+  global:
+    foo; # var
+    bar; # arm x86
+  local:
+    *;
+};
+
+LIBFOO_O { # introduced=O
+  global:
+    extern "C++" {
+      "Foo::*";
+    };
+    baz;
+} LIBFOO;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []symbolFileVersion{
+		{
+			name: "LIBFOO",
+			line: 2,
+			tags: []string{"introduced=21"},
+			symbols: []symbolFileSymbol{
+				{name: "foo", line: 4, tags: []string{"var"}},
+				{name: "bar", line: 5, tags: []string{"arm", "x86"}},
+			},
+		},
+		{
+			name: "LIBFOO_O",
+			base: "LIBFOO",
+			line: 10,
+			tags: []string{"introduced=O"},
+			symbols: []symbolFileSymbol{
+				{name: "baz", line: 15},
+			},
+		},
+	}
+	if !reflect.DeepEqual(f.versions, want) {
+		t.Errorf("versions = %+v, want %+v", f.versions, want)
+	}
+
+	if errs := f.lint(testDecodeApiLevel); len(errs) > 0 {
+		t.Errorf("unexpected lint errors: %q", errs)
+	}
+
+	var arm64Symbols []string
+	for _, symbol := range f.symbolsForArch("arm64") {
+		arm64Symbols = append(arm64Symbols, symbol.name)
+	}
+	if want := []string{"foo", "baz"}; !reflect.DeepEqual(arm64Symbols, want) {
+		t.Errorf("symbolsForArch(arm64) = %q, want %q", arm64Symbols, want)
+	}
+}
+
+func TestParseSymbolFileErrors(t *testing.T) {
+	testCases := []struct {
+		name, contents, want string
+	}{
+		{
+			name:     "top level",
+			contents: "foo;\n",
+			want:     "libfoo.map.txt:1: unexpected contents at top level: foo;",
+		},
+		{
+			name:     "unterminated",
+			contents: "LIBFOO {\n  foo;\n}\n",
+			want:     `libfoo.map.txt:3: unterminated version or extern "C++" block, expected ;`,
+		},
+		{
+			name:     "visibility",
+			contents: "LIBFOO {\n  public:\n};\n",
+			want:     `libfoo.map.txt:2: unknown visibility label "public"`,
+		},
+		{
+			name:     "missing semicolon",
+			contents: "LIBFOO {\n  foo # var\n};\n",
+			want:     "libfoo.map.txt:2: expected ; to terminate symbol: foo # var",
+		},
+		{
+			name:     "wildcard",
+			contents: "LIBFOO {\n  global:\n    foo*;\n};\n",
+			want:     "libfoo.map.txt:3: wildcard global symbols are not permitted",
+		},
+		{
+			name:     "eof",
+			contents: "LIBFOO {\n  foo;\n",
+			want:     "libfoo.map.txt:3: unexpected end of file in version LIBFOO",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseSymbolFile("libfoo.map.txt", tc.contents)
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("parseSymbolFile() error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLintSymbolFile(t *testing.T) {
+	f, err := parseSymbolFile("libfoo.map.txt", `LIBFOO { # introduced=23
+  global:
+    foo; # llndk
+    bar; # arm
+    baz; # introduced=P
+    qux; # nosuchtag
+};
+
+LIBFOO_N { # introduced=24
+  global:
+    foo; # apex
+    bar; # x86
+    foo; # llndk
+} LIBFOO_O;
+
+LIBFOO_M { # introduced=23 introduced-arm=21
+  global:
+    quux;
+};
+
+LIBFOO_PRIVATE {
+  global:
+    foo;
+};
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, err := range f.lint(testDecodeApiLevel) {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		`libfoo.map.txt:5: invalid API level in tag "introduced=P": unknown API level "P"`,
+		`libfoo.map.txt:6: unknown tag "nosuchtag"`,
+		`libfoo.map.txt:13: duplicate symbol "foo", first defined at line 3`,
+		`libfoo.map.txt:9: version "LIBFOO_N" inherits from "LIBFOO_O", which isn't defined before it`,
+		`libfoo.map.txt:16: version "LIBFOO_M" is introduced before version "LIBFOO_N" at line 9 for arm, versions must be in the order they were introduced`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("lint() = %q, want %q", got, want)
+	}
+}
+
+func TestSymbolFileExportsCheckList(t *testing.T) {
+	f, err := parseSymbolFile("libfoo.map.txt", `LIBFOO {
+  global:
+    foo;
+    bar; # arm
+    baz; # x86
+};
+
+LIBFOO_PRIVATE {
+  global:
+    qux;
+    bar; # x86
+};
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.cppSymbols {
+		t.Errorf("cppSymbols = true, want false")
+	}
+
+	got := f.exportsCheckList("x86")
+	want := []string{
+		"bar libfoo.map.txt:11",
+		"baz libfoo.map.txt:5",
+		"foo libfoo.map.txt:3",
+		"qux libfoo.map.txt:10",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exportsCheckList(x86) = %q, want %q", got, want)
+	}
+
+	got = f.exportsCheckList("arm64")
+	want = []string{
+		"bar",
+		"baz",
+		"foo libfoo.map.txt:3",
+		"qux libfoo.map.txt:10",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("exportsCheckList(arm64) = %q, want %q", got, want)
+	}
+}
+
+func TestSymbolFileLintErrors(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["29"],
+			},
+		}
+	`
+
+	android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("libfoo.map.txt", `LIBFOO {
+  global:
+    foo;
+    bar; # nosuchtag
+};
+`),
+	).
+		ExtendWithErrorHandler(android.FixtureExpectsAtLeastOneErrorMatchingPattern(
+			`stubs\.symbol_file: libfoo\.map\.txt:4: unknown tag "nosuchtag"`)).
+		RunTestWithBp(t, bp)
+}
+
+func TestSymbolFileExportsCheck(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["29"],
+			},
+			symbol_file_check: {
+				unlisted_exports: "error",
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("libfoo.map.txt", `LIBFOO {
+  global:
+    foo;
+  local:
+    *;
+};
+`),
+	).RunTestWithBp(t, bp)
+
+	libfoo := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	check := libfoo.Output("symbol_file_exports/libfoo.so.stamp")
+	android.AssertStringEquals(t, "missingLevel", "error", check.Args["missingLevel"])
+	android.AssertStringEquals(t, "unlistedLevel", "error", check.Args["unlistedLevel"])
+	android.AssertStringEquals(t, "input", libfoo.Rule("ld").Output.String(), check.Input.String())
+
+	// The check is a validation of the link, so it runs whenever the library is built.
+	android.AssertStringListContains(t, "validations", libfoo.Rule("ld").Validations.Strings(),
+		check.Output.String())
+}
+
+func TestSymbolFileExportsCheckSkipsHost(t *testing.T) {
+	bp := `
+		cc_library {
+			name: "libfoo",
+			host_supported: true,
+			srcs: ["foo.c"],
+			target: {
+				android: {
+					srcs: ["foo_android.c"],
+				},
+			},
+			stubs: {
+				symbol_file: "libfoo.map.txt",
+				versions: ["29"],
+			},
+		}
+	`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("libfoo.map.txt", `LIBFOO {
+  global:
+    foo;
+};
+
+LIBFOO_PRIVATE {
+  global:
+    foo_android;
+};
+`),
+	).RunTestWithBp(t, bp)
+
+	device := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared")
+	android.AssertIntEquals(t, "device validations", 1, len(device.Rule("ld").Validations))
+
+	// The host variant doesn't define the symbols of the device sources, so it isn't checked.
+	host := result.ModuleForTests("libfoo", result.Config.BuildOSTarget.String()+"_shared")
+	android.AssertIntEquals(t, "host validations", 0, len(host.Rule("ld").Validations))
+	if host.MaybeOutput("symbol_file_exports/libfoo.so.stamp").Rule != nil {
+		t.Errorf("expected no exports check for the host variant")
+	}
+}
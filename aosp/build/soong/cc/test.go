@@ -387,6 +387,11 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 		}
 	})
 
+	sanitize := ctx.Module().(*Module).sanitize
+	if sanitize != nil && sanitize.suppressions.Valid() {
+		test.data = append(test.data, android.DataPath{SrcPath: sanitize.suppressions.Path()})
+	}
+
 	var configs []tradefed.Config
 	for _, module := range test.Properties.Test_mainline_modules {
 		configs = append(configs, tradefed.Option{Name: "config-descriptor:metadata", Key: "mainline-param", Value: module})
@@ -441,6 +446,7 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 
 	test.testConfig = tradefed.AutoGenNativeTestConfig(ctx, test.Properties.Test_config,
 		test.Properties.Test_config_template, test.Properties.Test_suites, configs, test.Properties.Auto_gen_config, testInstallBase)
+	test.testConfig = sanitize.testConfig(ctx, test.testConfig)
 
 	test.extraTestConfigs = android.PathsForModuleSrc(ctx, test.Properties.Test_options.Extra_test_configs)
 
@@ -457,6 +463,14 @@ func (test *testBinary) install(ctx ModuleContext, file android.Path) {
 		test.Properties.Test_options.Unit_test = proptools.BoolPtr(true)
 	}
 	test.binaryDecorator.baseInstaller.install(ctx, file)
+	sanitize.installWindowsRuntime(ctx, test.binaryDecorator.baseInstaller.installDir(ctx))
+
+	if ctx.Host() {
+		if cov := ctx.Module().(*Module).coverage; cov != nil && cov.Properties.CoverageEnabled {
+			cov.buildHostCoverageReport(ctx, test.binaryDecorator.baseInstaller.path,
+				test.binaryDecorator.unstrippedOutputFile)
+		}
+	}
 }
 
 func NewTest(hod android.HostOrDeviceSupported) *Module {
@@ -584,6 +598,7 @@ func (benchmark *benchmarkDecorator) install(ctx ModuleContext, file android.Pat
 	benchmark.binaryDecorator.baseInstaller.dir = filepath.Join("benchmarktest", ctx.ModuleName())
 	benchmark.binaryDecorator.baseInstaller.dir64 = filepath.Join("benchmarktest64", ctx.ModuleName())
 	benchmark.binaryDecorator.baseInstaller.install(ctx, file)
+	benchmark.baseLinker.sanitize.installWindowsRuntime(ctx, benchmark.binaryDecorator.baseInstaller.installDir(ctx))
 }
 
 func NewBenchmark(hod android.HostOrDeviceSupported) *Module {
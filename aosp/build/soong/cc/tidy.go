@@ -15,9 +15,13 @@
 package cc
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"unicode"
 
+	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
 
 	"android/soong/android"
@@ -36,12 +40,48 @@ type TidyProperties struct {
 
 	// Checks that should be treated as errors.
 	Tidy_checks_as_errors []string
+
+	// Report of the clang-tidy diagnostics that are allowed in this module, as written to
+	// out/soong/tidy-reports by TIDY_EXPORT_FIXES=true m tidy-report-<module>. When set,
+	// clang-tidy doesn't fail on tidy_checks_as_errors, instead every diagnostic that is not in
+	// the baseline is an error.
+	Tidy_baseline *string `android:"path"`
+
+	// Whether to collect the fixes suggested by clang-tidy into a file that
+	// clang-apply-replacements can apply, built by m tidy-fix-<module>. Defaults to true if
+	// TIDY_EXPORT_FIXES is set in the environment.
+	Tidy_fix *bool
 }
 
 type tidyFeature struct {
 	Properties TidyProperties
+
+	baseline android.OptionalPath
+	fix      bool
 }
 
+func init() {
+	pctx.HostBinToolVariable("tidyReportCmd", "tidy_report")
+
+	android.RegisterSingletonType("tidy_report", tidyReportSingletonFactory)
+}
+
+var tidyReport = pctx.AndroidStaticRule("tidyReport",
+	blueprint.RuleParams{
+		Command:        "$tidyReportCmd --module $module --output $out $flags @${out}.rsp",
+		CommandDeps:    []string{"$tidyReportCmd"},
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "$in",
+	}, "module", "flags")
+
+var tidyFix = pctx.AndroidStaticRule("tidyFix",
+	blueprint.RuleParams{
+		Command:        "$tidyReportCmd --module $module --fixes-output $out @${out}.rsp",
+		CommandDeps:    []string{"$tidyReportCmd"},
+		Rspfile:        "${out}.rsp",
+		RspfileContent: "$in",
+	}, "module")
+
 var quotedFlagRegexp, _ = regexp.Compile(`^-?-[^=]+=('|").*('|")$`)
 
 // When passing flag -name=value, if user add quotes around 'value',
@@ -77,6 +117,24 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 
 	flags.Tidy = true
 
+	if listFile := ctx.Config().Getenv(envVariableTidyChangedFilesList); listFile != "" {
+		if err := getTidyChangedFiles(ctx.Config()).err; err != nil {
+			ctx.ModuleErrorf("failed to read %s: %s", envVariableTidyChangedFilesList, err)
+		}
+		ctx.AddNinjaFileDeps(listFile)
+	}
+
+	tidy.fix = BoolDefault(tidy.Properties.Tidy_fix, ctx.Config().IsEnvTrue("TIDY_EXPORT_FIXES"))
+
+	if tidy.Properties.Tidy_baseline != nil {
+		tidy.baseline = android.OptionalPathForPath(
+			android.PathForModuleSrc(ctx, *tidy.Properties.Tidy_baseline))
+	}
+
+	// The fixes are only exported when they are collected or the diagnostics are checked
+	// against a baseline.
+	flags.TidyExportFixes = tidy.fix || tidy.baseline.Valid()
+
 	// Add global WITH_TIDY_FLAGS and local tidy_flags.
 	withTidyFlags := ctx.Config().Getenv("WITH_TIDY_FLAGS")
 	if len(withTidyFlags) > 0 {
@@ -89,6 +147,13 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	// and with or without single or double quotes.
 	if !android.SubstringInList(flags.TidyFlags, "-header-filter=") {
 		defaultDirs := ctx.Config().Getenv("DEFAULT_TIDY_HEADER_DIRS")
+		// Add the header directories of the tidy config file for the module directory.
+		if dirs := config.TidyHeaderFilterDirsForDir(ctx, ctx.ModuleDir()); len(dirs) > 0 {
+			if defaultDirs != "" {
+				dirs = append([]string{defaultDirs}, dirs...)
+			}
+			defaultDirs = strings.Join(dirs, "|")
+		}
 		headerFilter := "-header-filter="
 		if defaultDirs == "" {
 			headerFilter += ctx.ModuleDir() + "/"
@@ -124,7 +189,7 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 	if checks := ctx.Config().TidyChecks(); len(checks) > 0 {
 		tidyChecks += checks
 	} else {
-		tidyChecks += config.TidyChecksForDir(ctx.ModuleDir())
+		tidyChecks += config.TidyChecksForDir(ctx, ctx.ModuleDir())
 	}
 	if len(tidy.Properties.Tidy_checks) > 0 {
 		tidyChecks = tidyChecks + "," + strings.Join(esc(ctx, "tidy_checks",
@@ -174,9 +239,204 @@ func (tidy *tidyFeature) flags(ctx ModuleContext, flags Flags) Flags {
 		if !inserted {
 			flags.TidyFlags = append(flags.TidyFlags, "-warnings-as-errors=-*")
 		}
-	} else if len(tidy.Properties.Tidy_checks_as_errors) > 0 {
-		tidyChecksAsErrors := "-warnings-as-errors=" + strings.Join(esc(ctx, "tidy_checks_as_errors", tidy.Properties.Tidy_checks_as_errors), ",")
-		flags.TidyFlags = append(flags.TidyFlags, tidyChecksAsErrors)
+	} else if !tidy.baseline.Valid() {
+		// With a tidy_baseline the diagnostics that are not in the baseline fail the tidy
+		// report instead.
+		checksAsErrors := append(android.CopyOf(config.TidyChecksAsErrorsForDir(ctx, ctx.ModuleDir())),
+			esc(ctx, "tidy_checks_as_errors", tidy.Properties.Tidy_checks_as_errors)...)
+		if len(checksAsErrors) > 0 {
+			tidyChecksAsErrors := "-warnings-as-errors=" + strings.Join(checksAsErrors, ",")
+			flags.TidyFlags = append(flags.TidyFlags, tidyChecksAsErrors)
+		}
 	}
 	return flags
 }
+
+func tidyReportSingletonFactory() android.Singleton {
+	return &tidyReportSingleton{}
+}
+
+// tidyReportSingleton merges the clang-tidy diagnostics of each module variant into a report at
+// out/soong/tidy-reports/<module dir>/<module>/<variant>.json, built by m tidy-report-<module>
+// and m tidy-report. clang-tidy only exports the diagnostics of modules with tidy_fix or
+// tidy_baseline, so only those modules have reports. For modules with a tidy_baseline the report is checked against the baseline
+// as part of checkbuild, so only diagnostics that are not in the baseline fail the build.
+//
+// For modules with tidy_fix the suggested fixes of all variants are merged and deduped into
+// out/soong/tidy-fixes/<module>/fixes.yaml, built by m tidy-fix-<module>, which is a directory
+// that clang-apply-replacements can consume.
+type tidyReportSingleton struct{}
+
+func (t *tidyReportSingleton) GenerateBuildActions(ctx android.SingletonContext) {
+	var allReports, baselineChecks android.Paths
+	moduleReports := make(map[string]android.Paths)
+	moduleFixes := make(map[string]android.Paths)
+
+	ctx.VisitAllModules(func(module android.Module) {
+		ccModule, ok := module.(*Module)
+		if !ok || !ccModule.Enabled() || len(ccModule.tidyYamlFiles) == 0 {
+			return
+		}
+
+		name := ccModule.Name()
+		report := android.PathForOutput(ctx, "tidy-reports", ctx.ModuleDir(module), name,
+			ctx.ModuleSubDir(module)+".json")
+
+		var implicits android.Paths
+		var implicitOutputs android.WritablePaths
+		flags := ""
+		if baseline := ccModule.tidyBaseline(); baseline.Valid() {
+			stamp := report.ReplaceExtension(ctx, "baseline.stamp")
+			implicits = append(implicits, baseline.Path())
+			implicitOutputs = append(implicitOutputs, stamp)
+			flags = "--baseline " + baseline.String() + " --stamp " + stamp.String()
+			baselineChecks = append(baselineChecks, stamp)
+		}
+
+		ctx.Build(pctx, android.BuildParams{
+			Rule:            tidyReport,
+			Description:     "clang-tidy report " + name,
+			Output:          report,
+			ImplicitOutputs: implicitOutputs,
+			Inputs:          ccModule.tidyYamlFiles,
+			Implicits:       implicits,
+			Args: map[string]string{
+				"module": name,
+				"flags":  flags,
+			},
+		})
+
+		allReports = append(allReports, report)
+		allReports = append(allReports, implicitOutputs.Paths()...)
+		moduleReports[name] = append(moduleReports[name], report)
+		moduleReports[name] = append(moduleReports[name], implicitOutputs.Paths()...)
+
+		if tidy := ccModule.tidyFeature(); tidy != nil && tidy.fix {
+			moduleFixes[name] = append(moduleFixes[name], ccModule.tidyYamlFiles...)
+		}
+	})
+
+	for _, name := range android.SortedStringKeys(moduleReports) {
+		ctx.Phony("tidy-report-"+name, moduleReports[name]...)
+	}
+
+	var allFixes android.Paths
+	for _, name := range android.SortedStringKeys(moduleFixes) {
+		fixesDir := android.PathForOutput(ctx, "tidy-fixes", name)
+		fixes := fixesDir.Join(ctx, "fixes.yaml")
+		// The description shows how to apply the fixes when they are regenerated.
+		ctx.Build(pctx, android.BuildParams{
+			Rule: tidyFix,
+			Description: "clang-tidy fixes " + name + ", apply with: clang-apply-replacements " +
+				"-format -style=file " + fixesDir.String(),
+			Output: fixes,
+			Inputs: moduleFixes[name],
+			Args: map[string]string{
+				"module": name,
+			},
+		})
+		ctx.Phony("tidy-fix-"+name, fixes)
+		allFixes = append(allFixes, fixes)
+	}
+	if len(allFixes) > 0 {
+		ctx.Phony("tidy-fix", allFixes...)
+	}
+	if len(allReports) > 0 {
+		ctx.Phony("tidy-report", allReports...)
+	}
+	if len(baselineChecks) > 0 {
+		ctx.Phony("checkbuild", baselineChecks...)
+	}
+}
+
+// tidyFeature returns the clang-tidy feature of the module, or nil.
+func (c *Module) tidyFeature() *tidyFeature {
+	for _, feature := range c.features {
+		if tidy, ok := feature.(*tidyFeature); ok {
+			return tidy
+		}
+	}
+	return nil
+}
+
+// tidyBaseline returns the baseline of the clang-tidy diagnostics of the module, if any.
+func (c *Module) tidyBaseline() android.OptionalPath {
+	if tidy := c.tidyFeature(); tidy != nil {
+		return tidy.baseline
+	}
+	return android.OptionalPath{}
+}
+
+const (
+	// Environment variables that enable incremental tidy, where clang-tidy only runs on the
+	// given changed files, e.g. those of a presubmit. TIDY_CHANGED_FILES is a list of paths
+	// relative to the top of the tree, TIDY_CHANGED_FILES_LIST the path of a file with one.
+	envVariableTidyChangedFiles     = "TIDY_CHANGED_FILES"
+	envVariableTidyChangedFilesList = "TIDY_CHANGED_FILES_LIST"
+)
+
+var tidyChangedFilesKey = android.NewOnceKey("TidyChangedFiles")
+
+type tidyChangedFiles struct {
+	// The changed files, nil if incremental tidy isn't enabled.
+	files map[string]bool
+	// The changed headers. Sources don't list the headers they include, so a changed
+	// header makes all sources in its module's directory changed.
+	headers []string
+	err     error
+}
+
+var tidyHeaderExts = []string{".h", ".hh", ".hpp", ".hxx", ".h++", ".inc", ".inl", ".ipp"}
+
+// parseTidyChangedFiles parses a comma or whitespace separated list of changed files.
+func parseTidyChangedFiles(list string) *tidyChangedFiles {
+	changed := &tidyChangedFiles{files: make(map[string]bool)}
+	for _, file := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	}) {
+		file = filepath.Clean(file)
+		changed.files[file] = true
+		if inList(filepath.Ext(file), tidyHeaderExts) {
+			changed.headers = append(changed.headers, file)
+		}
+	}
+	return changed
+}
+
+func getTidyChangedFiles(config android.Config) *tidyChangedFiles {
+	return config.Once(tidyChangedFilesKey, func() interface{} {
+		list := config.Getenv(envVariableTidyChangedFiles)
+		if listFile := config.Getenv(envVariableTidyChangedFilesList); listFile != "" {
+			if !filepath.IsAbs(listFile) {
+				listFile = filepath.Join(android.AbsSrcDirForExistingUseCases(), listFile)
+			}
+			contents, err := ioutil.ReadFile(listFile)
+			if err != nil {
+				return &tidyChangedFiles{err: err}
+			}
+			list += "\n" + string(contents)
+		} else if list == "" {
+			return &tidyChangedFiles{}
+		}
+		return parseTidyChangedFiles(list)
+	}).(*tidyChangedFiles)
+}
+
+// includes returns true if the source file in the given module directory is changed.
+func (changed *tidyChangedFiles) includes(srcFile, moduleDir string) bool {
+	if changed.files == nil || changed.files[srcFile] {
+		return true
+	}
+	for _, header := range changed.headers {
+		if strings.HasPrefix(header, moduleDir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// tidyChangedFile returns true if clang-tidy should check the source file. This is always the
+// case unless incremental tidy is enabled with TIDY_CHANGED_FILES or TIDY_CHANGED_FILES_LIST.
+func tidyChangedFile(ctx android.ModuleContext, srcFile android.Path) bool {
+	return getTidyChangedFiles(ctx.Config()).includes(srcFile.String(), ctx.ModuleDir())
+}
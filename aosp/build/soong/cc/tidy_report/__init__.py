@@ -0,0 +1,311 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Merges clang-tidy diagnostics of a module and checks them against a baseline.
+
+The inputs are the files written by clang-tidy -export-fixes for each source
+file of a module. The diagnostics are merged into a single JSON report. If a
+baseline report is given, every diagnostic that isn't in the baseline is
+printed and the tool fails.
+
+The suggested fixes can also be merged into a single file that
+clang-apply-replacements can consume. Fixes that are reported for more than one
+source file or variant, e.g. in a header, are only kept once.
+"""
+import argparse
+from collections import Counter
+from dataclasses import dataclass
+import json
+import os
+from pathlib import Path
+import re
+import sys
+from typing import Any, Dict, Iterable, List, Optional, TextIO, Tuple
+
+
+@dataclass(frozen=True, order=True)
+class Diagnostic:
+    """A single clang-tidy diagnostic."""
+    file: str
+    offset: int
+    check: str
+    level: str
+    message: str
+
+    def baseline_key(self) -> Tuple[str, str, str]:
+        """Returns the key used to match diagnostics against the baseline.
+
+        The offset is left out so that unrelated edits to a file don't make
+        the diagnostics of the file new.
+        """
+        return (self.file, self.check, self.message)
+
+    def to_json(self) -> Dict[str, Any]:
+        """Returns the representation of the diagnostic in a report."""
+        return {
+            'file': self.file,
+            'offset': self.offset,
+            'check': self.check,
+            'level': self.level,
+            'message': self.message,
+        }
+
+    @staticmethod
+    def from_json(obj: Dict[str, Any]) -> 'Diagnostic':
+        """Reads a diagnostic from a report."""
+        return Diagnostic(file=obj['file'],
+                          offset=obj.get('offset', 0),
+                          check=obj['check'],
+                          level=obj.get('level', 'Warning'),
+                          message=obj['message'])
+
+    def __str__(self) -> str:
+        return f'{self.file}:{self.offset}: {self.message} [{self.check}]'
+
+
+KEY_VALUE_RE = re.compile(r'^(\s*)(- )?([A-Za-z]+):\s*(.*)$')
+
+
+def decode_scalar(value: str) -> str:
+    """Decodes a YAML scalar as written by clang-tidy."""
+    if len(value) >= 2 and value[0] == "'" and value[-1] == "'":
+        return value[1:-1].replace("''", "'")
+    if len(value) >= 2 and value[0] == '"' and value[-1] == '"':
+        try:
+            return json.loads(value)
+        except ValueError:
+            return value[1:-1]
+    return value
+
+
+def normalize_path(path: str) -> str:
+    """Makes paths inside the source tree relative to the top of the tree."""
+    if os.path.isabs(path):
+        top = os.getcwd() + os.sep
+        if path.startswith(top):
+            return path[len(top):]
+    return os.path.normpath(path)
+
+
+def parse_export_fixes(export_file: TextIO) -> List[Diagnostic]:
+    """Parses the diagnostics from the output of clang-tidy -export-fixes.
+
+    This handles the subset of YAML that clang-tidy writes. Both the current
+    format, that nests the message in a DiagnosticMessage, and the older flat
+    format are supported. The first Message, FilePath and FileOffset of a
+    diagnostic belong to the diagnostic itself, later ones to its
+    replacements and notes.
+    """
+    diagnostics = []
+    current: Optional[Dict[str, str]] = None
+
+    def finish() -> None:
+        if current is not None and 'Message' in current:
+            diagnostics.append(
+                Diagnostic(file=normalize_path(current.get('FilePath', '')),
+                           offset=int(current.get('FileOffset', '0')),
+                           check=current['DiagnosticName'],
+                           level=current.get('Level', 'Warning'),
+                           message=current['Message']))
+
+    for line in export_file:
+        match = KEY_VALUE_RE.match(line.rstrip('\n'))
+        if match is None:
+            continue
+        key = match.group(3)
+        value = decode_scalar(match.group(4).strip())
+        if key == 'DiagnosticName':
+            finish()
+            current = {key: value}
+        elif current is not None and key not in current:
+            current[key] = value
+    finish()
+    return diagnostics
+
+
+def split_diagnostic_blocks(export_file: TextIO) -> List[str]:
+    """Returns the YAML text of each diagnostic from clang-tidy -export-fixes."""
+    blocks = []
+    block: List[str] = []
+    indent: Optional[int] = None
+    for line in export_file:
+        match = KEY_VALUE_RE.match(line.rstrip('\n'))
+        if match is not None and match.group(3) == 'DiagnosticName':
+            if block:
+                blocks.append(''.join(block))
+            block = [line]
+            indent = len(match.group(1))
+        elif block and indent is not None and (
+                line.startswith(' ' * (indent + 1)) or not line.strip()):
+            block.append(line)
+        elif block:
+            blocks.append(''.join(block))
+            block = []
+    if block:
+        blocks.append(''.join(block))
+    return blocks
+
+
+def has_replacements(block: str) -> bool:
+    """Returns true if a diagnostic suggests at least one replacement."""
+    for line in block.splitlines():
+        match = KEY_VALUE_RE.match(line)
+        if (match is not None and match.group(3) == 'Replacements'
+                and match.group(4).strip() != '[]'):
+            return True
+    return False
+
+
+def merge_fixes(blocks: Iterable[str]) -> str:
+    """Merges and dedupes diagnostics with fixes into a single YAML file."""
+    fixes = []
+    seen = set()
+    for block in blocks:
+        if has_replacements(block) and block not in seen:
+            seen.add(block)
+            fixes.append(block if block.endswith('\n') else block + '\n')
+    if not fixes:
+        return "---\nMainSourceFile: ''\nDiagnostics: []\n...\n"
+    return ("---\nMainSourceFile: ''\nDiagnostics:\n" + ''.join(fixes) +
+            '...\n')
+
+
+def merge(diagnostics: Iterable[Diagnostic]) -> List[Diagnostic]:
+    """Dedupes diagnostics reported by several sources of a module."""
+    return sorted(set(diagnostics))
+
+
+def new_diagnostics(diagnostics: List[Diagnostic],
+                    baseline: List[Diagnostic]) -> List[Diagnostic]:
+    """Returns the diagnostics that aren't covered by the baseline.
+
+    A baseline entry covers one diagnostic, so a new occurrence of an already
+    known diagnostic in the same file is still reported.
+    """
+    known = Counter(d.baseline_key() for d in baseline)
+    new = []
+    for diagnostic in diagnostics:
+        key = diagnostic.baseline_key()
+        if known[key] > 0:
+            known[key] -= 1
+        else:
+            new.append(diagnostic)
+    return new
+
+
+def read_report(report_file: TextIO) -> List[Diagnostic]:
+    """Reads the diagnostics of a report, e.g. a checked-in baseline."""
+    report = json.load(report_file)
+    return [Diagnostic.from_json(d) for d in report.get('diagnostics', [])]
+
+
+def write_report(report_file: TextIO, module: str,
+                 diagnostics: List[Diagnostic]) -> None:
+    """Writes the merged diagnostics of a module."""
+    json.dump(
+        {
+            'module': module,
+            'diagnostics': [d.to_json() for d in diagnostics],
+        },
+        report_file,
+        indent=2,
+        sort_keys=True)
+    report_file.write('\n')
+
+
+def expand_inputs(inputs: List[str]) -> List[Path]:
+    """Expands @file arguments to the whitespace separated list in file."""
+    paths = []
+    for arg in inputs:
+        if arg.startswith('@'):
+            with open(arg[1:]) as rsp_file:
+                paths.extend(Path(p) for p in rsp_file.read().split())
+        else:
+            paths.append(Path(arg))
+    return paths
+
+
+def parse_args() -> argparse.Namespace:
+    """Parses and returns command line arguments."""
+    parser = argparse.ArgumentParser()
+
+    parser.add_argument('--module', required=True, help='Name of the module.')
+    parser.add_argument('--output',
+                        type=Path,
+                        help='Path to write the merged report to.')
+    parser.add_argument(
+        '--fixes-output',
+        type=Path,
+        help='Path to write the merged fixes for clang-apply-replacements to.')
+    parser.add_argument('--baseline',
+                        type=Path,
+                        help='Report with the diagnostics that are allowed.')
+    parser.add_argument('--stamp',
+                        type=Path,
+                        help='File to touch if there are no new diagnostics.')
+    parser.add_argument(
+        'inputs',
+        nargs='*',
+        help='Files written by clang-tidy -export-fixes, or @file lists.')
+
+    return parser.parse_args()
+
+
+def main() -> None:
+    """Program entry point."""
+    args = parse_args()
+    if args.output is None and args.fixes_output is None:
+        sys.exit('error: one of --output or --fixes-output is required')
+
+    inputs = expand_inputs(args.inputs)
+
+    if args.fixes_output is not None:
+        blocks: List[str] = []
+        for path in inputs:
+            with path.open() as export_file:
+                blocks.extend(split_diagnostic_blocks(export_file))
+        with args.fixes_output.open('w') as fixes_file:
+            fixes_file.write(merge_fixes(blocks))
+
+    if args.output is None:
+        return
+
+    diagnostics: List[Diagnostic] = []
+    for path in inputs:
+        with path.open() as export_file:
+            diagnostics.extend(parse_export_fixes(export_file))
+    diagnostics = merge(diagnostics)
+
+    with args.output.open('w') as report_file:
+        write_report(report_file, args.module, diagnostics)
+
+    if args.baseline is not None:
+        with args.baseline.open() as baseline_file:
+            baseline = read_report(baseline_file)
+        new = new_diagnostics(diagnostics, baseline)
+        if new:
+            for diagnostic in new:
+                print(diagnostic, file=sys.stderr)
+            sys.exit(f'error: {len(new)} new clang-tidy diagnostic(s) in '
+                     f'{args.module} that are not in {args.baseline}. Fix '
+                     f'them, or update the baseline with {args.output}.')
+
+    if args.stamp is not None:
+        args.stamp.touch()
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,183 @@
+#!/usr/bin/env python
+#
+# Copyright (C) 2021 The Android Open Source Project
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#      http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+#
+"""Tests for tidy_report."""
+import io
+import textwrap
+import unittest
+
+import tidy_report
+from tidy_report import Diagnostic
+
+# pylint: disable=missing-docstring
+
+
+class ParseExportFixesTest(unittest.TestCase):
+    def test_nested_format(self) -> None:
+        export_file = io.StringIO(
+            textwrap.dedent("""\
+            ---
+            MainSourceFile:  'frameworks/foo/foo.cpp'
+            Diagnostics:
+              - DiagnosticName:  google-explicit-constructor
+                DiagnosticMessage:
+                  Message:         single-argument constructors must be marked explicit
+                  FilePath:        'frameworks/foo/foo.h'
+                  FileOffset:      120
+                  Replacements:
+                    - FilePath:        'frameworks/foo/foo.h'
+                      Offset:          120
+                      Length:          0
+                      ReplacementText: 'explicit '
+                Level:           Warning
+                BuildDirectory:  '/tmp'
+              - DiagnosticName:  bugprone-use-after-move
+                DiagnosticMessage:
+                  Message:         '''x'' used after it was moved'
+                  FilePath:        'frameworks/foo/foo.cpp'
+                  FileOffset:      42
+                  Replacements:    []
+                Notes:
+                  - Message:         move occurred here
+                    FilePath:        'frameworks/foo/foo.cpp'
+                    FileOffset:      30
+                Level:           Error
+            ...
+            """))
+        self.assertEqual([
+            Diagnostic(file='frameworks/foo/foo.h',
+                       offset=120,
+                       check='google-explicit-constructor',
+                       level='Warning',
+                       message='single-argument constructors must be marked '
+                       'explicit'),
+            Diagnostic(file='frameworks/foo/foo.cpp',
+                       offset=42,
+                       check='bugprone-use-after-move',
+                       level='Error',
+                       message="'x' used after it was moved"),
+        ], tidy_report.parse_export_fixes(export_file))
+
+    def test_flat_format(self) -> None:
+        export_file = io.StringIO(
+            textwrap.dedent("""\
+            ---
+            MainSourceFile:  foo.cpp
+            Diagnostics:
+              - DiagnosticName:  misc-unused-using-decls
+                Message:         "using decl 'bar' is unused"
+                FileOffset:      7
+                FilePath:        foo.cpp
+                Replacements:    []
+            ...
+            """))
+        self.assertEqual([
+            Diagnostic(file='foo.cpp',
+                       offset=7,
+                       check='misc-unused-using-decls',
+                       level='Warning',
+                       message="using decl 'bar' is unused"),
+        ], tidy_report.parse_export_fixes(export_file))
+
+    def test_empty(self) -> None:
+        self.assertEqual([],
+                         tidy_report.parse_export_fixes(io.StringIO('')))
+
+
+class MergeFixesTest(unittest.TestCase):
+    EXPORT = textwrap.dedent("""\
+        ---
+        MainSourceFile:  'foo.cpp'
+        Diagnostics:
+          - DiagnosticName:  google-explicit-constructor
+            DiagnosticMessage:
+              Message:         single-argument constructors must be marked explicit
+              FilePath:        'foo.h'
+              FileOffset:      120
+              Replacements:
+                - FilePath:        'foo.h'
+                  Offset:          120
+                  Length:          0
+                  ReplacementText: 'explicit '
+            Level:           Warning
+          - DiagnosticName:  misc-unused-parameters
+            DiagnosticMessage:
+              Message:         parameter 'x' is unused
+              FilePath:        'foo.cpp'
+              FileOffset:      42
+              Replacements:    []
+            Level:           Warning
+        ...
+        """)
+
+    def test_split(self) -> None:
+        blocks = tidy_report.split_diagnostic_blocks(io.StringIO(self.EXPORT))
+        self.assertEqual(2, len(blocks))
+        self.assertTrue(blocks[0].lstrip().startswith(
+            '- DiagnosticName:  google-explicit-constructor'))
+        self.assertTrue(tidy_report.has_replacements(blocks[0]))
+        self.assertFalse(tidy_report.has_replacements(blocks[1]))
+
+    def test_merge_dedupes_headers(self) -> None:
+        # The header fix is reported by both sources that include it.
+        blocks = (
+            tidy_report.split_diagnostic_blocks(io.StringIO(self.EXPORT)) +
+            tidy_report.split_diagnostic_blocks(io.StringIO(self.EXPORT)))
+        merged = tidy_report.merge_fixes(blocks)
+        self.assertEqual(1, merged.count('DiagnosticName'))
+        self.assertTrue(merged.startswith('---\n'))
+        self.assertTrue(merged.endswith('...\n'))
+        self.assertEqual(
+            tidy_report.parse_export_fixes(io.StringIO(self.EXPORT))[:1],
+            tidy_report.parse_export_fixes(io.StringIO(merged)))
+
+    def test_merge_empty(self) -> None:
+        self.assertEqual([],
+                         tidy_report.parse_export_fixes(
+                             io.StringIO(tidy_report.merge_fixes([]))))
+
+
+class BaselineTest(unittest.TestCase):
+    def test_new_diagnostics(self) -> None:
+        old = Diagnostic('foo.cpp', 10, 'misc-a', 'Warning', 'a')
+        moved = Diagnostic('foo.cpp', 20, 'misc-a', 'Warning', 'a')
+        new = Diagnostic('foo.cpp', 30, 'misc-b', 'Warning', 'b')
+        self.assertEqual([new],
+                         tidy_report.new_diagnostics([moved, new], [old]))
+        self.assertEqual([moved],
+                         tidy_report.new_diagnostics([old, moved], [old]))
+        self.assertEqual([], tidy_report.new_diagnostics([], [old]))
+
+    def test_report_round_trip(self) -> None:
+        diagnostics = tidy_report.merge([
+            Diagnostic('foo.h', 10, 'misc-a', 'Warning', 'a'),
+            Diagnostic('bar.cpp', 5, 'misc-b', 'Warning', 'b'),
+            Diagnostic('foo.h', 10, 'misc-a', 'Warning', 'a'),
+        ])
+        self.assertEqual(2, len(diagnostics))
+        report_file = io.StringIO()
+        tidy_report.write_report(report_file, 'libfoo', diagnostics)
+        report_file.seek(0)
+        self.assertEqual(diagnostics, tidy_report.read_report(report_file))
+
+
+def main() -> None:
+    suite = unittest.TestLoader().loadTestsFromName(__name__)
+    unittest.TextTestRunner(verbosity=3).run(suite)
+
+
+if __name__ == '__main__':
+    main()
@@ -0,0 +1,45 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+)
+
+func TestTidyChangedFiles(t *testing.T) {
+	changed := parseTidyChangedFiles("tools/aapt2/Main.cpp,\n./tools/aapt2/link/Linker.cpp libs/androidfw/include/androidfw/Util.h\n")
+
+	testCases := []struct {
+		srcFile, moduleDir string
+		want               bool
+	}{
+		{"tools/aapt2/Main.cpp", "tools/aapt2", true},
+		{"tools/aapt2/link/Linker.cpp", "tools/aapt2", true},
+		{"tools/aapt2/Debug.cpp", "tools/aapt2", false},
+		// A changed header changes all sources in its module's directory.
+		{"libs/androidfw/Util.cpp", "libs/androidfw", true},
+		{"libs/androidfwx/Util.cpp", "libs/androidfwx", false},
+	}
+	for _, tc := range testCases {
+		if got := changed.includes(tc.srcFile, tc.moduleDir); got != tc.want {
+			t.Errorf("includes(%q, %q) = %t, want %t", tc.srcFile, tc.moduleDir, got, tc.want)
+		}
+	}
+
+	// Without a list of changed files all files are checked.
+	if !(&tidyChangedFiles{}).includes("tools/aapt2/Debug.cpp", "tools/aapt2") {
+		t.Errorf("expected all files to be included when incremental tidy is disabled")
+	}
+}
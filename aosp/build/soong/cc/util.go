@@ -76,17 +76,24 @@ func flagsToBuilderFlags(in Flags) builderFlags {
 		localCppFlags:        strings.Join(in.Local.CppFlags, " "),
 		localLdFlags:         strings.Join(in.Local.LdFlags, " "),
 
-		aidlFlags:     strings.Join(in.aidlFlags, " "),
-		rsFlags:       strings.Join(in.rsFlags, " "),
-		libFlags:      strings.Join(in.libFlags, " "),
-		extraLibFlags: strings.Join(in.extraLibFlags, " "),
-		tidyFlags:     strings.Join(in.TidyFlags, " "),
-		sAbiFlags:     strings.Join(in.SAbiFlags, " "),
-		toolchain:     in.Toolchain,
-		gcovCoverage:  in.GcovCoverage,
-		tidy:          in.Tidy,
-		sAbiDump:      in.SAbiDump,
-		emitXrefs:     in.EmitXrefs,
+		aidlFlags:       strings.Join(in.aidlFlags, " "),
+		rsFlags:         strings.Join(in.rsFlags, " "),
+		libFlags:        strings.Join(in.libFlags, " "),
+		extraLibFlags:   strings.Join(in.extraLibFlags, " "),
+		tidyFlags:       strings.Join(in.TidyFlags, " "),
+		sAbiFlags:       strings.Join(in.SAbiFlags, " "),
+		toolchain:       in.Toolchain,
+		gcovCoverage:    in.GcovCoverage,
+		tidy:            in.Tidy,
+		tidyExportFixes: in.TidyExportFixes,
+		sAbiDump:        in.SAbiDump,
+		emitXrefs:       in.EmitXrefs,
+
+		distributedThinLTO: in.DistributedThinLTO,
+
+		coverageFlags:       strings.Join(in.CoverageFlags, " "),
+		coverageSrcs:        in.CoverageSrcs,
+		coverageExcludeSrcs: in.CoverageExcludeSrcs,
 
 		systemIncludeFlags: strings.Join(in.SystemIncludeFlags, " "),
 